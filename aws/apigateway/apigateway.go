@@ -27,6 +27,7 @@ func ProxyHandler(handler http.Handler) func(events.APIGatewayProxyRequest) (eve
 			RequestURI: ar.RequestContext.Path,
 			Proto:      ar.RequestContext.Protocol,
 			Header:     multiValueHeader(ar.Headers),
+			RemoteAddr: ar.RequestContext.Identity.SourceIP,
 		}
 
 		if ar.Body != "" && ar.IsBase64Encoded {
@@ -85,6 +86,7 @@ func HTTPHandler(handler http.Handler) func(events.APIGatewayV2HTTPRequest) (eve
 			RequestURI: ar.RequestContext.HTTP.Path,
 			Proto:      ar.RequestContext.HTTP.Protocol,
 			Header:     multiValueHeader(ar.Headers),
+			RemoteAddr: ar.RequestContext.HTTP.SourceIP,
 		}
 
 		if ar.Body != "" && ar.IsBase64Encoded {
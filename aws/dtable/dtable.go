@@ -7,13 +7,21 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/jimmysawczuk/kit/retry"
+	"github.com/jimmysawczuk/kit/tracing"
 	"github.com/rs/zerolog"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Table struct {
 	conn *dynamodb.DynamoDB
 
 	Name string
+
+	// Retry, if set, retries operations that fail with transient errors. If its Retryable is nil,
+	// retry.AWS is used, so throttled requests are retried but failed conditions aren't.
+	Retry *retry.Policy
 }
 
 func New(conn *dynamodb.DynamoDB, name string) *Table {
@@ -42,18 +50,59 @@ func debug(ctx context.Context, table, name string, in any) {
 	}
 }
 
+// retry runs fn under the Table's retry policy, if it has one.
+func (t *Table) retry(ctx context.Context, fn func(context.Context) error) error {
+	if t.Retry == nil {
+		return fn(ctx)
+	}
+
+	p := *t.Retry
+	if p.Retryable == nil {
+		p.Retryable = retry.AWS
+	}
+	return p.Do(ctx, fn)
+}
+
+// startSpan starts a client span for the DynamoDB operation op, if ctx is being traced.
+func (t *Table) startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "DynamoDB."+op, trace.SpanKindClient,
+		semconv.RPCSystemKey.String("aws-api"),
+		semconv.RPCService("DynamoDB"),
+		semconv.RPCMethod(op),
+		semconv.AWSDynamoDBTableNames(t.Name),
+	)
+}
+
 func (t *Table) PutItem(ctx context.Context, in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
 	debug(ctx, t.Name, "PutItem", in)
 
+	ctx, span := t.startSpan(ctx, "PutItem")
+	defer span.End()
+
 	in.TableName = aws.String(t.Name)
-	return t.conn.PutItemWithContext(ctx, in)
+	var out *dynamodb.PutItemOutput
+	err := t.retry(ctx, func(ctx context.Context) (err error) {
+		out, err = t.conn.PutItemWithContext(ctx, in)
+		return err
+	})
+	tracing.RecordError(span, err)
+	return out, err
 }
 
 func (t *Table) GetItem(ctx context.Context, in *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
 	debug(ctx, t.Name, "PutItem", in)
 
+	ctx, span := t.startSpan(ctx, "GetItem")
+	defer span.End()
+
 	in.TableName = aws.String(t.Name)
-	return t.conn.GetItemWithContext(ctx, in)
+	var out *dynamodb.GetItemOutput
+	err := t.retry(ctx, func(ctx context.Context) (err error) {
+		out, err = t.conn.GetItemWithContext(ctx, in)
+		return err
+	})
+	tracing.RecordError(span, err)
+	return out, err
 }
 
 type BatchGetItemInput struct {
@@ -93,8 +142,16 @@ func (t *Table) BatchGetItem(ctx context.Context, in *BatchGetItemInput) (*Batch
 		ReturnConsumedCapacity: in.ReturnConsumedCapacity,
 	}
 
-	dout, err := t.conn.BatchGetItemWithContext(ctx, &din)
+	ctx, span := t.startSpan(ctx, "BatchGetItem")
+	defer span.End()
+
+	var dout *dynamodb.BatchGetItemOutput
+	err := t.retry(ctx, func(ctx context.Context) (err error) {
+		dout, err = t.conn.BatchGetItemWithContext(ctx, &din)
+		return err
+	})
 	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("batch get item with context: %w", err)
 	}
 
@@ -108,20 +165,47 @@ func (t *Table) BatchGetItem(ctx context.Context, in *BatchGetItemInput) (*Batch
 func (t *Table) UpdateItem(ctx context.Context, in *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
 	debug(ctx, t.Name, "UpdateItem", in)
 
+	ctx, span := t.startSpan(ctx, "UpdateItem")
+	defer span.End()
+
 	in.TableName = aws.String(t.Name)
-	return t.conn.UpdateItemWithContext(ctx, in)
+	var out *dynamodb.UpdateItemOutput
+	err := t.retry(ctx, func(ctx context.Context) (err error) {
+		out, err = t.conn.UpdateItemWithContext(ctx, in)
+		return err
+	})
+	tracing.RecordError(span, err)
+	return out, err
 }
 
 func (t *Table) DeleteItem(ctx context.Context, in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
 	debug(ctx, t.Name, "DeleteItem", in)
 
+	ctx, span := t.startSpan(ctx, "DeleteItem")
+	defer span.End()
+
 	in.TableName = aws.String(t.Name)
-	return t.conn.DeleteItemWithContext(ctx, in)
+	var out *dynamodb.DeleteItemOutput
+	err := t.retry(ctx, func(ctx context.Context) (err error) {
+		out, err = t.conn.DeleteItemWithContext(ctx, in)
+		return err
+	})
+	tracing.RecordError(span, err)
+	return out, err
 }
 
 func (t *Table) Query(ctx context.Context, in *dynamodb.QueryInput) (*dynamodb.QueryOutput, error) {
 	debug(ctx, t.Name, "Query", in)
 
+	ctx, span := t.startSpan(ctx, "Query")
+	defer span.End()
+
 	in.TableName = aws.String(t.Name)
-	return t.conn.QueryWithContext(ctx, in)
+	var out *dynamodb.QueryOutput
+	err := t.retry(ctx, func(ctx context.Context) (err error) {
+		out, err = t.conn.QueryWithContext(ctx, in)
+		return err
+	})
+	tracing.RecordError(span, err)
+	return out, err
 }
@@ -0,0 +1,81 @@
+package dtable
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Schema names the key and TTL attributes of a table that's shared by several kinds of items, like the
+// DynamoStores in kit's web packages. Each kind is written under its own partition key prefix.
+type Schema struct {
+	// PartitionKey is the name of the table's partition key attribute. Defaults to "pk".
+	PartitionKey string
+
+	// SortKey is the name of the table's sort key attribute, if it has one. Items are written with their
+	// kind as the sort key.
+	SortKey string
+
+	// TTLAttribute is the name of the attribute holding an item's expiry, in Unix seconds. Defaults to "ttl".
+	TTLAttribute string
+}
+
+// PartitionKeyName returns the name of the partition key attribute.
+func (s Schema) PartitionKeyName() string {
+	if s.PartitionKey == "" {
+		return "pk"
+	}
+	return s.PartitionKey
+}
+
+// TTLAttributeName returns the name of the TTL attribute.
+func (s Schema) TTLAttributeName() string {
+	if s.TTLAttribute == "" {
+		return "ttl"
+	}
+	return s.TTLAttribute
+}
+
+// ItemKey returns the key of the item of kind with id: a partition key of "kind#id" and, if the table has
+// a sort key, a sort key of kind.
+func (s Schema) ItemKey(kind, id string) map[string]*dynamodb.AttributeValue {
+	tbr := map[string]*dynamodb.AttributeValue{
+		s.PartitionKeyName(): {S: aws.String(kind + "#" + id)},
+	}
+	if s.SortKey != "" {
+		tbr[s.SortKey] = &dynamodb.AttributeValue{S: aws.String(kind)}
+	}
+	return tbr
+}
+
+// Expired reports whether item's TTL has passed as of now. Items without a TTL don't expire.
+func (s Schema) Expired(item map[string]*dynamodb.AttributeValue, now time.Time) (bool, error) {
+	// DynamoDB deletes expired items lazily, so reads can still return them.
+	av := item[s.TTLAttributeName()]
+	if av == nil || av.N == nil {
+		return false, nil
+	}
+
+	ttl, err := strconv.ParseInt(*av.N, 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("strconv: parse int (attribute: %s): %w", s.TTLAttributeName(), err)
+	}
+
+	return ttl < now.Unix(), nil
+}
+
+// Unix returns t as a number attribute of Unix seconds, the format DynamoDB's TTL expects.
+func Unix(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.Unix(), 10))}
+}
+
+// ConditionFailed reports whether err is from a write whose condition expression wasn't met.
+func ConditionFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
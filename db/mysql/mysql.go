@@ -4,12 +4,19 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
 	"os"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	"github.com/go-sql-driver/mysql"
+	"github.com/jimmysawczuk/kit/retry"
 	"github.com/jmoiron/sqlx"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type silentLogger struct{}
@@ -30,6 +37,10 @@ type Config struct {
 
 	MaxOpenConns int `envconfig:"MAX_OPEN_CONNS" default:"10"`
 	MaxIdleConns int `envconfig:"MAX_IDLE_CONNS" default:"10"`
+
+	// Trace records a span for each statement run with a context that's being traced, using otelsql and the
+	// global TracerProvider.
+	Trace bool `envconfig:"TRACE"`
 }
 
 func (c Config) addr() string {
@@ -89,11 +100,28 @@ func Open(c Config) (*sqlx.DB, error) {
 
 	mysql.SetLogger(silentLogger{})
 
-	db, err := sqlx.Open("mysql", cfg.FormatDSN())
+	connector, err := mysql.NewConnector(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("sqlx: open: %w", err)
+		return nil, fmt.Errorf("mysql: new connector: %w", err)
+	}
+
+	sqlDB := sql.OpenDB(connector)
+	if c.Trace {
+		sqlDB = otelsql.OpenDB(connector,
+			otelsql.WithAttributes(semconv.DBSystemMySQL, semconv.DBNamespace(c.DB)),
+			otelsql.WithSpanOptions(otelsql.SpanOptions{
+				// Work outside of a trace, i.e. the pool's own connection management, isn't recorded.
+				SpanFilter: func(ctx context.Context, _ otelsql.Method, _ string, _ []driver.NamedValue) bool {
+					return trace.SpanContextFromContext(ctx).IsValid()
+				},
+				OmitConnResetSession: true,
+				OmitRows:             true,
+			}),
+		)
 	}
 
+	db := sqlx.NewDb(sqlDB, "mysql")
+
 	db.SetMaxOpenConns(c.MaxOpenConns)
 	db.SetMaxIdleConns(c.MaxIdleConns)
 
@@ -107,3 +135,55 @@ func Open(c Config) (*sqlx.DB, error) {
 
 	return db, nil
 }
+
+// commitError marks an error returned by Commit.
+type commitError struct {
+	err error
+}
+
+func (e *commitError) Error() string {
+	return "sqlx: commit: " + e.err.Error()
+}
+
+func (e *commitError) Unwrap() error {
+	return e.err
+}
+
+// RetryTx runs fn in a transaction, committing if it returns nil and rolling back otherwise. If the
+// transaction fails with an error p considers retryable, the whole transaction is run again, since MySQL
+// rolls back everything on a deadlock. If p's Retryable is nil, retry.MySQL is used.
+//
+// Commit errors are only retried if they're deadlocks or lock wait timeouts. A commit that loses its
+// connection may have succeeded, so running the transaction again could apply it twice.
+func RetryTx(ctx context.Context, db *sqlx.DB, p retry.Policy, fn func(ctx context.Context, tx *sqlx.Tx) error) error {
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = retry.MySQL
+	}
+
+	p.Retryable = func(err error) bool {
+		var cerr *commitError
+		if errors.As(err, &cerr) {
+			return retry.MySQLLock(err) && retryable(err)
+		}
+		return retryable(err)
+	}
+
+	return p.Do(ctx, func(ctx context.Context) error {
+		tx, err := db.BeginTxx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("sqlx: begin: %w", err)
+		}
+
+		if err := fn(ctx, tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return &commitError{err: err}
+		}
+
+		return nil
+	})
+}
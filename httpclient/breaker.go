@@ -0,0 +1,98 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jimmysawczuk/kit/resilience"
+)
+
+// ErrCircuitOpen is returned, wrapped with the host, for calls to a host whose circuit breaker is open.
+var ErrCircuitOpen = resilience.ErrCircuitOpen
+
+// errFailedResponse marks a response that IsFailure counted against its host.
+var errFailedResponse = errors.New("httpclient: failed response")
+
+// BreakerOptions configures Breaker.
+type BreakerOptions struct {
+	// Threshold is how many consecutive failures open a host's circuit. Defaults to 5.
+	Threshold int
+
+	// Cooldown is how long a circuit stays open before a single trial call is let through. If it
+	// succeeds the circuit closes; otherwise it opens for another Cooldown. Defaults to 30 seconds.
+	Cooldown time.Duration
+
+	// IsFailure decides whether a call counts against the circuit. Defaults to network errors and 5xx
+	// responses. Calls the caller cancelled never count.
+	IsFailure func(resp *http.Response, err error) bool
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+func isFailure(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= 500
+}
+
+// Breaker stops calling hosts that keep failing, so a struggling dependency isn't buried under retries
+// and callers fail fast. Each host has its own resilience.Breaker, named after the host, which logs its
+// state changes to the logger on the request's context.
+func Breaker(opts BreakerOptions) Middleware {
+	if opts.IsFailure == nil {
+		opts.IsFailure = isFailure
+	}
+
+	var (
+		mu       sync.Mutex
+		breakers = map[string]*resilience.Breaker{}
+	)
+
+	breaker := func(host string) *resilience.Breaker {
+		mu.Lock()
+		defer mu.Unlock()
+
+		b, ok := breakers[host]
+		if !ok {
+			b = resilience.NewBreaker(resilience.BreakerOptions{
+				Name:             host,
+				FailureThreshold: opts.Threshold,
+				OpenTimeout:      opts.Cooldown,
+				IsFailure: func(err error) bool {
+					if errors.Is(err, errFailedResponse) {
+						return true
+					}
+					return err != nil && !errors.Is(err, context.Canceled) && opts.IsFailure(nil, err)
+				},
+				Now: opts.Now,
+			})
+			breakers[host] = b
+		}
+		return b
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			var resp *http.Response
+
+			err := breaker(r.URL.Host).Do(r.Context(), func(ctx context.Context) error {
+				var err error
+				resp, err = next.RoundTrip(r)
+				if err == nil && opts.IsFailure(resp, nil) {
+					return errFailedResponse
+				}
+				return err
+			})
+			if errors.Is(err, errFailedResponse) {
+				return resp, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			return resp, nil
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/jimmysawczuk/kit/web/respond"
+)
+
+// maxErrorBody is the most of an error response's body that's read.
+const maxErrorBody = 1 << 20
+
+// Error is an error response from a service. Responses written with respond.CodedError carry their code,
+// message, request ID and info; for anything else, Message is the start of the body.
+type Error struct {
+	Status    int
+	Code      string
+	Message   string
+	RequestID string
+
+	// Info is the response's info, for decoding into a type that describes it with DecodeInfo.
+	Info json.RawMessage
+}
+
+func (e *Error) Error() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "httpclient: %d", e.Status)
+	if e.Code != "" {
+		sb.WriteString(" " + e.Code)
+	}
+	if e.Message != "" {
+		sb.WriteString(": " + e.Message)
+	}
+	if e.RequestID != "" {
+		sb.WriteString(" (request id: " + e.RequestID + ")")
+	}
+	return sb.String()
+}
+
+// DecodeInfo unmarshals the error's info into v.
+func (e *Error) DecodeInfo(v any) error {
+	if len(e.Info) == 0 {
+		return fmt.Errorf("httpclient: error has no info")
+	}
+
+	if err := json.Unmarshal(e.Info, v); err != nil {
+		return fmt.Errorf("json: unmarshal: %w", err)
+	}
+	return nil
+}
+
+// HasCode reports whether err is an *Error with the provided code.
+func HasCode(err error, code string) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// HasStatus reports whether err is an *Error with the provided HTTP status.
+func HasStatus(err error, status int) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == status
+}
+
+// DecodeError reads resp's body into an *Error. It doesn't close the body.
+func DecodeError(resp *http.Response) error {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return fmt.Errorf("httpclient: read error body (status: %d): %w", resp.StatusCode, err)
+	}
+
+	e := &Error{
+		Status:    resp.StatusCode,
+		RequestID: resp.Header.Get("X-Request-Id"),
+	}
+
+	var er struct {
+		respond.ErrorResponse
+		Info json.RawMessage `json:"info,omitempty"`
+	}
+
+	if isJSON(resp.Header.Get("Content-Type")) && json.Unmarshal(body, &er) == nil {
+		e.Code = er.ErrorCode
+		e.Message = er.Error
+		e.Info = er.Info
+		if er.RequestID != "" {
+			e.RequestID = er.RequestID
+		}
+		if e.Message == "" && e.Code == "" {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
+
+	e.Message = strings.TrimSpace(string(body))
+	if len(e.Message) > 512 {
+		e.Message = e.Message[:512]
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+
+	return e
+}
+
+func isJSON(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mt == "application/json" || strings.HasSuffix(mt, "+json"))
+}
+
+// DecodeJSON reads resp's body and closes it. Successful responses are unmarshaled into v, unless v is
+// nil; error responses, with a status of 400 or more, are returned as an *Error.
+func DecodeJSON(resp *http.Response, v any) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return DecodeError(resp)
+	}
+
+	if v == nil || resp.StatusCode == http.StatusNoContent {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("json: decode: %w", err)
+	}
+
+	return nil
+}
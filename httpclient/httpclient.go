@@ -0,0 +1,48 @@
+// Package httpclient builds http.Clients for calling other services. Behaviour is layered onto a base
+// http.RoundTripper as Middleware, so each piece can be used on its own:
+//
+//	client := httpclient.New(nil,
+//		httpclient.Propagate,
+//		httpclient.Retry(httpclient.RetryOptions{}),
+//		httpclient.Breaker(httpclient.BreakerOptions{}),
+//		httpclient.Log,
+//	)
+//
+// With that order, the request ID and trace context are added once, and every attempt is checked against
+// its host's circuit breaker and logged. DecodeJSON turns kit's JSON error responses back into *Error.
+package httpclient
+
+import (
+	"net/http"
+)
+
+// Middleware wraps an http.RoundTripper with extra behaviour.
+type Middleware func(http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function to an http.RoundTripper.
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f RoundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// Chain wraps base with mws. The first Middleware is the outermost, so it sees each request first. If base
+// is nil, http.DefaultTransport is used.
+func Chain(base http.RoundTripper, mws ...Middleware) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	for i := len(mws) - 1; i >= 0; i-- {
+		base = mws[i](base)
+	}
+	return base
+}
+
+// New returns an http.Client using Chain(base, mws...) as its Transport.
+func New(base http.RoundTripper, mws ...Middleware) *http.Client {
+	return &http.Client{
+		Transport: Chain(base, mws...),
+	}
+}
@@ -0,0 +1,268 @@
+package httpclient_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/httpclient"
+	"github.com/jimmysawczuk/kit/web/requestid"
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPropagateAndLog(t *testing.T) {
+	var got http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	rec := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+
+	ctx := log.WithContext(context.Background())
+	ctx = requestid.Set(ctx, "req-001")
+	ctx, span := otel.Tracer("test").Start(ctx, "job")
+
+	client := httpclient.New(nil, httpclient.Propagate, httpclient.Log)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/items?token=secret", nil)
+	require.NoError(t, err)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	require.NoError(t, httpclient.DecodeJSON(resp, nil))
+	span.End()
+
+	// The caller's request is left alone.
+	require.Empty(t, req.Header)
+
+	spans := rec.Ended()
+	require.Len(t, spans, 2)
+	call := spans[0]
+	require.Equal(t, "HTTP GET", call.Name())
+	require.Equal(t, trace.SpanKindClient, call.SpanKind())
+	require.Equal(t, span.SpanContext().SpanID(), call.Parent().SpanID())
+
+	sc := call.SpanContext()
+	require.Equal(t, "req-001", got.Get("X-Request-Id"))
+	require.Equal(t, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01", got.Get("Traceparent"))
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	require.Equal(t, "httpclient: request finished", entry["message"])
+	require.Equal(t, "GET", entry["@client.method"])
+	require.Equal(t, srv.URL+"/items", entry["@client.url"])
+	require.EqualValues(t, http.StatusNoContent, entry["@client.status"])
+}
+
+func TestRetry(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		header     http.Header
+		statuses   []int
+		retryAfter string
+		wantCalls  int
+		wantStatus int
+	}{
+		{"RECOVERS", http.MethodGet, nil, []int{503, 502, 200}, "", 3, 200},
+		{"GIVES_UP", http.MethodGet, nil, []int{503, 503, 503, 503}, "", 3, 503},
+		{"NOT_RETRYABLE_STATUS", http.MethodGet, nil, []int{500, 200}, "", 1, 500},
+		{"POST_NOT_RETRIED", http.MethodPost, nil, []int{503, 200}, "", 1, 503},
+		{"POST_WITH_IDEMPOTENCY_KEY", http.MethodPost, http.Header{"Idempotency-Key": {"k1"}}, []int{503, 200}, "", 2, 200},
+		{"HONORS_RETRY_AFTER", http.MethodPut, nil, []int{429, 200}, "0", 2, 200},
+		{"RETRY_AFTER_TOO_LONG", http.MethodGet, nil, []int{429, 200}, "3600", 1, 429},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := calls.Add(1)
+
+				body, _ := io.ReadAll(r.Body)
+				require.Equal(t, "payload", string(body))
+
+				if test.retryAfter != "" {
+					w.Header().Set("Retry-After", test.retryAfter)
+				}
+				w.WriteHeader(test.statuses[n-1])
+			}))
+			defer srv.Close()
+
+			client := httpclient.New(nil, httpclient.Retry(httpclient.RetryOptions{
+				BaseDelay: time.Millisecond,
+				MaxDelay:  10 * time.Millisecond,
+			}))
+
+			req, err := http.NewRequest(test.method, srv.URL, strings.NewReader("payload"))
+			require.NoError(t, err)
+			for k, v := range test.header {
+				req.Header[k] = v
+			}
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			require.Equal(t, test.wantStatus, resp.StatusCode)
+			require.EqualValues(t, test.wantCalls, calls.Load())
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	client := httpclient.New(nil, httpclient.Retry(httpclient.RetryOptions{BaseDelay: time.Hour, MaxDelay: time.Hour}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	require.NoError(t, err)
+
+	_, err = client.Do(req)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestBreaker(t *testing.T) {
+	var healthy atomic.Bool
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := httpclient.New(nil, httpclient.Breaker(httpclient.BreakerOptions{
+		Threshold: 2,
+		Cooldown:  time.Minute,
+		Now:       func() time.Time { return now },
+	}))
+
+	get := func() (int, error) {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	for range 2 {
+		status, err := get()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusInternalServerError, status)
+	}
+
+	// The circuit is open, so calls fail without reaching the server.
+	_, err := get()
+	require.ErrorIs(t, err, httpclient.ErrCircuitOpen)
+	require.EqualValues(t, 2, calls.Load())
+
+	// After the cooldown, a failed trial opens it again.
+	now = now.Add(time.Minute)
+	status, err := get()
+	require.NoError(t, err)
+	require.Equal(t, http.StatusInternalServerError, status)
+
+	_, err = get()
+	require.ErrorIs(t, err, httpclient.ErrCircuitOpen)
+
+	// A successful trial closes it.
+	healthy.Store(true)
+	now = now.Add(time.Minute)
+	for range 3 {
+		status, err := get()
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, status)
+	}
+	require.EqualValues(t, 6, calls.Load())
+}
+
+func TestDecodeJSON(t *testing.T) {
+	type info struct {
+		Field string `json:"field"`
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		check   func(t *testing.T, err error, v map[string]string)
+	}{
+		{"SUCCESS", func(w http.ResponseWriter, r *http.Request) {
+			respond.Success(r.Context(), http.StatusOK, map[string]string{"name": "widget"}).Write(w)
+		}, func(t *testing.T, err error, v map[string]string) {
+			require.NoError(t, err)
+			require.Equal(t, "widget", v["name"])
+		}},
+		{"CODED_ERROR", func(w http.ResponseWriter, r *http.Request) {
+			ctx := requestid.Set(r.Context(), "req-002")
+			err := respond.ErrWithInfo(errors.New("name is required"), info{Field: "name"})
+			respond.CodedError(ctx, http.StatusBadRequest, "INVALID_INPUT", err).Write(w)
+		}, func(t *testing.T, err error, v map[string]string) {
+			var e *httpclient.Error
+			require.ErrorAs(t, err, &e)
+			require.Equal(t, http.StatusBadRequest, e.Status)
+			require.Equal(t, "INVALID_INPUT", e.Code)
+			require.Equal(t, "name is required", e.Message)
+			require.Equal(t, "req-002", e.RequestID)
+			require.Equal(t, "httpclient: 400 INVALID_INPUT: name is required (request id: req-002)", e.Error())
+
+			var i info
+			require.NoError(t, e.DecodeInfo(&i))
+			require.Equal(t, "name", i.Field)
+
+			require.True(t, httpclient.HasCode(err, "INVALID_INPUT"))
+			require.True(t, httpclient.HasStatus(err, http.StatusBadRequest))
+		}},
+		{"PLAIN_ERROR", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "upstream exploded", http.StatusBadGateway)
+		}, func(t *testing.T, err error, v map[string]string) {
+			require.True(t, httpclient.HasStatus(err, http.StatusBadGateway))
+			require.False(t, httpclient.HasCode(err, "INVALID_INPUT"))
+			require.EqualError(t, err, "httpclient: 502: upstream exploded")
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(test.handler)
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			require.NoError(t, err)
+
+			var v map[string]string
+			test.check(t, httpclient.DecodeJSON(resp, &v), v)
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Log logs each call to the logger on the request's context once the response's headers arrive.
+// Failed calls are logged as errors.
+func Log(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		start := time.Now()
+
+		resp, err := next.RoundTrip(r)
+
+		log := zerolog.Ctx(r.Context())
+
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("@client.method", r.Method).
+				Str("@client.url", redactURL(r.URL)).
+				Dur("@client.dur", time.Since(start)).
+				Msg("httpclient: request failed")
+			return nil, err
+		}
+
+		log.Info().
+			Str("@client.method", r.Method).
+			Str("@client.url", redactURL(r.URL)).
+			Int("@client.status", resp.StatusCode).
+			Dur("@client.dur", time.Since(start)).
+			Msg("httpclient: request finished")
+
+		return resp, nil
+	})
+}
+
+// redactURL returns u without its credentials or query string, which often carry secrets.
+func redactURL(u *url.URL) string {
+	c := *u
+	c.User = nil
+	c.RawQuery = ""
+	c.ForceQuery = false
+	return c.String()
+}
@@ -0,0 +1,50 @@
+package httpclient
+
+import (
+	"net/http"
+
+	"github.com/jimmysawczuk/kit/tracing"
+	"github.com/jimmysawczuk/kit/web/requestid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Propagate passes the request ID and trace context from the request's context on to the service being
+// called. If the context is being traced, a client span is recorded for the call and its context is
+// sent with the global propagator, so the callee's spans nest under it.
+func Propagate(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		ctx := r.Context()
+
+		ctx, span := tracing.Start(ctx, "HTTP "+r.Method, trace.SpanKindClient,
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.ServerAddress(r.URL.Host),
+			semconv.URLFull(redactURL(r.URL)),
+		)
+		defer span.End()
+
+		// RoundTrippers mustn't modify the request they're given.
+		r = r.Clone(ctx)
+
+		if id := requestid.Get(ctx); id != "" && r.Header.Get(requestid.DefaultHeader) == "" {
+			r.Header.Set(requestid.DefaultHeader, id)
+		}
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+
+		resp, err := next.RoundTrip(r)
+		if err != nil {
+			tracing.RecordError(span, err)
+			return nil, err
+		}
+
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+		if resp.StatusCode >= 500 {
+			span.SetStatus(codes.Error, resp.Status)
+		}
+
+		return resp, nil
+	})
+}
@@ -0,0 +1,154 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RetryOptions configures Retry.
+type RetryOptions struct {
+	// MaxAttempts is the most times a request is sent, including the first. Defaults to 3.
+	MaxAttempts int
+
+	// BaseDelay is the backoff before the first retry; it doubles for each retry after that. Defaults to
+	// 100 milliseconds.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the backoff. A Retry-After longer than MaxDelay isn't waited for; the response is
+	// returned instead. Defaults to 10 seconds.
+	MaxDelay time.Duration
+
+	// Methods are retried. Defaults to the idempotent methods: GET, HEAD, OPTIONS, PUT, DELETE and TRACE.
+	// Requests with an Idempotency-Key header are retried whatever their method.
+	Methods []string
+
+	// Statuses are retried. Defaults to 429, 502, 503 and 504.
+	Statuses []int
+}
+
+var defaultRetryMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace,
+}
+
+var defaultRetryStatuses = []int{
+	http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout,
+}
+
+// Retry resends idempotent requests that fail with a network error or a retryable status, waiting with
+// exponential backoff and full jitter between attempts. If a response has a Retry-After header, that's
+// how long Retry waits instead. Requests with a body are only retried if it can be replayed, i.e. if
+// GetBody is set, as http.NewRequest does for common body types.
+func Retry(opts RetryOptions) Middleware {
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 3
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = 100 * time.Millisecond
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = 10 * time.Second
+	}
+	if opts.Methods == nil {
+		opts.Methods = defaultRetryMethods
+	}
+	if opts.Statuses == nil {
+		opts.Statuses = defaultRetryStatuses
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			if !opts.retryable(r) {
+				return next.RoundTrip(r)
+			}
+
+			ctx := r.Context()
+
+			for attempt := 1; ; attempt++ {
+				req := r
+				if attempt > 1 && r.Body != nil && r.Body != http.NoBody {
+					body, err := r.GetBody()
+					if err != nil {
+						return nil, err
+					}
+					req = r.Clone(ctx)
+					req.Body = body
+				}
+
+				resp, err := next.RoundTrip(req)
+
+				if attempt >= opts.MaxAttempts || !opts.retryResult(ctx, resp, err) {
+					return resp, err
+				}
+
+				delay := opts.backoff(attempt)
+				if resp != nil {
+					if after, ok := retryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+						if after > opts.MaxDelay {
+							return resp, nil
+						}
+						delay = after
+					}
+
+					// Drain the body so the connection can be reused.
+					io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+					resp.Body.Close()
+				}
+
+				t := time.NewTimer(delay)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return nil, ctx.Err()
+				case <-t.C:
+				}
+			}
+		})
+	}
+}
+
+func (o RetryOptions) retryable(r *http.Request) bool {
+	if r.Body != nil && r.Body != http.NoBody && r.GetBody == nil {
+		return false
+	}
+	return slices.Contains(o.Methods, r.Method) || r.Header.Get("Idempotency-Key") != ""
+}
+
+func (o RetryOptions) retryResult(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		// Don't retry calls the caller gave up on, or hosts we've stopped calling.
+		return ctx.Err() == nil && !errors.Is(err, ErrCircuitOpen)
+	}
+	return slices.Contains(o.Statuses, resp.StatusCode)
+}
+
+// backoff returns a random delay up to BaseDelay * 2^(attempt-1), capped at MaxDelay.
+func (o RetryOptions) backoff(attempt int) time.Duration {
+	ceiling := o.MaxDelay
+	if shift := attempt - 1; shift < 32 {
+		ceiling = min(o.BaseDelay<<shift, o.MaxDelay)
+	}
+	return rand.N(ceiling) + 1
+}
+
+// retryAfter parses a Retry-After header, which is either a number of seconds or an HTTP date.
+func retryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+
+	return 0, false
+}
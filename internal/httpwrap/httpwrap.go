@@ -0,0 +1,76 @@
+// Package httpwrap helps middleware wrap http.ResponseWriters without hiding what the underlying writer
+// supports, and adjust the responses they pass through.
+package httpwrap
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Writer is embedded by ResponseWriter wrappers in place of http.ResponseWriter. It passes everything
+// through, and unwraps to the underlying ResponseWriter for use with http.ResponseController.
+type Writer struct {
+	http.ResponseWriter
+}
+
+// Unwrap returns the underlying ResponseWriter for use with http.ResponseController.
+func (w Writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush flushes w if it's an http.Flusher, calling start first. A handler that flushes before writing
+// anything starts the response with a 200, so start lets wrappers record or commit to that.
+func Flush(w http.ResponseWriter, start func()) {
+	if f, ok := w.(http.Flusher); ok {
+		start()
+		f.Flush()
+	}
+}
+
+// StatusWriter records the status of the response written through it.
+type StatusWriter struct {
+	Writer
+
+	// Status is the response's status, or 0 if it hasn't started.
+	Status int
+}
+
+// NewStatusWriter returns a StatusWriter wrapping w.
+func NewStatusWriter(w http.ResponseWriter) *StatusWriter {
+	return &StatusWriter{Writer: Writer{ResponseWriter: w}}
+}
+
+func (sw *StatusWriter) WriteHeader(code int) {
+	if sw.Status == 0 {
+		sw.Status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *StatusWriter) Write(b []byte) (int, error) {
+	if sw.Status == 0 {
+		sw.Status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (sw *StatusWriter) Flush() {
+	Flush(sw.ResponseWriter, func() {
+		if sw.Status == 0 {
+			sw.Status = http.StatusOK
+		}
+	})
+}
+
+// AddVary adds name to h's Vary header, unless it's already listed or the response varies on everything.
+func AddVary(h http.Header, name string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
@@ -0,0 +1,319 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ErrCircuitOpen is returned, wrapped with the Breaker's name, for calls a Breaker rejects.
+var ErrCircuitOpen = errors.New("resilience: circuit open")
+
+// State is the state of a Breaker's circuit.
+type State int
+
+// Breaker states.
+const (
+	// StateClosed lets calls through, counting consecutive failures.
+	StateClosed State = iota
+
+	// StateOpen rejects calls until OpenTimeout has passed.
+	StateOpen
+
+	// StateHalfOpen lets a few trial calls through to see whether the dependency has recovered.
+	StateHalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// MarshalText implements encoding.TextMarshaler, so States read well in health check output.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// BreakerOptions configures a Breaker.
+type BreakerOptions struct {
+	// Name identifies the Breaker in errors, logs and health check output. Defaults to "breaker".
+	Name string
+
+	// FailureThreshold is how many consecutive failures open the circuit. Defaults to 5.
+	FailureThreshold int
+
+	// OpenTimeout is how long the circuit stays open before trial calls are let through. Defaults to 30
+	// seconds.
+	OpenTimeout time.Duration
+
+	// HalfOpenCalls is how many trial calls are let through at once while half-open, and how many must
+	// succeed in a row to close the circuit. Defaults to 1.
+	HalfOpenCalls int
+
+	// IsFailure decides whether an error counts against the circuit. Defaults to any error except
+	// context.Canceled, since a caller giving up says nothing about the dependency.
+	IsFailure func(error) bool
+
+	// FailHealthCheck makes HealthCheck fail while the circuit is open. It's off by default: an open
+	// circuit usually means a shared dependency is down, and failing every instance's health check at once
+	// would take the whole service out rather than just the calls that need the dependency.
+	FailHealthCheck bool
+
+	// OnStateChange, if set, is called after every state transition. It's called with the Breaker locked,
+	// so it mustn't use the Breaker.
+	OnStateChange func(name string, from, to State)
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// BreakerStats describes the current state of a Breaker.
+type BreakerStats struct {
+	State     State      `json:"state"`
+	Failures  int        `json:"failures"`
+	OpenedAt  *time.Time `json:"openedAt,omitempty"`
+	Rejected  int64      `json:"rejected"`
+	LastError string     `json:"lastError,omitempty"`
+}
+
+// Breaker is a circuit breaker: once a dependency fails FailureThreshold times in a row, calls to it are
+// rejected with ErrCircuitOpen for OpenTimeout, then a few trial calls decide whether to close the circuit
+// again or keep it open. State transitions are logged to the logger on the context of the call that
+// caused them.
+type Breaker struct {
+	opts BreakerOptions
+
+	mu         sync.Mutex
+	state      State
+	generation uint64
+	failures   int
+	successes  int
+	trials     int
+	openedAt   time.Time
+	rejected   int64
+	lastErr    error
+}
+
+var _ Policy = &Breaker{}
+
+// NewBreaker returns a closed Breaker configured with opts.
+func NewBreaker(opts BreakerOptions) *Breaker {
+	if opts.Name == "" {
+		opts.Name = "breaker"
+	}
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	if opts.HalfOpenCalls <= 0 {
+		opts.HalfOpenCalls = 1
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = isFailure
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	return &Breaker{opts: opts}
+}
+
+func isFailure(err error) bool {
+	return err != nil && !errors.Is(err, context.Canceled)
+}
+
+// Do implements Policy, running fn unless the circuit is open.
+func (b *Breaker) Do(ctx context.Context, fn func(context.Context) error) error {
+	generation, err := b.before(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(ctx)
+	b.after(ctx, generation, err)
+
+	return err
+}
+
+// State returns the circuit's current state.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh(context.Background(), b.opts.Now())
+	return b.state
+}
+
+// Stats returns the current state of the Breaker.
+func (b *Breaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh(context.Background(), b.opts.Now())
+
+	stats := BreakerStats{
+		State:    b.state,
+		Failures: b.failures,
+		Rejected: b.rejected,
+	}
+	if b.state != StateClosed {
+		openedAt := b.openedAt
+		stats.OpenedAt = &openedAt
+	}
+	if b.lastErr != nil {
+		stats.LastError = b.lastErr.Error()
+	}
+	return stats
+}
+
+// before checks whether a call may go ahead, returning the generation it belongs to.
+func (b *Breaker) before(ctx context.Context) (uint64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh(ctx, b.opts.Now())
+
+	switch b.state {
+	case StateOpen:
+		b.rejected++
+		return 0, fmt.Errorf("%w (name: %s)", ErrCircuitOpen, b.opts.Name)
+
+	case StateHalfOpen:
+		if b.trials >= b.opts.HalfOpenCalls {
+			b.rejected++
+			return 0, fmt.Errorf("%w (name: %s)", ErrCircuitOpen, b.opts.Name)
+		}
+		b.trials++
+	}
+
+	return b.generation, nil
+}
+
+// after records the outcome of a call. Calls that started before the last state change are ignored, so a
+// slow call from before the circuit opened can't close it.
+func (b *Breaker) after(ctx context.Context, generation uint64, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.opts.Now()
+	b.refresh(ctx, now)
+
+	if generation != b.generation {
+		return
+	}
+
+	failed := b.opts.IsFailure(err)
+	if failed {
+		b.lastErr = err
+	} else if errors.Is(err, context.Canceled) {
+		// The call was abandoned, so it's neither a failure nor a success.
+		if b.state == StateHalfOpen {
+			b.trials--
+		}
+		return
+	}
+
+	switch b.state {
+	case StateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+
+		b.failures++
+		if b.failures >= b.opts.FailureThreshold {
+			b.transition(ctx, StateOpen, now)
+		}
+
+	case StateHalfOpen:
+		if failed {
+			b.failures++
+			b.transition(ctx, StateOpen, now)
+			return
+		}
+
+		b.trials--
+		b.successes++
+		if b.successes >= b.opts.HalfOpenCalls {
+			b.transition(ctx, StateClosed, now)
+		}
+	}
+}
+
+// refresh moves an open circuit to half-open once its OpenTimeout has passed.
+func (b *Breaker) refresh(ctx context.Context, now time.Time) {
+	if b.state == StateOpen && !now.Before(b.openedAt.Add(b.opts.OpenTimeout)) {
+		b.transition(ctx, StateHalfOpen, now)
+	}
+}
+
+func (b *Breaker) transition(ctx context.Context, to State, now time.Time) {
+	from := b.state
+
+	b.state = to
+	b.generation++
+	b.successes = 0
+	b.trials = 0
+
+	switch to {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.failures = 0
+		b.lastErr = nil
+	}
+
+	evt := zerolog.Ctx(ctx).Info()
+	if to == StateOpen {
+		evt = zerolog.Ctx(ctx).Warn().AnErr("lastError", b.lastErr)
+	}
+	evt.Str("breaker", b.opts.Name).
+		Stringer("from", from).
+		Stringer("to", to).
+		Int("failures", b.failures).
+		Msg("resilience: circuit state changed")
+
+	if b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(b.opts.Name, from, to)
+	}
+}
+
+// Name implements web.HealthChecker.
+func (b *Breaker) Name() string {
+	return b.opts.Name
+}
+
+// HealthCheck implements web.HealthChecker. The circuit's state is reported through HealthDetails; the
+// check itself only fails while the circuit is open if FailHealthCheck is set.
+func (b *Breaker) HealthCheck(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh(ctx, b.opts.Now())
+	if b.state == StateOpen && b.opts.FailHealthCheck {
+		if b.lastErr != nil {
+			return fmt.Errorf("%w (name: %s): %w", ErrCircuitOpen, b.opts.Name, b.lastErr)
+		}
+		return fmt.Errorf("%w (name: %s)", ErrCircuitOpen, b.opts.Name)
+	}
+	return nil
+}
+
+// HealthDetails implements web.HealthDetailer.
+func (b *Breaker) HealthDetails(_ context.Context) any {
+	return b.Stats()
+}
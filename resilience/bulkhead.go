@@ -0,0 +1,121 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBulkheadFull is returned, wrapped with the Bulkhead's name, for calls a Bulkhead rejects.
+var ErrBulkheadFull = errors.New("resilience: bulkhead full")
+
+// BulkheadOptions configures a Bulkhead.
+type BulkheadOptions struct {
+	// Name identifies the Bulkhead in errors and health check output. Defaults to "bulkhead".
+	Name string
+
+	// Limit is how many calls may be in flight at once. Defaults to 10.
+	Limit int
+
+	// MaxWait is how long a call waits for a slot before it's rejected. If zero, calls are rejected as
+	// soon as the Bulkhead is full.
+	MaxWait time.Duration
+}
+
+// BulkheadStats describes the current state of a Bulkhead.
+type BulkheadStats struct {
+	Limit    int   `json:"limit"`
+	InFlight int   `json:"inFlight"`
+	Rejected int64 `json:"rejected"`
+}
+
+// Bulkhead caps the number of concurrent calls to a dependency, so a slow dependency ties up a bounded
+// number of goroutines and connections rather than all of them.
+type Bulkhead struct {
+	opts BulkheadOptions
+
+	slots    chan struct{}
+	rejected atomic.Int64
+}
+
+var _ Policy = &Bulkhead{}
+
+// NewBulkhead returns a Bulkhead configured with opts.
+func NewBulkhead(opts BulkheadOptions) *Bulkhead {
+	if opts.Name == "" {
+		opts.Name = "bulkhead"
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = 10
+	}
+
+	return &Bulkhead{
+		opts:  opts,
+		slots: make(chan struct{}, opts.Limit),
+	}
+}
+
+// Do implements Policy, running fn once a slot is free.
+func (b *Bulkhead) Do(ctx context.Context, fn func(context.Context) error) error {
+	if err := b.acquire(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		<-b.slots
+	}()
+
+	return fn(ctx)
+}
+
+func (b *Bulkhead) acquire(ctx context.Context) error {
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if b.opts.MaxWait <= 0 {
+		b.rejected.Add(1)
+		return fmt.Errorf("%w (name: %s)", ErrBulkheadFull, b.opts.Name)
+	}
+
+	t := time.NewTimer(b.opts.MaxWait)
+	defer t.Stop()
+
+	select {
+	case b.slots <- struct{}{}:
+		return nil
+	case <-t.C:
+		b.rejected.Add(1)
+		return fmt.Errorf("%w (name: %s)", ErrBulkheadFull, b.opts.Name)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the current state of the Bulkhead.
+func (b *Bulkhead) Stats() BulkheadStats {
+	return BulkheadStats{
+		Limit:    b.opts.Limit,
+		InFlight: len(b.slots),
+		Rejected: b.rejected.Load(),
+	}
+}
+
+// Name implements web.HealthChecker.
+func (b *Bulkhead) Name() string {
+	return b.opts.Name
+}
+
+// HealthCheck implements web.HealthChecker. Rejecting calls is the Bulkhead working as intended, so it's
+// always healthy; its Stats are reported through HealthDetails.
+func (b *Bulkhead) HealthCheck(_ context.Context) error {
+	return nil
+}
+
+// HealthDetails implements web.HealthDetailer.
+func (b *Bulkhead) HealthDetails(_ context.Context) any {
+	return b.Stats()
+}
@@ -0,0 +1,108 @@
+// Package resilience protects callers from slow or failing dependencies. Breakers stop calling a
+// dependency that keeps failing, Bulkheads cap how many calls to it are in flight, and Timeouts bound how
+// long each call can take. Each is a Policy wrapping a func(context.Context) error, so they work for any
+// dependency, and they can be combined with Do:
+//
+//	db := resilience.NewBreaker(resilience.BreakerOptions{Name: "mysql"})
+//	pool := resilience.NewBulkhead(resilience.BulkheadOptions{Name: "mysql-pool", Limit: 20})
+//
+//	err := resilience.Do(ctx, func(ctx context.Context) error {
+//		return store.Save(ctx, v)
+//	}, db, resilience.Timeout(2*time.Second), pool)
+//
+// Timeouts go outside Bulkheads: a Timeout returns without waiting for calls that ignore their context,
+// so inside a Bulkhead, it would free the slot while the call is still running.
+//
+// Breakers and Bulkheads are web.HealthCheckers, so their state shows up in the App's health checks. They
+// stay healthy while rejecting calls, since that's them working as intended, unless a Breaker is set up
+// with FailHealthCheck.
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrTimeout is returned when a call doesn't finish within its Timeout.
+var ErrTimeout = errors.New("resilience: timeout")
+
+// Policy runs fn with some protection around it.
+type Policy interface {
+	Do(ctx context.Context, fn func(context.Context) error) error
+}
+
+// PolicyFunc adapts a function to a Policy.
+type PolicyFunc func(ctx context.Context, fn func(context.Context) error) error
+
+// Do implements Policy.
+func (f PolicyFunc) Do(ctx context.Context, fn func(context.Context) error) error {
+	return f(ctx, fn)
+}
+
+// Do runs fn inside policies. The first Policy is the outermost, i.e. with a Breaker first, calls it
+// rejects never take a Bulkhead slot, and a Timeout before a Bulkhead bounds both the time spent waiting
+// for a slot and the call itself.
+func Do(ctx context.Context, fn func(context.Context) error, policies ...Policy) error {
+	for i := len(policies) - 1; i >= 0; i-- {
+		p, next := policies[i], fn
+		fn = func(ctx context.Context) error {
+			return p.Do(ctx, next)
+		}
+	}
+	return fn(ctx)
+}
+
+// Timeout is a Policy that cancels fn's context after the duration. If fn hasn't returned by then, Do
+// returns an error wrapping ErrTimeout and context.DeadlineExceeded without waiting for it, so callers
+// aren't held up by calls that ignore their context.
+//
+// fn runs on its own goroutine, so if it panics, Do panics with the same value on the caller's goroutine,
+// where recovery middleware can catch it. Once Do has returned, fn's panics are dropped, like its errors.
+type Timeout time.Duration
+
+// result is what fn returned, or the value it panicked with.
+type result struct {
+	err   error
+	panic any
+}
+
+// Do implements Policy.
+func (t Timeout) Do(ctx context.Context, fn func(context.Context) error) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, time.Duration(t), ErrTimeout)
+	defer cancel()
+
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				done <- result{panic: v}
+			}
+		}()
+		done <- result{err: fn(ctx)}
+	}()
+
+	var res result
+	select {
+	case res = <-done:
+	case <-ctx.Done():
+		// fn may have finished at the same moment.
+		select {
+		case res = <-done:
+		default:
+			res = result{err: ctx.Err()}
+		}
+	}
+
+	if res.panic != nil {
+		panic(res.panic)
+	}
+
+	err := res.err
+
+	if err != nil && errors.Is(err, context.DeadlineExceeded) && errors.Is(context.Cause(ctx), ErrTimeout) {
+		return fmt.Errorf("%w after %s: %w", ErrTimeout, time.Duration(t), err)
+	}
+	return err
+}
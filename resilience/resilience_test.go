@@ -0,0 +1,305 @@
+package resilience_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/resilience"
+	"github.com/jimmysawczuk/kit/web"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ web.HealthChecker  = &resilience.Breaker{}
+	_ web.HealthDetailer = &resilience.Breaker{}
+	_ web.HealthChecker  = &resilience.Bulkhead{}
+	_ web.HealthDetailer = &resilience.Bulkhead{}
+)
+
+var errDown = errors.New("connection refused")
+
+func TestBreaker(t *testing.T) {
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+	ctx := log.WithContext(context.Background())
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	var transitions []string
+	b := resilience.NewBreaker(resilience.BreakerOptions{
+		Name:             "mysql",
+		FailureThreshold: 3,
+		OpenTimeout:      time.Minute,
+		HalfOpenCalls:    2,
+		Now:              func() time.Time { return now },
+		OnStateChange: func(name string, from, to resilience.State) {
+			transitions = append(transitions, name+": "+from.String()+" -> "+to.String())
+		},
+	})
+
+	calls := 0
+	call := func(err error) error {
+		return b.Do(ctx, func(context.Context) error {
+			calls++
+			return err
+		})
+	}
+
+	// Cancelled calls and successes don't count towards the threshold.
+	require.ErrorIs(t, call(errDown), errDown)
+	require.ErrorIs(t, call(errDown), errDown)
+	require.ErrorIs(t, call(context.Canceled), context.Canceled)
+	require.NoError(t, call(nil))
+	require.Equal(t, resilience.StateClosed, b.State())
+
+	for range 3 {
+		require.ErrorIs(t, call(errDown), errDown)
+	}
+	require.Equal(t, resilience.StateOpen, b.State())
+	require.NoError(t, b.HealthCheck(ctx))
+
+	details, err := json.Marshal(b.HealthDetails(ctx))
+	require.NoError(t, err)
+	require.Contains(t, string(details), `"state":"open"`)
+	require.Contains(t, string(details), `"lastError":"connection refused"`)
+
+	// Open circuits reject calls without running them.
+	err = call(nil)
+	require.ErrorIs(t, err, resilience.ErrCircuitOpen)
+	require.EqualError(t, err, "resilience: circuit open (name: mysql)")
+	require.Equal(t, 7, calls)
+
+	// A failed trial reopens the circuit.
+	now = now.Add(time.Minute)
+	require.Equal(t, resilience.StateHalfOpen, b.State())
+	require.NoError(t, b.HealthCheck(ctx))
+	require.ErrorIs(t, call(errDown), errDown)
+	require.Equal(t, resilience.StateOpen, b.State())
+
+	// Enough successful trials close it.
+	now = now.Add(time.Minute)
+	require.NoError(t, call(nil))
+	require.Equal(t, resilience.StateHalfOpen, b.State())
+	require.NoError(t, call(nil))
+	require.Equal(t, resilience.StateClosed, b.State())
+
+	require.Equal(t, []string{
+		"mysql: closed -> open",
+		"mysql: open -> half-open",
+		"mysql: half-open -> open",
+		"mysql: open -> half-open",
+		"mysql: half-open -> closed",
+	}, transitions)
+
+	// The first move to half-open was noticed by State, which has no context to log to.
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 4)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	require.Equal(t, "resilience: circuit state changed", entry["message"])
+	require.Equal(t, "warn", entry["level"])
+	require.Equal(t, "mysql", entry["breaker"])
+	require.Equal(t, "closed", entry["from"])
+	require.Equal(t, "open", entry["to"])
+	require.Equal(t, "connection refused", entry["lastError"])
+
+	details, err = json.Marshal(b.HealthDetails(ctx))
+	require.NoError(t, err)
+	require.JSONEq(t, `{"state":"closed","failures":0,"rejected":1}`, string(details))
+}
+
+func TestBreakerFailHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	errDown := errors.New("connection refused")
+
+	b := resilience.NewBreaker(resilience.BreakerOptions{Name: "mysql", FailureThreshold: 1, FailHealthCheck: true})
+	require.NoError(t, b.HealthCheck(ctx))
+
+	require.ErrorIs(t, b.Do(ctx, func(context.Context) error { return errDown }), errDown)
+	require.Equal(t, resilience.StateOpen, b.State())
+
+	err := b.HealthCheck(ctx)
+	require.ErrorIs(t, err, resilience.ErrCircuitOpen)
+	require.ErrorContains(t, err, "connection refused")
+}
+
+func TestBreakerIgnoresStaleCalls(t *testing.T) {
+	b := resilience.NewBreaker(resilience.BreakerOptions{FailureThreshold: 1})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- b.Do(context.Background(), func(context.Context) error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	require.ErrorIs(t, b.Do(context.Background(), func(context.Context) error { return errDown }), errDown)
+	require.Equal(t, resilience.StateOpen, b.State())
+
+	// The slow call succeeding doesn't close the circuit it started before.
+	close(release)
+	require.NoError(t, <-done)
+	require.Equal(t, resilience.StateOpen, b.State())
+}
+
+func TestBulkhead(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxWait time.Duration
+		release bool
+		wantErr error
+	}{
+		{"REJECTS_WHEN_FULL", 0, false, resilience.ErrBulkheadFull},
+		{"REJECTS_AFTER_WAIT", 10 * time.Millisecond, false, resilience.ErrBulkheadFull},
+		{"WAITS_FOR_SLOT", time.Second, true, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := resilience.NewBulkhead(resilience.BulkheadOptions{Name: "dynamo", Limit: 2, MaxWait: test.maxWait})
+
+			var wg sync.WaitGroup
+			started := make(chan struct{}, 2)
+			release := make(chan struct{})
+			for range 2 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					b.Do(context.Background(), func(context.Context) error {
+						started <- struct{}{}
+						<-release
+						return nil
+					})
+				}()
+			}
+			<-started
+			<-started
+
+			require.Equal(t, 2, b.Stats().InFlight)
+
+			if test.release {
+				time.AfterFunc(10*time.Millisecond, func() { close(release) })
+			}
+
+			err := b.Do(context.Background(), func(context.Context) error { return nil })
+			if test.wantErr != nil {
+				require.ErrorIs(t, err, test.wantErr)
+				require.EqualValues(t, 1, b.Stats().Rejected)
+				close(release)
+			} else {
+				require.NoError(t, err)
+			}
+
+			wg.Wait()
+			require.Equal(t, 0, b.Stats().InFlight)
+			require.NoError(t, b.HealthCheck(context.Background()))
+		})
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		fn      func(ctx context.Context) error
+		wantErr []error
+	}{
+		{"FINISHES", func(ctx context.Context) error { return nil }, nil},
+		{"RESPECTS_CONTEXT", func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}, []error{resilience.ErrTimeout, context.DeadlineExceeded}},
+		{"IGNORES_CONTEXT", func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		}, []error{resilience.ErrTimeout, context.DeadlineExceeded}},
+		{"OWN_ERROR", func(ctx context.Context) error { return errDown }, []error{errDown}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			start := time.Now()
+			err := resilience.Timeout(10*time.Millisecond).Do(context.Background(), test.fn)
+			require.Less(t, time.Since(start), 500*time.Millisecond)
+
+			if test.wantErr == nil {
+				require.NoError(t, err)
+			}
+			for _, want := range test.wantErr {
+				require.ErrorIs(t, err, want)
+			}
+		})
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	defer func() {
+		require.Equal(t, "boom", recover())
+	}()
+
+	resilience.Timeout(time.Second).Do(context.Background(), func(ctx context.Context) error {
+		panic("boom")
+	})
+	t.Fatal("expected a panic")
+}
+
+func TestTimeoutKeepsBulkheadSlot(t *testing.T) {
+	bulkhead := resilience.NewBulkhead(resilience.BulkheadOptions{Limit: 1})
+
+	release := make(chan struct{})
+	err := resilience.Do(context.Background(), func(ctx context.Context) error {
+		<-release
+		return nil
+	}, resilience.Timeout(10*time.Millisecond), bulkhead)
+	require.ErrorIs(t, err, resilience.ErrTimeout)
+
+	// The call is still running, so it still holds the slot.
+	require.Equal(t, 1, bulkhead.Stats().InFlight)
+	err = resilience.Do(context.Background(), func(ctx context.Context) error { return nil }, resilience.Timeout(10*time.Millisecond), bulkhead)
+	require.ErrorIs(t, err, resilience.ErrBulkheadFull)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		return bulkhead.Stats().InFlight == 0
+	}, time.Second, time.Millisecond)
+}
+
+func TestDo(t *testing.T) {
+	breaker := resilience.NewBreaker(resilience.BreakerOptions{FailureThreshold: 1})
+	bulkhead := resilience.NewBulkhead(resilience.BulkheadOptions{Limit: 1})
+
+	var order []string
+	trace := func(name string) resilience.Policy {
+		return resilience.PolicyFunc(func(ctx context.Context, fn func(context.Context) error) error {
+			order = append(order, name)
+			return fn(ctx)
+		})
+	}
+
+	err := resilience.Do(context.Background(), func(ctx context.Context) error {
+		order = append(order, "fn")
+		_, ok := ctx.Deadline()
+		require.True(t, ok)
+		return errDown
+	}, trace("outer"), breaker, resilience.Timeout(time.Second), bulkhead, trace("inner"))
+	require.ErrorIs(t, err, errDown)
+	require.Equal(t, []string{"outer", "inner", "fn"}, order)
+
+	// The Breaker opened, so the call stops there.
+	order = nil
+	err = resilience.Do(context.Background(), func(ctx context.Context) error { return nil }, trace("outer"), breaker, trace("inner"))
+	require.ErrorIs(t, err, resilience.ErrCircuitOpen)
+	require.Equal(t, []string{"outer"}, order)
+}
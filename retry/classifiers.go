@@ -0,0 +1,57 @@
+package retry
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/go-sql-driver/mysql"
+)
+
+// MySQL error numbers that are safe to retry.
+const (
+	// MySQLLockWaitTimeout is ER_LOCK_WAIT_TIMEOUT.
+	MySQLLockWaitTimeout = 1205
+
+	// MySQLDeadlock is ER_LOCK_DEADLOCK. MySQL rolls back the whole transaction, so it's the transaction
+	// that should be retried, not the statement; see mysql.RetryTx in db/mysql.
+	MySQLDeadlock = 1213
+)
+
+// MySQL reports whether err is a transient MySQL error: a deadlock, a lock wait timeout or a bad
+// connection.
+func MySQL(err error) bool {
+	return MySQLLock(err) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn)
+}
+
+// MySQLLock reports whether err is a deadlock or a lock wait timeout. Unlike a bad connection, these
+// guarantee the statement that failed had no effect.
+func MySQLLock(err error) bool {
+	var merr *mysql.MySQLError
+	return errors.As(err, &merr) && (merr.Number == MySQLDeadlock || merr.Number == MySQLLockWaitTimeout)
+}
+
+// AWS reports whether err is an AWS error worth retrying: throttling, i.e. DynamoDB's
+// ProvisionedThroughputExceededException, or a 5xx response from the service.
+func AWS(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+
+	if request.IsErrorThrottle(aerr) {
+		return true
+	}
+
+	var rf awserr.RequestFailure
+	if errors.As(err, &rf) {
+		switch rf.StatusCode() {
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+	}
+
+	return false
+}
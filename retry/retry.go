@@ -0,0 +1,194 @@
+// Package retry retries operations that fail with transient errors. A Policy says how many attempts to
+// make, how long to wait between them and which errors are worth retrying; classifiers for MySQL and AWS
+// errors are included and can be combined with Any:
+//
+//	p := retry.Policy{
+//		MaxAttempts: 5,
+//		Retryable:   retry.Any(retry.MySQL, retry.AWS),
+//	}
+//
+//	err := p.Do(ctx, func(ctx context.Context) error {
+//		return store.Save(ctx, v)
+//	})
+//
+// Policy implements resilience.Policy, so it can be combined with circuit breakers and timeouts.
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Classifier reports whether an error is transient, so the operation that returned it is worth retrying.
+type Classifier func(err error) bool
+
+// Any returns a Classifier reporting whether any of classifiers consider an error retryable.
+func Any(classifiers ...Classifier) Classifier {
+	return func(err error) bool {
+		for _, c := range classifiers {
+			if c(err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Always considers every error retryable.
+func Always(err error) bool {
+	return err != nil
+}
+
+// Jitter randomizes a backoff delay, so clients that failed together don't retry together. r is the
+// Policy's source of randomness.
+type Jitter func(d time.Duration, r *rand.Rand) time.Duration
+
+// FullJitter waits a random duration between zero and the delay.
+func FullJitter(d time.Duration, r *rand.Rand) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(r.Int64N(int64(d) + 1))
+}
+
+// EqualJitter waits at least half the delay, plus a random duration up to the other half.
+func EqualJitter(d time.Duration, r *rand.Rand) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(r.Int64N(int64(d-half)+1))
+}
+
+// NoJitter waits exactly the delay.
+func NoJitter(d time.Duration, _ *rand.Rand) time.Duration {
+	return d
+}
+
+// Policy describes how an operation is retried. The zero Policy makes 3 attempts, retrying any error,
+// with full-jittered exponential backoff starting at 50 milliseconds.
+type Policy struct {
+	// MaxAttempts is the most times the operation is run, including the first. Defaults to 3.
+	MaxAttempts int
+
+	// BaseDelay is the backoff before the first retry. Defaults to 50 milliseconds.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the backoff. Defaults to 5 seconds.
+	MaxDelay time.Duration
+
+	// Multiplier is how much the backoff grows after each retry. Defaults to 2.
+	Multiplier float64
+
+	// Jitter randomizes each backoff. Defaults to FullJitter.
+	Jitter Jitter
+
+	// Rand is the source of randomness for Jitter, i.e. cryptorand.New(). Its Source must be safe for
+	// concurrent use if the Policy is shared. Defaults to math/rand's global generator.
+	Rand *rand.Rand
+
+	// Retryable decides which errors are retried. Defaults to Always. Context errors from the operation's
+	// own context are never retried.
+	Retryable Classifier
+
+	// OnRetry, if set, is called before waiting to retry, i.e. to log the failure.
+	OnRetry func(ctx context.Context, attempt int, err error, delay time.Duration)
+}
+
+// globalRand adapts math/rand/v2's global generator, which is safe for concurrent use, to a *rand.Rand.
+var globalRand = rand.New(globalSource{})
+
+type globalSource struct{}
+
+func (globalSource) Uint64() uint64 {
+	return rand.Uint64()
+}
+
+// Delay returns the backoff before retry n, counting from 1, before jitter is applied.
+func (p Policy) Delay(n int) time.Duration {
+	base := p.BaseDelay
+	if base <= 0 {
+		base = 50 * time.Millisecond
+	}
+
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Second
+	}
+
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 2
+	}
+
+	d := float64(base)
+	for i := 1; i < n && d < float64(maxDelay); i++ {
+		d *= mult
+	}
+
+	return min(time.Duration(d), maxDelay)
+}
+
+// Do runs fn until it succeeds, returns an error that isn't retryable, or has run MaxAttempts times.
+// It stops early if ctx is done, or if waiting to retry would take it past ctx's deadline. If every
+// attempt fails, the last error is returned wrapped with the number of attempts.
+func (p Policy) Do(ctx context.Context, fn func(context.Context) error) error {
+	attempts := p.MaxAttempts
+	if attempts <= 0 {
+		attempts = 3
+	}
+
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = Always
+	}
+
+	jitter := p.Jitter
+	if jitter == nil {
+		jitter = FullJitter
+	}
+
+	r := p.Rand
+	if r == nil {
+		r = globalRand
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+			return err
+		}
+
+		if !retryable(err) {
+			return err
+		}
+
+		if attempt >= attempts {
+			return fmt.Errorf("retry: gave up after %d attempts: %w", attempt, err)
+		}
+
+		delay := jitter(p.Delay(attempt), r)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			return fmt.Errorf("retry: deadline too close to retry after %d attempts: %w", attempt, err)
+		}
+
+		if p.OnRetry != nil {
+			p.OnRetry(ctx, attempt, err, delay)
+		}
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("retry: %w after %d attempts: %w", ctx.Err(), attempt, err)
+		case <-t.C:
+		}
+	}
+}
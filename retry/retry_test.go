@@ -0,0 +1,176 @@
+package retry_test
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jimmysawczuk/kit/cryptorand"
+	"github.com/jimmysawczuk/kit/resilience"
+	"github.com/jimmysawczuk/kit/retry"
+	"github.com/stretchr/testify/require"
+)
+
+var _ resilience.Policy = retry.Policy{}
+
+func TestClassifiers(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		mysql bool
+		lock  bool
+		aws   bool
+	}{
+		{"DEADLOCK", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, true, true, false},
+		{"LOCK_WAIT_TIMEOUT", &mysql.MySQLError{Number: 1205, Message: "Lock wait timeout exceeded"}, true, true, false},
+		{"WRAPPED_DEADLOCK", fmt.Errorf("sqlx: exec: %w", &mysql.MySQLError{Number: 1213}), true, true, false},
+		{"DUPLICATE_KEY", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}, false, false, false},
+		{"BAD_CONN", driver.ErrBadConn, true, false, false},
+		{"INVALID_CONN", fmt.Errorf("sqlx: get: %w", mysql.ErrInvalidConn), true, false, false},
+		{"THROUGHPUT_EXCEEDED", awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "slow down", nil), false, false, true},
+		{"THROTTLING", fmt.Errorf("dtable: put item: %w", awserr.New("ThrottlingException", "rate exceeded", nil)), false, false, true},
+		{"SERVICE_UNAVAILABLE", awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "try again", nil), 503, "req-1"), false, false, true},
+		{"CONDITION_FAILED", awserr.NewRequestFailure(awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "nope", nil), 400, "req-2"), false, false, false},
+		{"PLAIN", errors.New("boom"), false, false, false},
+		{"NIL", nil, false, false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.mysql, retry.MySQL(test.err))
+			require.Equal(t, test.lock, retry.MySQLLock(test.err))
+			require.Equal(t, test.aws, retry.AWS(test.err))
+			require.Equal(t, test.mysql || test.aws, retry.Any(retry.MySQL, retry.AWS)(test.err))
+		})
+	}
+}
+
+var errTransient = errors.New("transient")
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    retry.Policy
+		errs      []error
+		wantCalls int
+		wantErr   error
+		wantMsg   string
+	}{
+		{"SUCCEEDS", retry.Policy{}, nil, 1, nil, ""},
+		{"RECOVERS", retry.Policy{}, []error{errTransient, errTransient}, 3, nil, ""},
+		{"GIVES_UP", retry.Policy{MaxAttempts: 2}, []error{errTransient, errTransient, errTransient}, 2, errTransient, "retry: gave up after 2 attempts: transient"},
+		{"NOT_RETRYABLE", retry.Policy{Retryable: retry.MySQL}, []error{errTransient}, 1, errTransient, "transient"},
+		{"CLASSIFIED", retry.Policy{Retryable: retry.MySQL}, []error{driver.ErrBadConn}, 2, nil, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := test.policy
+			p.BaseDelay = time.Millisecond
+
+			var retries []int
+			p.OnRetry = func(ctx context.Context, attempt int, err error, delay time.Duration) {
+				retries = append(retries, attempt)
+			}
+
+			calls := 0
+			err := p.Do(context.Background(), func(context.Context) error {
+				calls++
+				if calls <= len(test.errs) {
+					return test.errs[calls-1]
+				}
+				return nil
+			})
+
+			require.Equal(t, test.wantCalls, calls)
+			require.Len(t, retries, calls-1)
+			if test.wantErr == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, test.wantErr)
+			require.EqualError(t, err, test.wantMsg)
+		})
+	}
+}
+
+func TestPolicyContext(t *testing.T) {
+	p := retry.Policy{MaxAttempts: 10, BaseDelay: time.Hour, MaxDelay: time.Hour, Jitter: retry.NoJitter}
+
+	// Waiting an hour would pass the deadline, so it gives up straight away.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	calls := 0
+	err := p.Do(ctx, func(context.Context) error {
+		calls++
+		return errTransient
+	})
+	require.ErrorIs(t, err, errTransient)
+	require.Equal(t, 1, calls)
+
+	// Cancelling stops the wait.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	err = p.Do(ctx, func(context.Context) error {
+		return errTransient
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.ErrorIs(t, err, errTransient)
+
+	// The operation's own context errors aren't retried.
+	calls = 0
+	err = p.Do(ctx, func(ctx context.Context) error {
+		calls++
+		return ctx.Err()
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, calls)
+}
+
+func TestDelay(t *testing.T) {
+	p := retry.Policy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second, Multiplier: 3}
+
+	require.Equal(t, 100*time.Millisecond, p.Delay(1))
+	require.Equal(t, 300*time.Millisecond, p.Delay(2))
+	require.Equal(t, 900*time.Millisecond, p.Delay(3))
+	require.Equal(t, time.Second, p.Delay(4))
+	require.Equal(t, time.Second, p.Delay(100))
+
+	r := cryptorand.New()
+	for range 100 {
+		full := retry.FullJitter(time.Second, r)
+		require.GreaterOrEqual(t, full, time.Duration(0))
+		require.LessOrEqual(t, full, time.Second)
+
+		equal := retry.EqualJitter(time.Second, r)
+		require.GreaterOrEqual(t, equal, 500*time.Millisecond)
+		require.LessOrEqual(t, equal, time.Second)
+	}
+}
+
+func TestWithBreaker(t *testing.T) {
+	breaker := resilience.NewBreaker(resilience.BreakerOptions{FailureThreshold: 2})
+	p := retry.Policy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Millisecond,
+		// Once the circuit opens there's no point retrying.
+		Retryable: func(err error) bool { return !errors.Is(err, resilience.ErrCircuitOpen) },
+	}
+
+	calls := 0
+	err := resilience.Do(context.Background(), func(context.Context) error {
+		calls++
+		return errTransient
+	}, p, breaker)
+
+	require.ErrorIs(t, err, resilience.ErrCircuitOpen)
+	require.Equal(t, 2, calls)
+}
@@ -0,0 +1,164 @@
+// Package tracing sets up OpenTelemetry tracing for a service and instruments kit's packages with it.
+// NewProvider registers a TracerProvider that sends spans to an OpenTelemetry collector over OTLP/HTTP,
+// along with W3C Trace Context propagation, as OpenTelemetry's globals, so spans recorded by other
+// instrumentation, i.e. otelhttp or otelsql, join the same traces:
+//
+//	tp, err := tracing.NewProvider(ctx, tracing.Config{
+//		Endpoint: "http://collector:4318/v1/traces",
+//		Service:  "my-service",
+//	})
+//
+//	r := router.New()
+//	r.Use(tracing.Middleware)
+//
+//	app := web.NewApp().
+//		WithRouter(r).
+//		WithShutdown(tp)
+//
+// Once a request has a span, Start creates child spans from its context, which is how aws/dtable and
+// httpclient instrument their calls.
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// DefaultOTLPEndpoint is where a collector running alongside the service receives OTLP/HTTP traces.
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+// instrumentationScope names this package as the source of the spans it starts.
+const instrumentationScope = "github.com/jimmysawczuk/kit/tracing"
+
+// Config configures the TracerProvider built by NewProvider.
+type Config struct {
+	// Endpoint is the collector's traces URL. Defaults to DefaultOTLPEndpoint.
+	Endpoint string
+
+	// Service is reported as the service.name resource attribute.
+	Service string
+
+	// Headers are added to every export request, i.e. for collector authentication.
+	Headers map[string]string
+
+	// Sampler decides whether traces started by this service are recorded. Traces continued from another
+	// service keep that service's decision. Defaults to sdktrace.AlwaysSample.
+	Sampler sdktrace.Sampler
+
+	// Log receives errors from OpenTelemetry, i.e. failed exports. If nil, OpenTelemetry's default handler
+	// writes them to the standard library's logger.
+	Log *zerolog.Logger
+}
+
+func (c Config) endpoint() string {
+	if c.Endpoint == "" {
+		return DefaultOTLPEndpoint
+	}
+	return c.Endpoint
+}
+
+func (c Config) sampler() sdktrace.Sampler {
+	if c.Sampler == nil {
+		return sdktrace.AlwaysSample()
+	}
+	return c.Sampler
+}
+
+// Provider is the TracerProvider registered by NewProvider. Spans are queued and sent to the collector
+// in batches by the SDK's BatchSpanProcessor, so exporting never blocks a request.
+type Provider struct {
+	*sdktrace.TracerProvider
+}
+
+// NewProvider builds a Provider exporting to the collector described by c, and registers it and the W3C
+// Trace Context and Baggage propagators as OpenTelemetry's globals.
+func NewProvider(ctx context.Context, c Config) (*Provider, error) {
+	exp, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(c.endpoint()),
+		otlptracehttp.WithHeaders(c.Headers),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("otlptracehttp: new: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(c.Service)))
+	if err != nil {
+		return nil, fmt.Errorf("resource: merge: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(c.sampler())),
+	)
+
+	if c.Log != nil {
+		log := c.Log
+		otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+			log.Error().Err(err).Msg("tracing: opentelemetry error")
+		}))
+	}
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return &Provider{TracerProvider: tp}, nil
+}
+
+// Name implements web.Shutdowner.
+func (p *Provider) Name() string {
+	return "tracing"
+}
+
+// Shutdown implements web.Shutdowner. It sends whatever spans are still queued, then stops the Provider.
+// Calls after the first do nothing.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if err := p.TracerProvider.Shutdown(ctx); err != nil {
+		return fmt.Errorf("tracer provider: shutdown: %w", err)
+	}
+	return nil
+}
+
+// tracer returns the tracer kit's spans are started with, from the global TracerProvider.
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationScope)
+}
+
+// Start starts a child of the span in ctx. If ctx isn't being traced, nothing is: ctx is returned unchanged
+// along with a span that does nothing.
+func Start(ctx context.Context, name string, kind trace.SpanKind, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, noop.Span{}
+	}
+
+	return tracer().Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+}
+
+// RecordError records err on span and marks the span as failed. It does nothing if err is nil.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// ParseTraceparent parses a W3C traceparent header value. It'll return false if the value isn't valid.
+func ParseTraceparent(v string) (trace.SpanContext, bool) {
+	carrier := propagation.HeaderCarrier{"Traceparent": {v}}
+	sc := trace.SpanContextFromContext(propagation.TraceContext{}.Extract(context.Background(), carrier))
+	return sc, sc.IsValid()
+}
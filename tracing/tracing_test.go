@@ -0,0 +1,244 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/jimmysawczuk/kit/tracing"
+	"github.com/jimmysawczuk/kit/web/middleware"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		ok      bool
+		sampled bool
+	}{
+		{"SAMPLED", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"NOT_SAMPLED", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"FUTURE_VERSION", "cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"VERSION_00_EXTRA", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false},
+		{"INVALID_VERSION", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"ZERO_TRACE_ID", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"ZERO_SPAN_ID", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"UPPERCASE", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"TOO_SHORT", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false, false},
+		{"EMPTY", "", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sc, ok := tracing.ParseTraceparent(test.in)
+			require.Equal(t, test.ok, ok)
+			if !ok {
+				return
+			}
+
+			require.True(t, sc.IsRemote())
+			require.Equal(t, test.sampled, sc.IsSampled())
+			require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+			require.Equal(t, "00f067aa0ba902b7", sc.SpanID().String())
+		})
+	}
+}
+
+// record registers a TracerProvider that keeps ended spans in memory, and W3C Trace Context propagation.
+func record(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	t.Cleanup(func() {
+		tp.Shutdown(context.Background())
+	})
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return rec
+}
+
+func byName(rec *tracetest.SpanRecorder, name string) (sdktrace.ReadOnlySpan, bool) {
+	for _, s := range rec.Ended() {
+		if s.Name() == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+func attr(s sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, a := range s.Attributes() {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestMiddleware(t *testing.T) {
+	rec := record(t)
+
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+
+	var outgoing http.Header
+
+	r := router.New()
+	r.Use(middleware.WithLogger(&log), tracing.Middleware)
+	r.Getf("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), "render", trace.SpanKindInternal)
+		outgoing = http.Header{}
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(outgoing))
+		span.End()
+
+		zerolog.Ctx(ctx).Info().Msg("handled")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	r.Getf("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	req.Header.Set("Tracestate", "vendor=abc")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusNoContent, w.Code)
+
+	req = httptest.NewRequest(http.MethodGet, "/fail", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// Spans aren't started outside of a trace.
+	_, span := tracing.Start(context.Background(), "orphan", trace.SpanKindInternal)
+	span.End()
+
+	require.Len(t, rec.Ended(), 3)
+
+	server, ok := byName(rec, "GET /users/{id}")
+	require.True(t, ok)
+	sc := server.SpanContext()
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	require.Equal(t, "vendor=abc", sc.TraceState().String())
+	require.Equal(t, trace.SpanKindServer, server.SpanKind())
+	require.Equal(t, "/users/{id}", attr(server, "http.route").AsString())
+	require.EqualValues(t, http.StatusNoContent, attr(server, "http.response.status_code").AsInt64())
+	require.Equal(t, codes.Unset, server.Status().Code)
+
+	render, ok := byName(rec, "render")
+	require.True(t, ok)
+	require.Equal(t, sc.SpanID(), render.Parent().SpanID())
+	require.Equal(t, "00-"+sc.TraceID().String()+"-"+render.SpanContext().SpanID().String()+"-01", outgoing.Get("Traceparent"))
+	require.Equal(t, "vendor=abc", outgoing.Get("Tracestate"))
+
+	fail, ok := byName(rec, "GET /fail")
+	require.True(t, ok)
+	require.False(t, fail.Parent().IsValid())
+	require.NotEqual(t, sc.TraceID(), fail.SpanContext().TraceID())
+	require.Equal(t, codes.Error, fail.Status().Code)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	require.Equal(t, sc.TraceID().String(), entry["@trace.id"])
+	require.Equal(t, sc.SpanID().String(), entry["@span.id"])
+}
+
+// collector is an in-process stand-in for an OTLP/HTTP collector.
+type collector struct {
+	mu      sync.Mutex
+	service string
+	spans   []string
+	headers http.Header
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var req coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.headers = r.Header
+	for _, rs := range req.ResourceSpans {
+		for _, a := range rs.Resource.Attributes {
+			if a.Key == "service.name" {
+				c.service = a.Value.GetStringValue()
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				c.spans = append(c.spans, s.Name)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+}
+
+func TestNewProvider(t *testing.T) {
+	col := &collector{}
+	srv := httptest.NewServer(col)
+	defer srv.Close()
+
+	tp, err := tracing.NewProvider(context.Background(), tracing.Config{
+		Endpoint: srv.URL + "/v1/traces",
+		Service:  "kit-test",
+		Headers:  map[string]string{"Authorization": "Bearer collector-token"},
+	})
+	require.NoError(t, err)
+
+	r := router.New()
+	r.Use(tracing.Middleware)
+	r.Getf("/", func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.Start(r.Context(), "child", trace.SpanKindInternal)
+		span.End()
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// Shutdown sends the queued spans, and can be called again.
+	require.NoError(t, tp.Shutdown(context.Background()))
+	require.NoError(t, tp.Shutdown(context.Background()))
+
+	col.mu.Lock()
+	defer col.mu.Unlock()
+
+	require.Equal(t, "kit-test", col.service)
+	require.Equal(t, "Bearer collector-token", col.headers.Get("Authorization"))
+	require.ElementsMatch(t, []string{"GET /", "child"}, col.spans)
+}
@@ -0,0 +1,200 @@
+// Package apikey authenticates callers with static API keys. Keys look like
+//
+//	<prefix>_<id>_<secret>
+//
+// where the prefix identifies the kind of key (i.e. "sk_live"), the ID is a public handle used to look the
+// key up, and the secret is only ever shown once, when the key is generated. Stores keep a SHA-256 hash
+// of the secret, never the secret itself.
+package apikey
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jimmysawczuk/kit/cryptorand"
+)
+
+const (
+	idLength     = 12
+	secretLength = 32
+	alphabet     = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+var (
+	// ErrMalformed is returned for strings that aren't shaped like a key.
+	ErrMalformed = errors.New("apikey: malformed key")
+
+	// ErrNotFound is returned by a Store when there's no key with an ID.
+	ErrNotFound = errors.New("apikey: key not found")
+
+	// ErrInvalid is returned when a key's secret doesn't match.
+	ErrInvalid = errors.New("apikey: invalid key")
+
+	// ErrExpired is returned for keys past their expiry.
+	ErrExpired = errors.New("apikey: key expired")
+
+	// ErrRevoked is returned for revoked keys.
+	ErrRevoked = errors.New("apikey: key revoked")
+)
+
+// Key is a stored API key.
+type Key struct {
+	ID     string
+	Prefix string
+
+	// Hash is the SHA-256 hash of the key's secret.
+	Hash []byte
+
+	// Subject is who the key authenticates as, i.e. a partner's ID.
+	Subject string
+	Name    string
+	Scopes  []string
+
+	CreatedAt time.Time
+
+	// ExpiresAt is when the key stops working. Zero means never.
+	ExpiresAt time.Time
+
+	// RevokedAt is when the key was revoked. Zero means it hasn't been.
+	RevokedAt time.Time
+
+	// LastUsedAt is when the key was last used, as of the last time usage was flushed to the store.
+	LastUsedAt time.Time
+}
+
+// HasScope reports whether the key was granted scope.
+func (k Key) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// Usable returns an error if the key has been revoked or has expired as of now.
+func (k Key) Usable(now time.Time) error {
+	if !k.RevokedAt.IsZero() {
+		return ErrRevoked
+	}
+
+	if !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt) {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// Store looks up and persists keys.
+type Store interface {
+	// Get returns the key with id, or ErrNotFound.
+	Get(ctx context.Context, id string) (Key, error)
+
+	// Put creates or replaces a key.
+	Put(ctx context.Context, k Key) error
+
+	// Delete removes the key with id.
+	Delete(ctx context.Context, id string) error
+
+	// Touch records that the key with id was last used at t.
+	Touch(ctx context.Context, id string, t time.Time) error
+}
+
+func random(n int) string {
+	rnd := cryptorand.New()
+
+	var sb strings.Builder
+	sb.Grow(n)
+	for range n {
+		sb.WriteByte(alphabet[rnd.IntN(len(alphabet))])
+	}
+	return sb.String()
+}
+
+func hash(secret string) []byte {
+	sum := sha256.Sum256([]byte(secret))
+	return sum[:]
+}
+
+// Generate creates a new key with prefix for subject. The returned string is the only copy of the full
+// key; the Key only holds its hash and should be saved with Store.Put.
+func Generate(prefix, subject string, scopes ...string) (string, Key) {
+	id, secret := random(idLength), random(secretLength)
+
+	return prefix + "_" + id + "_" + secret, Key{
+		ID:        id,
+		Prefix:    prefix,
+		Hash:      hash(secret),
+		Subject:   subject,
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+}
+
+// Parse splits a key into its prefix, ID and secret. The prefix may itself contain underscores.
+func Parse(key string) (prefix, id, secret string, err error) {
+	rest, secret, ok := cut(key)
+	if !ok {
+		return "", "", "", ErrMalformed
+	}
+
+	prefix, id, ok = cut(rest)
+	if !ok || len(id) != idLength || len(secret) != secretLength {
+		return "", "", "", ErrMalformed
+	}
+
+	return prefix, id, secret, nil
+}
+
+func cut(s string) (string, string, bool) {
+	i := strings.LastIndexByte(s, '_')
+	if i <= 0 || i == len(s)-1 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+// Verify reports whether secret matches the key's hash.
+func (k Key) Verify(secret string) bool {
+	return subtle.ConstantTimeCompare(hash(secret), k.Hash) == 1
+}
+
+// Authenticator checks keys against a Store.
+type Authenticator struct {
+	Store Store
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// Authenticate parses key, looks it up and verifies its secret, expiry and revocation.
+func (a *Authenticator) Authenticate(ctx context.Context, key string) (Key, error) {
+	prefix, id, secret, err := Parse(key)
+	if err != nil {
+		return Key{}, err
+	}
+
+	k, err := a.Store.Get(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return Key{}, ErrInvalid
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("store: get: %w", err)
+	}
+
+	if k.Prefix != prefix || !k.Verify(secret) {
+		return Key{}, ErrInvalid
+	}
+
+	now := time.Now()
+	if a.Now != nil {
+		now = a.Now()
+	}
+
+	if err := k.Usable(now); err != nil {
+		return Key{}, err
+	}
+
+	return k, nil
+}
@@ -0,0 +1,157 @@
+package apikey_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web/auth"
+	"github.com/jimmysawczuk/kit/web/auth/apikey"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate(t *testing.T) {
+	raw, k := apikey.Generate("sk_live", "partner-1", "orders:read")
+	require.True(t, strings.HasPrefix(raw, "sk_live_"+k.ID+"_"))
+	require.NotContains(t, string(k.Hash), raw)
+
+	prefix, id, secret, err := apikey.Parse(raw)
+	require.NoError(t, err)
+	require.Equal(t, "sk_live", prefix)
+	require.Equal(t, k.ID, id)
+	require.True(t, k.Verify(secret))
+	require.False(t, k.Verify(secret[1:]+"x"))
+
+	other, _ := apikey.Generate("sk_live", "partner-1")
+	require.NotEqual(t, raw, other)
+
+	for _, bad := range []string{"", "nounderscores", "sk_short_secret", raw + "_", "_" + k.ID + "_" + secret} {
+		_, _, _, err := apikey.Parse(bad)
+		require.ErrorIs(t, err, apikey.ErrMalformed, bad)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	now := time.Now()
+
+	valid, validKey := apikey.Generate("sk", "partner-1", "orders:read")
+	expired, expiredKey := apikey.Generate("sk", "partner-2")
+	expiredKey.ExpiresAt = now.Add(-time.Minute)
+	revoked, revokedKey := apikey.Generate("sk", "partner-3")
+	revokedKey.RevokedAt = now.Add(-time.Hour)
+	unknown, _ := apikey.Generate("sk", "partner-4")
+
+	store := apikey.NewMemoryStore(validKey, expiredKey, revokedKey)
+	a := &apikey.Authenticator{Store: store}
+
+	_, id, secret, err := apikey.Parse(valid)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		key  string
+		err  error
+	}{
+		{"VALID", valid, nil},
+		{"EXPIRED", expired, apikey.ErrExpired},
+		{"REVOKED", revoked, apikey.ErrRevoked},
+		{"UNKNOWN", unknown, apikey.ErrInvalid},
+		{"WRONG_SECRET", "sk_" + id + "_" + strings.Repeat("x", len(secret)), apikey.ErrInvalid},
+		{"WRONG_PREFIX", "pk_" + id + "_" + secret, apikey.ErrInvalid},
+		{"MALFORMED", "garbage", apikey.ErrMalformed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			k, err := a.Authenticate(context.Background(), test.key)
+			if test.err != nil {
+				require.ErrorIs(t, err, test.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "partner-1", k.Subject)
+			require.True(t, k.HasScope("orders:read"))
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	raw, k := apikey.Generate("sk", "partner-1", "orders:read")
+	store := apikey.NewMemoryStore(k)
+	tracker := apikey.NewTracker(store)
+
+	h := apikey.Middleware(&apikey.Authenticator{Store: store}, apikey.Options{
+		Header:  "X-API-Key",
+		Tracker: tracker,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := auth.Get(r.Context())
+		require.True(t, ok)
+		require.Equal(t, "apikey", p.Method)
+		require.True(t, p.HasScope("orders:read"))
+
+		w.Write([]byte(p.Subject))
+	}))
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		body    string
+	}{
+		{"MISSING", nil, http.StatusUnauthorized, `"code":"MISSING_API_KEY"`},
+		{"INVALID", map[string]string{"X-API-Key": "sk_nope"}, http.StatusUnauthorized, `"code":"INVALID_API_KEY"`},
+		{"BEARER", map[string]string{"Authorization": "Bearer " + raw}, http.StatusOK, "partner-1"},
+		{"HEADER", map[string]string{"X-API-Key": raw}, http.StatusOK, "partner-1"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			require.Equal(t, test.status, w.Code)
+			require.Contains(t, w.Body.String(), test.body)
+		})
+	}
+
+	// Uses are only written to the store when the tracker flushes.
+	stored, err := store.Get(context.Background(), k.ID)
+	require.NoError(t, err)
+	require.True(t, stored.LastUsedAt.IsZero())
+
+	require.NoError(t, tracker.Shutdown(context.Background()))
+
+	stored, err = store.Get(context.Background(), k.ID)
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now(), stored.LastUsedAt, time.Second)
+}
+
+func TestTrackerStart(t *testing.T) {
+	_, k := apikey.Generate("sk", "partner-1")
+	store := apikey.NewMemoryStore(k)
+
+	tracker := &apikey.Tracker{Store: store, Interval: 10 * time.Millisecond}
+	tracker.Start(nil)
+	tracker.Start(nil)
+	t.Cleanup(func() {
+		require.NoError(t, tracker.Shutdown(context.Background()))
+		require.NoError(t, tracker.Shutdown(context.Background()))
+	})
+
+	used := time.Now()
+	tracker.Record(k.ID, used)
+
+	require.Eventually(t, func() bool {
+		stored, err := store.Get(context.Background(), k.ID)
+		return err == nil && stored.LastUsedAt.Equal(used)
+	}, time.Second, 5*time.Millisecond)
+}
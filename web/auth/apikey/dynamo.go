@@ -0,0 +1,169 @@
+package apikey
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/jimmysawczuk/kit/aws/dtable"
+)
+
+// DynamoStore is a Store that keeps keys in a DynamoDB table, one item per key.
+type DynamoStore struct {
+	Table *dtable.Table
+
+	// Schema names the table's key attributes. Items are written with a sort key of "apikey". Keys are
+	// kept until they're deleted, so TTLAttribute isn't used.
+	dtable.Schema
+}
+
+var _ Store = &DynamoStore{}
+
+// NewDynamoStore returns a DynamoStore for a table with a "pk" partition key and no sort key.
+func NewDynamoStore(t *dtable.Table) *DynamoStore {
+	return &DynamoStore{
+		Table: t,
+	}
+}
+
+func (d *DynamoStore) key(id string) map[string]*dynamodb.AttributeValue {
+	return d.ItemKey("apikey", id)
+}
+
+func timestamp(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.UnixNano(), 10))}
+}
+
+func parseTimestamp(item map[string]*dynamodb.AttributeValue, name string) (time.Time, error) {
+	av, ok := item[name]
+	if !ok || av.N == nil {
+		return time.Time{}, nil
+	}
+
+	v, err := strconv.ParseInt(*av.N, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("strconv: parse int (attribute: %s): %w", name, err)
+	}
+
+	return time.Unix(0, v), nil
+}
+
+func str(item map[string]*dynamodb.AttributeValue, name string) string {
+	if av, ok := item[name]; ok && av.S != nil {
+		return *av.S
+	}
+	return ""
+}
+
+// Get implements Store.
+func (d *DynamoStore) Get(ctx context.Context, id string) (Key, error) {
+	out, err := d.Table.GetItem(ctx, &dynamodb.GetItemInput{
+		Key: d.key(id),
+	})
+	if err != nil {
+		return Key{}, fmt.Errorf("dtable: get item: %w", err)
+	}
+
+	if len(out.Item) == 0 {
+		return Key{}, ErrNotFound
+	}
+
+	k := Key{
+		ID:      id,
+		Prefix:  str(out.Item, "prefix"),
+		Subject: str(out.Item, "subject"),
+		Name:    str(out.Item, "name"),
+	}
+
+	if av, ok := out.Item["hash"]; ok {
+		k.Hash = av.B
+	}
+
+	if av, ok := out.Item["scopes"]; ok {
+		k.Scopes = aws.StringValueSlice(av.SS)
+	}
+
+	for name, dst := range map[string]*time.Time{
+		"createdAt":  &k.CreatedAt,
+		"expiresAt":  &k.ExpiresAt,
+		"revokedAt":  &k.RevokedAt,
+		"lastUsedAt": &k.LastUsedAt,
+	} {
+		if *dst, err = parseTimestamp(out.Item, name); err != nil {
+			return Key{}, err
+		}
+	}
+
+	return k, nil
+}
+
+// Put implements Store.
+func (d *DynamoStore) Put(ctx context.Context, k Key) error {
+	item := d.key(k.ID)
+	item["prefix"] = &dynamodb.AttributeValue{S: aws.String(k.Prefix)}
+	item["hash"] = &dynamodb.AttributeValue{B: k.Hash}
+	item["subject"] = &dynamodb.AttributeValue{S: aws.String(k.Subject)}
+	item["createdAt"] = timestamp(k.CreatedAt)
+
+	if k.Name != "" {
+		item["name"] = &dynamodb.AttributeValue{S: aws.String(k.Name)}
+	}
+
+	// DynamoDB doesn't allow empty sets.
+	if len(k.Scopes) > 0 {
+		item["scopes"] = &dynamodb.AttributeValue{SS: aws.StringSlice(k.Scopes)}
+	}
+
+	for name, t := range map[string]time.Time{
+		"expiresAt":  k.ExpiresAt,
+		"revokedAt":  k.RevokedAt,
+		"lastUsedAt": k.LastUsedAt,
+	} {
+		if !t.IsZero() {
+			item[name] = timestamp(t)
+		}
+	}
+
+	if _, err := d.Table.PutItem(ctx, &dynamodb.PutItemInput{Item: item}); err != nil {
+		return fmt.Errorf("dtable: put item: %w", err)
+	}
+
+	return nil
+}
+
+// Delete implements Store.
+func (d *DynamoStore) Delete(ctx context.Context, id string) error {
+	if _, err := d.Table.DeleteItem(ctx, &dynamodb.DeleteItemInput{Key: d.key(id)}); err != nil {
+		return fmt.Errorf("dtable: delete item: %w", err)
+	}
+
+	return nil
+}
+
+// Touch implements Store. Writes older than the stored last use, and writes for deleted keys, are
+// discarded.
+func (d *DynamoStore) Touch(ctx context.Context, id string, t time.Time) error {
+	_, err := d.Table.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:                 d.key(id),
+		UpdateExpression:    aws.String("SET #lastUsedAt = :t"),
+		ConditionExpression: aws.String("attribute_exists(#pk) AND (attribute_not_exists(#lastUsedAt) OR #lastUsedAt < :t)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk":         aws.String(d.PartitionKeyName()),
+			"#lastUsedAt": aws.String("lastUsedAt"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":t": timestamp(t),
+		},
+	})
+	if err != nil {
+		if dtable.ConditionFailed(err) {
+			return nil
+		}
+		return fmt.Errorf("dtable: update item: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package apikey
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a Store that keeps keys in memory, i.e. for tests or keys loaded from configuration.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	keys map[string]Key
+}
+
+var _ Store = &MemoryStore{}
+
+// NewMemoryStore returns a MemoryStore holding keys.
+func NewMemoryStore(keys ...Key) *MemoryStore {
+	m := &MemoryStore{
+		keys: map[string]Key{},
+	}
+	for _, k := range keys {
+		m.keys[k.ID] = k
+	}
+	return m
+}
+
+// Get implements Store.
+func (m *MemoryStore) Get(_ context.Context, id string) (Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k, ok := m.keys[id]
+	if !ok {
+		return Key{}, ErrNotFound
+	}
+
+	k.Scopes = slices.Clone(k.Scopes)
+	return k, nil
+}
+
+// Put implements Store.
+func (m *MemoryStore) Put(_ context.Context, k Key) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys[k.ID] = k
+	return nil
+}
+
+// Delete implements Store.
+func (m *MemoryStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.keys, id)
+	return nil
+}
+
+// Touch implements Store.
+func (m *MemoryStore) Touch(_ context.Context, id string, t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.keys[id]
+	if ok && t.After(k.LastUsedAt) {
+		k.LastUsedAt = t
+		m.keys[id] = k
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web/auth"
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/rs/zerolog"
+)
+
+type ctxKey int
+
+const (
+	keyKey ctxKey = 1 << iota
+)
+
+// Get attempts to get the authenticated Key from the provided context.Context. It'll return false if the
+// context doesn't contain one.
+func Get(ctx context.Context) (Key, bool) {
+	v, ok := ctx.Value(keyKey).(Key)
+	return v, ok
+}
+
+// Set returns a copy of the provided context.Context with the provided Key as a value.
+func Set(parent context.Context, k Key) context.Context {
+	return context.WithValue(parent, keyKey, k)
+}
+
+// Options configures the API key middleware.
+type Options struct {
+	// Header is a header to read keys from, i.e. "X-API-Key". Keys are always accepted as bearer tokens in
+	// the Authorization header.
+	Header string
+
+	// Optional lets requests without a key through unauthenticated. Requests with an invalid key are
+	// still rejected.
+	Optional bool
+
+	// Tracker, if set, records when each key is used.
+	Tracker *Tracker
+}
+
+// Middleware authenticates requests with an API key checked by a, then sets the Key and an auth.Principal
+// on the context and the subject and key ID on the logger. Requests without a valid key are rejected with
+// a 401.
+func Middleware(a *Authenticator, opts Options) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			raw, ok := fromRequest(r, opts.Header)
+			if !ok {
+				if opts.Optional {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				respond.CodedError(ctx, http.StatusUnauthorized, "MISSING_API_KEY", errors.New("missing api key")).Write(w)
+				return
+			}
+
+			k, err := a.Authenticate(ctx, raw)
+			if err != nil {
+				if !errors.Is(err, ErrMalformed) && !errors.Is(err, ErrInvalid) && !errors.Is(err, ErrExpired) && !errors.Is(err, ErrRevoked) {
+					zerolog.Ctx(ctx).Error().Err(err).Msg("apikey: couldn't authenticate key")
+					respond.CodedError(ctx, http.StatusServiceUnavailable, "API_KEY_UNAVAILABLE", errors.New("couldn't authenticate api key")).Write(w)
+					return
+				}
+
+				zerolog.Ctx(ctx).Info().Err(err).Msg("apikey: invalid key")
+				respond.CodedError(ctx, http.StatusUnauthorized, "INVALID_API_KEY", err).Write(w)
+				return
+			}
+
+			if opts.Tracker != nil {
+				opts.Tracker.Record(k.ID, time.Now())
+			}
+
+			ctx = Set(ctx, k)
+			ctx = auth.Set(ctx, auth.Principal{
+				Subject: k.Subject,
+				Scopes:  k.Scopes,
+				Method:  "apikey",
+			})
+
+			zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("@auth.sub", k.Subject).Str("@auth.key", k.ID)
+			})
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func fromRequest(r *http.Request, header string) (string, bool) {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		if token = strings.TrimSpace(token); token != "" {
+			return token, true
+		}
+	}
+
+	if header != "" {
+		if v := strings.TrimSpace(r.Header.Get(header)); v != "" {
+			return v, true
+		}
+	}
+
+	return "", false
+}
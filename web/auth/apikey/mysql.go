@@ -0,0 +1,130 @@
+package apikey
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MySQLStore is a Store that keeps keys in a MySQL table with the following schema:
+//
+//	CREATE TABLE api_keys (
+//		id CHAR(12) NOT NULL PRIMARY KEY,
+//		prefix VARCHAR(32) NOT NULL,
+//		hash BINARY(32) NOT NULL,
+//		subject VARCHAR(255) NOT NULL,
+//		name VARCHAR(255) NOT NULL,
+//		scopes TEXT NOT NULL,
+//		created_at DATETIME(6) NOT NULL,
+//		expires_at DATETIME(6) NULL,
+//		revoked_at DATETIME(6) NULL,
+//		last_used_at DATETIME(6) NULL,
+//		INDEX (subject)
+//	);
+type MySQLStore struct {
+	DB *sqlx.DB
+
+	// Table is the name of the keys table. Defaults to "api_keys".
+	Table string
+}
+
+var _ Store = &MySQLStore{}
+
+// NewMySQLStore returns a MySQLStore using the "api_keys" table.
+func NewMySQLStore(db *sqlx.DB) *MySQLStore {
+	return &MySQLStore{
+		DB: db,
+	}
+}
+
+func (m *MySQLStore) table() string {
+	if m.Table == "" {
+		return "api_keys"
+	}
+	return m.Table
+}
+
+type keyRow struct {
+	ID         string       `db:"id"`
+	Prefix     string       `db:"prefix"`
+	Hash       []byte       `db:"hash"`
+	Subject    string       `db:"subject"`
+	Name       string       `db:"name"`
+	Scopes     string       `db:"scopes"`
+	CreatedAt  time.Time    `db:"created_at"`
+	ExpiresAt  sql.NullTime `db:"expires_at"`
+	RevokedAt  sql.NullTime `db:"revoked_at"`
+	LastUsedAt sql.NullTime `db:"last_used_at"`
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
+
+func (r keyRow) key() Key {
+	return Key{
+		ID:         r.ID,
+		Prefix:     r.Prefix,
+		Hash:       r.Hash,
+		Subject:    r.Subject,
+		Name:       r.Name,
+		Scopes:     strings.Fields(r.Scopes),
+		CreatedAt:  r.CreatedAt,
+		ExpiresAt:  r.ExpiresAt.Time,
+		RevokedAt:  r.RevokedAt.Time,
+		LastUsedAt: r.LastUsedAt.Time,
+	}
+}
+
+// Get implements Store.
+func (m *MySQLStore) Get(ctx context.Context, id string) (Key, error) {
+	var row keyRow
+	err := m.DB.GetContext(ctx, &row, "SELECT `id`, `prefix`, `hash`, `subject`, `name`, `scopes`, `created_at`, "+
+		"`expires_at`, `revoked_at`, `last_used_at` FROM `"+m.table()+"` WHERE `id` = ?", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Key{}, ErrNotFound
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("sqlx: get: %w", err)
+	}
+
+	return row.key(), nil
+}
+
+// Put implements Store.
+func (m *MySQLStore) Put(ctx context.Context, k Key) error {
+	_, err := m.DB.ExecContext(ctx, "REPLACE INTO `"+m.table()+"` (`id`, `prefix`, `hash`, `subject`, `name`, `scopes`, "+
+		"`created_at`, `expires_at`, `revoked_at`, `last_used_at`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		k.ID, k.Prefix, k.Hash, k.Subject, k.Name, strings.Join(k.Scopes, " "),
+		k.CreatedAt.UTC(), nullTime(k.ExpiresAt), nullTime(k.RevokedAt), nullTime(k.LastUsedAt))
+	if err != nil {
+		return fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	return nil
+}
+
+// Delete implements Store.
+func (m *MySQLStore) Delete(ctx context.Context, id string) error {
+	if _, err := m.DB.ExecContext(ctx, "DELETE FROM `"+m.table()+"` WHERE `id` = ?", id); err != nil {
+		return fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	return nil
+}
+
+// Touch implements Store.
+func (m *MySQLStore) Touch(ctx context.Context, id string, t time.Time) error {
+	_, err := m.DB.ExecContext(ctx, "UPDATE `"+m.table()+"` SET `last_used_at` = ? "+
+		"WHERE `id` = ? AND (`last_used_at` IS NULL OR `last_used_at` < ?)", t.UTC(), id, t.UTC())
+	if err != nil {
+		return fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package apikey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Tracker records when keys are used without adding a store write to every request. Uses are buffered in
+// memory and written to the Store every Interval, keeping only the latest use of each key.
+type Tracker struct {
+	Store Store
+
+	// Interval is how often buffered uses are written. Defaults to 1 minute.
+	Interval time.Duration
+
+	mu       sync.Mutex
+	pending  map[string]time.Time
+	started  bool
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewTracker returns a Tracker that writes to store. Call Start to begin flushing periodically.
+func NewTracker(store Store) *Tracker {
+	return &Tracker{
+		Store: store,
+	}
+}
+
+// init creates the buffer and the channels used to run the periodic flush, so a Tracker built as a
+// struct literal works too. t.mu must be held.
+func (t *Tracker) init() {
+	if t.pending == nil {
+		t.pending = map[string]time.Time{}
+	}
+	if t.stop == nil {
+		t.stop = make(chan struct{})
+		t.done = make(chan struct{})
+	}
+}
+
+// Record notes that the key with id was used at t. It never blocks on the store.
+func (t *Tracker) Record(id string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.init()
+	if prev, ok := t.pending[id]; !ok || at.After(prev) {
+		t.pending[id] = at
+	}
+}
+
+// Flush writes buffered uses to the store. Uses that fail to write are kept for the next flush.
+func (t *Tracker) Flush(ctx context.Context) error {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = map[string]time.Time{}
+	t.mu.Unlock()
+
+	var errs []error
+	for id, at := range pending {
+		if err := t.Store.Touch(ctx, id, at); err != nil {
+			errs = append(errs, fmt.Errorf("store: touch (id: %s): %w", id, err))
+			t.Record(id, at)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Start flushes buffered uses every Interval until Shutdown is called. Flush failures are logged to log.
+// Starting a Tracker that's already started does nothing.
+func (t *Tracker) Start(log *zerolog.Logger) {
+	if log == nil {
+		nop := zerolog.Nop()
+		log = &nop
+	}
+
+	t.mu.Lock()
+	if t.started {
+		t.mu.Unlock()
+		return
+	}
+	t.init()
+	t.started = true
+	stop, done := t.stop, t.done
+	t.mu.Unlock()
+
+	interval := t.Interval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	go func() {
+		defer close(done)
+
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-tick.C:
+			}
+
+			if err := t.Flush(context.Background()); err != nil {
+				log.Error().Err(err).Msg("apikey: couldn't record key usage")
+			}
+		}
+	}()
+}
+
+// Name implements web.Shutdowner.
+func (t *Tracker) Name() string {
+	return "apikey-tracker"
+}
+
+// Shutdown implements web.Shutdowner. It stops the periodic flush, if it was started, and writes whatever
+// uses are still buffered.
+func (t *Tracker) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	t.init()
+	started, stop, done := t.started, t.stop, t.done
+	t.mu.Unlock()
+
+	t.stopOnce.Do(func() {
+		close(stop)
+	})
+
+	if started {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return t.Flush(ctx)
+}
@@ -0,0 +1,54 @@
+// Package auth holds the authenticated principal of a request, however it was authenticated.
+package auth
+
+import (
+	"context"
+	"slices"
+)
+
+type ctxKey int
+
+const (
+	principalKey ctxKey = 1 << iota
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Subject uniquely identifies the caller, i.e. a user ID or a client's name.
+	Subject string
+
+	Scopes []string
+	Roles  []string
+
+	// Method is how the caller was authenticated, i.e. "jwt".
+	Method string
+}
+
+// HasScope reports whether the principal was granted scope.
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// HasRole reports whether the principal has role.
+func (p Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// Get attempts to get the Principal from the provided context.Context. It'll return false if the
+// request wasn't authenticated.
+func Get(ctx context.Context) (Principal, bool) {
+	v, ok := ctx.Value(principalKey).(Principal)
+	return v, ok
+}
+
+// Subject returns the subject of the Principal in the provided context.Context, or an empty string if
+// the request wasn't authenticated.
+func Subject(ctx context.Context) string {
+	p, _ := Get(ctx)
+	return p.Subject
+}
+
+// Set returns a copy of the provided context.Context with the provided Principal as a value.
+func Set(parent context.Context, p Principal) context.Context {
+	return context.WithValue(parent, principalKey, p)
+}
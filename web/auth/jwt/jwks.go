@@ -0,0 +1,295 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// JWK is a JSON Web Key (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+
+	// oct
+	K string `json:"k,omitempty"`
+}
+
+// PublicKey decodes the key into an *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey or []byte.
+func (k JWK) PublicKey() (any, error) {
+	dec := base64.RawURLEncoding.DecodeString
+
+	switch k.Kty {
+	case "RSA":
+		n, err := dec(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("decode n: %w", err)
+		}
+		e, err := dec(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("decode e: %w", err)
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decode x: %w", err)
+		}
+		y, err := dec(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("decode y: %w", err)
+		}
+
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("point is not on curve %s", k.Crv)
+		}
+		return pub, nil
+
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("decode x: %w", err)
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("expected %d byte key, got %d", ed25519.PublicKeySize, len(x))
+		}
+		return ed25519.PublicKey(x), nil
+
+	case "oct":
+		secret, err := dec(k.K)
+		if err != nil {
+			return nil, fmt.Errorf("decode k: %w", err)
+		}
+		return secret, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+// JWKS is a KeySet fetched from a JWKS URL. Keys are cached and refreshed every RefreshInterval; a token
+// signed with a key that isn't in the cache triggers an early refresh (no more often than
+// MinRefreshInterval), so keys that are rotated in are picked up promptly. Keys are fetched without
+// holding up requests that can be served from the cache, and concurrent requests share a single fetch.
+type JWKS struct {
+	URL string
+
+	// Client fetches the keys. Defaults to a client with a 10 second timeout.
+	Client *http.Client
+
+	// RefreshInterval is how long fetched keys are used before they're fetched again. Defaults to 1 hour.
+	RefreshInterval time.Duration
+
+	// MinRefreshInterval is the minimum time between fetches. Defaults to 1 minute.
+	MinRefreshInterval time.Duration
+
+	fetch singleflight.Group
+
+	mu          sync.Mutex
+	keys        map[string]any
+	algs        map[string]string
+	fetched     time.Time
+	lastAttempt time.Time
+}
+
+var _ KeySet = &JWKS{}
+
+// NewJWKS returns a JWKS that fetches keys from url.
+func NewJWKS(url string) *JWKS {
+	return &JWKS{
+		URL: url,
+	}
+}
+
+func (j *JWKS) client() *http.Client {
+	if j.Client == nil {
+		return &http.Client{Timeout: 10 * time.Second}
+	}
+	return j.Client
+}
+
+func (j *JWKS) refreshInterval() time.Duration {
+	if j.RefreshInterval <= 0 {
+		return time.Hour
+	}
+	return j.RefreshInterval
+}
+
+func (j *JWKS) minRefreshInterval() time.Duration {
+	if j.MinRefreshInterval <= 0 {
+		return time.Minute
+	}
+	return j.MinRefreshInterval
+}
+
+// Key implements KeySet.
+func (j *JWKS) Key(ctx context.Context, kid, alg string) (any, error) {
+	j.mu.Lock()
+	stale := time.Since(j.fetched) > j.refreshInterval()
+	cached := len(j.keys) > 0
+	j.mu.Unlock()
+
+	if stale {
+		// If this fails, carry on with the keys we have, if any.
+		if err := j.refresh(ctx); err != nil && !cached {
+			return nil, err
+		}
+	}
+
+	if key, ok := j.lookup(kid, alg); ok {
+		return key, nil
+	}
+
+	if err := j.refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	if key, ok := j.lookup(kid, alg); ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w (kid: %s)", ErrKeyNotFound, kid)
+}
+
+// lookup finds the key for kid. If kid is empty, the only key that can be used with alg is returned.
+func (j *JWKS) lookup(kid, alg string) (any, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if kid != "" {
+		key, ok := j.keys[kid]
+		return key, ok
+	}
+
+	var found any
+	for id, key := range j.keys {
+		if a := j.algs[id]; a != "" && a != alg {
+			continue
+		}
+		if found != nil {
+			return nil, false
+		}
+		found = key
+	}
+
+	return found, found != nil
+}
+
+// refresh fetches the keys, unless they were fetched within MinRefreshInterval, joining a fetch that's
+// already in flight. The fetch isn't cancelled with ctx, since other requests may be waiting on it.
+func (j *JWKS) refresh(ctx context.Context) error {
+	ch := j.fetch.DoChan("", func() (any, error) {
+		j.mu.Lock()
+		if time.Since(j.lastAttempt) <= j.minRefreshInterval() {
+			j.mu.Unlock()
+			return nil, nil
+		}
+		j.lastAttempt = time.Now()
+		j.mu.Unlock()
+
+		keys, algs, err := j.get(context.WithoutCancel(ctx))
+		if err != nil {
+			return nil, err
+		}
+
+		j.mu.Lock()
+		j.keys, j.algs = keys, algs
+		j.fetched = time.Now()
+		j.mu.Unlock()
+
+		return nil, nil
+	})
+
+	select {
+	case res := <-ch:
+		return res.Err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// get fetches and decodes the key set.
+func (j *JWKS) get(ctx context.Context) (map[string]any, map[string]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.URL, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("http: new request: %w", err)
+	}
+
+	resp, err := j.client().Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("jwks: fetch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("jwks: fetch: unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []JWK `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, nil, fmt.Errorf("json: decode: %w", err)
+	}
+
+	keys, algs := map[string]any{}, map[string]string{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		pub, err := k.PublicKey()
+		if err != nil {
+			// Skip keys we don't understand rather than rejecting the whole set.
+			continue
+		}
+
+		keys[k.Kid] = pub
+		algs[k.Kid] = k.Alg
+	}
+
+	return keys, algs, nil
+}
@@ -0,0 +1,322 @@
+// Package jwt validates JSON Web Tokens (RFC 7519) presented as bearer tokens, with keys that can be
+// fetched from a JWKS endpoint.
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Supported signing algorithms.
+const (
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+	HS256 = "HS256"
+)
+
+// Errors returned when validating a token. They're wrapped with more detail, so use errors.Is.
+var (
+	ErrMalformed        = errors.New("jwt: malformed token")
+	ErrUnsupportedAlg   = errors.New("jwt: unsupported algorithm")
+	ErrInvalidSignature = errors.New("jwt: invalid signature")
+	ErrExpired          = errors.New("jwt: token is expired")
+	ErrNotYetValid      = errors.New("jwt: token is not valid yet")
+	ErrInvalidIssuer    = errors.New("jwt: invalid issuer")
+	ErrInvalidAudience  = errors.New("jwt: invalid audience")
+	ErrKeyNotFound      = errors.New("jwt: key not found")
+)
+
+// NumericDate is a JSON number of seconds since the Unix epoch.
+type NumericDate struct {
+	time.Time
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (n *NumericDate) UnmarshalJSON(b []byte) error {
+	var f json.Number
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("json: unmarshal: %w", err)
+	}
+
+	v, err := f.Float64()
+	if err != nil {
+		return fmt.Errorf("parse numeric date: %w", err)
+	}
+
+	sec := int64(v)
+	n.Time = time.Unix(sec, int64((v-float64(sec))*float64(time.Second))).UTC()
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (n NumericDate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.Unix())
+}
+
+// Audience is the aud claim, which can be either a string or an array of strings.
+type Audience []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return fmt.Errorf("json: unmarshal: %w", err)
+	}
+
+	*a = ss
+	return nil
+}
+
+// Claims are the registered claims of a token, plus the scope claim from RFC 8693.
+type Claims struct {
+	Issuer    string       `json:"iss,omitempty"`
+	Subject   string       `json:"sub,omitempty"`
+	Audience  Audience     `json:"aud,omitempty"`
+	ExpiresAt *NumericDate `json:"exp,omitempty"`
+	NotBefore *NumericDate `json:"nbf,omitempty"`
+	IssuedAt  *NumericDate `json:"iat,omitempty"`
+	ID        string       `json:"jti,omitempty"`
+
+	// Scope is a space-separated list of scopes.
+	Scope string `json:"scope,omitempty"`
+
+	raw []byte
+}
+
+// Scopes returns the token's scopes.
+func (c *Claims) Scopes() []string {
+	return strings.Fields(c.Scope)
+}
+
+// Decode unmarshals the token's full payload into v, for access to private claims.
+func (c *Claims) Decode(v any) error {
+	if err := json.Unmarshal(c.raw, v); err != nil {
+		return fmt.Errorf("json: unmarshal: %w", err)
+	}
+	return nil
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+}
+
+// KeySet provides the keys used to verify tokens.
+type KeySet interface {
+	// Key returns the key identified by kid for use with alg: an *rsa.PublicKey, *ecdsa.PublicKey,
+	// ed25519.PublicKey or, for HS256, a []byte secret. kid may be empty if the token didn't specify one.
+	Key(ctx context.Context, kid, alg string) (any, error)
+}
+
+// StaticKeys is a KeySet of fixed keys, indexed by key ID. If a token doesn't specify a key ID, the key
+// with an empty ID is used.
+type StaticKeys map[string]any
+
+// Key implements KeySet.
+func (s StaticKeys) Key(_ context.Context, kid, _ string) (any, error) {
+	k, ok := s[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w (kid: %s)", ErrKeyNotFound, kid)
+	}
+	return k, nil
+}
+
+// Validator validates tokens.
+type Validator struct {
+	Keys KeySet
+
+	// Issuer, if set, must match the token's iss claim.
+	Issuer string
+
+	// Audience, if set, must be one of the token's aud claims.
+	Audience string
+
+	// Algorithms lists the algorithms that tokens may be signed with. Defaults to RS256, ES256 and EdDSA;
+	// HS256 must be allowed explicitly.
+	Algorithms []string
+
+	// Leeway is the clock skew tolerated when checking exp and nbf.
+	Leeway time.Duration
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+var defaultAlgorithms = []string{RS256, ES256, EdDSA}
+
+// Validate parses token, verifies its signature and checks its claims.
+func (v *Validator) Validate(ctx context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 parts, got %d", ErrMalformed, len(parts))
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrMalformed, err)
+	}
+
+	algs := v.Algorithms
+	if len(algs) == 0 {
+		algs = defaultAlgorithms
+	}
+
+	if !slices.Contains(algs, h.Alg) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlg, h.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrMalformed, err)
+	}
+
+	key, err := v.Keys.Key(ctx, h.Kid, h.Alg)
+	if err != nil {
+		return nil, fmt.Errorf("key set: key: %w", err)
+	}
+
+	if err := verify(h.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: payload: %w", ErrMalformed, err)
+	}
+
+	claims := &Claims{raw: payload}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("%w: payload: %w", ErrMalformed, err)
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *Validator) checkClaims(c *Claims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	if c.ExpiresAt == nil {
+		return fmt.Errorf("%w: missing exp", ErrExpired)
+	}
+
+	if !now.Before(c.ExpiresAt.Add(v.Leeway)) {
+		return fmt.Errorf("%w: expired at %s", ErrExpired, c.ExpiresAt.Format(time.RFC3339))
+	}
+
+	if c.NotBefore != nil && now.Add(v.Leeway).Before(c.NotBefore.Time) {
+		return fmt.Errorf("%w: not valid until %s", ErrNotYetValid, c.NotBefore.Format(time.RFC3339))
+	}
+
+	if v.Issuer != "" && c.Issuer != v.Issuer {
+		return fmt.Errorf("%w: %q", ErrInvalidIssuer, c.Issuer)
+	}
+
+	if v.Audience != "" && !slices.Contains(c.Audience, v.Audience) {
+		return fmt.Errorf("%w: %q", ErrInvalidAudience, []string(c.Audience))
+	}
+
+	return nil
+}
+
+func decodeSegment(seg string, v any) error {
+	by, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("base64: decode: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(by))
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("json: decode: %w", err)
+	}
+
+	return nil
+}
+
+// verify checks sig over signed using key, making sure the key is of the right type for alg so that one
+// kind of key can't be used to verify another algorithm.
+func verify(alg string, key any, signed, sig []byte) error {
+	sum := sha256.Sum256(signed)
+
+	switch alg {
+	case RS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %s requires an rsa key, got %T", ErrInvalidSignature, alg, key)
+		}
+
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+		}
+
+	case ES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve.Params().BitSize != 256 {
+			return fmt.Errorf("%w: %s requires a p-256 key, got %T", ErrInvalidSignature, alg, key)
+		}
+
+		if len(sig) != 64 {
+			return fmt.Errorf("%w: expected 64 byte signature, got %d", ErrInvalidSignature, len(sig))
+		}
+
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, sum[:], r, s) {
+			return ErrInvalidSignature
+		}
+
+	case EdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %s requires an ed25519 key, got %T", ErrInvalidSignature, alg, key)
+		}
+
+		if !ed25519.Verify(pub, signed, sig) {
+			return ErrInvalidSignature
+		}
+
+	case HS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("%w: %s requires a secret, got %T", ErrInvalidSignature, alg, key)
+		}
+
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrInvalidSignature
+		}
+
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedAlg, alg)
+	}
+
+	return nil
+}
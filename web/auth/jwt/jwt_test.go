@@ -0,0 +1,345 @@
+package jwt_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web/auth"
+	"github.com/jimmysawczuk/kit/web/auth/jwt"
+	"github.com/stretchr/testify/require"
+)
+
+var b64 = base64.RawURLEncoding
+
+// sign builds a token signed with key using alg.
+func sign(t *testing.T, alg, kid string, key any, claims map[string]any) string {
+	t.Helper()
+
+	hdr, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := b64.EncodeToString(hdr) + "." + b64.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		require.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signed))
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	}
+
+	return signed + "." + b64.EncodeToString(sig)
+}
+
+func validClaims() map[string]any {
+	return map[string]any{
+		"iss":   "https://issuer.example.com",
+		"sub":   "user-123",
+		"aud":   []string{"api", "other"},
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nbf":   time.Now().Add(-time.Minute).Unix(),
+		"scope": "orders:read orders:write",
+		"tier":  "gold",
+	}
+}
+
+func withClaim(k string, v any) map[string]any {
+	c := validClaims()
+	if v == nil {
+		delete(c, k)
+	} else {
+		c[k] = v
+	}
+	return c
+}
+
+// jwksServer serves the public halves of keys as a JWKS, counting fetches.
+type jwksServer struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	keys    []jwt.JWK
+	fetches atomic.Int32
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	s := &jwksServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]any{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) setKeys(keys ...jwt.JWK) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func rsaJWK(kid string, k *rsa.PrivateKey) jwt.JWK {
+	return jwt.JWK{Kty: "RSA", Kid: kid, Alg: jwt.RS256, N: b64.EncodeToString(k.N.Bytes()), E: b64.EncodeToString(big.NewInt(int64(k.E)).Bytes())}
+}
+
+func ecJWK(kid string, k *ecdsa.PrivateKey) jwt.JWK {
+	return jwt.JWK{Kty: "EC", Kid: kid, Crv: "P-256", X: b64.EncodeToString(k.X.FillBytes(make([]byte, 32))), Y: b64.EncodeToString(k.Y.FillBytes(make([]byte, 32)))}
+}
+
+func edJWK(kid string, k ed25519.PrivateKey) jwt.JWK {
+	return jwt.JWK{Kty: "OKP", Kid: kid, Crv: "Ed25519", X: b64.EncodeToString(k.Public().(ed25519.PublicKey))}
+}
+
+func TestValidate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	secret := []byte("super-secret")
+
+	srv := newJWKSServer(t)
+	srv.setKeys(rsaJWK("rsa", rsaKey), ecJWK("ec", ecKey), edJWK("ed", edKey), jwt.JWK{Kty: "oct", Kid: "hs", K: b64.EncodeToString(secret)})
+
+	v := &jwt.Validator{
+		Keys:       jwt.NewJWKS(srv.URL),
+		Issuer:     "https://issuer.example.com",
+		Audience:   "api",
+		Algorithms: []string{jwt.RS256, jwt.ES256, jwt.EdDSA, jwt.HS256},
+		Leeway:     30 * time.Second,
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"RS256", sign(t, jwt.RS256, "rsa", rsaKey, validClaims()), nil},
+		{"ES256", sign(t, jwt.ES256, "ec", ecKey, validClaims()), nil},
+		{"EDDSA", sign(t, jwt.EdDSA, "ed", edKey, validClaims()), nil},
+		{"HS256", sign(t, jwt.HS256, "hs", secret, validClaims()), nil},
+		{"WRONG_KEY", sign(t, jwt.ES256, "ec", mustECKey(t), validClaims()), jwt.ErrInvalidSignature},
+		{"ALG_KEY_MISMATCH", sign(t, jwt.HS256, "rsa", secret, validClaims()), jwt.ErrInvalidSignature},
+		{"UNSUPPORTED_ALG", sign(t, "none", "rsa", secret, validClaims()), jwt.ErrUnsupportedAlg},
+		{"EXPIRED", sign(t, jwt.RS256, "rsa", rsaKey, withClaim("exp", time.Now().Add(-time.Minute).Unix())), jwt.ErrExpired},
+		{"EXPIRED_WITHIN_LEEWAY", sign(t, jwt.RS256, "rsa", rsaKey, withClaim("exp", time.Now().Add(-10*time.Second).Unix())), nil},
+		{"MISSING_EXP", sign(t, jwt.RS256, "rsa", rsaKey, withClaim("exp", nil)), jwt.ErrExpired},
+		{"NOT_YET_VALID", sign(t, jwt.RS256, "rsa", rsaKey, withClaim("nbf", time.Now().Add(time.Minute).Unix())), jwt.ErrNotYetValid},
+		{"WRONG_ISSUER", sign(t, jwt.RS256, "rsa", rsaKey, withClaim("iss", "https://evil.example.com")), jwt.ErrInvalidIssuer},
+		{"WRONG_AUDIENCE", sign(t, jwt.RS256, "rsa", rsaKey, withClaim("aud", "other")), jwt.ErrInvalidAudience},
+		{"UNKNOWN_KID", sign(t, jwt.RS256, "missing", rsaKey, validClaims()), jwt.ErrKeyNotFound},
+		{"MALFORMED", "not.a-token", jwt.ErrMalformed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims, err := v.Validate(context.Background(), test.token)
+			if test.err != nil {
+				require.ErrorIs(t, err, test.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "user-123", claims.Subject)
+			require.Equal(t, []string{"orders:read", "orders:write"}, claims.Scopes())
+
+			var private struct {
+				Tier string `json:"tier"`
+			}
+			require.NoError(t, claims.Decode(&private))
+			require.Equal(t, "gold", private.Tier)
+		})
+	}
+}
+
+func mustECKey(t *testing.T) *ecdsa.PrivateKey {
+	k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return k
+}
+
+func TestJWKSRotation(t *testing.T) {
+	oldKey, newKey := mustECKey(t), mustECKey(t)
+
+	srv := newJWKSServer(t)
+	srv.setKeys(ecJWK("old", oldKey))
+
+	jwks := jwt.NewJWKS(srv.URL)
+	jwks.MinRefreshInterval = 10 * time.Millisecond
+	v := &jwt.Validator{Keys: jwks}
+
+	_, err := v.Validate(context.Background(), sign(t, jwt.ES256, "old", oldKey, validClaims()))
+	require.NoError(t, err)
+
+	_, err = v.Validate(context.Background(), sign(t, jwt.ES256, "old", oldKey, validClaims()))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, srv.fetches.Load())
+
+	srv.setKeys(ecJWK("old", oldKey), ecJWK("new", newKey))
+
+	// Unknown keys within MinRefreshInterval of the last fetch don't trigger another one.
+	_, err = v.Validate(context.Background(), sign(t, jwt.ES256, "new", newKey, validClaims()))
+	require.ErrorIs(t, err, jwt.ErrKeyNotFound)
+
+	time.Sleep(20 * time.Millisecond)
+
+	_, err = v.Validate(context.Background(), sign(t, jwt.ES256, "new", newKey, validClaims()))
+	require.NoError(t, err)
+	require.EqualValues(t, 2, srv.fetches.Load())
+}
+
+func TestJWKSFetchNotBlocking(t *testing.T) {
+	key := mustECKey(t)
+
+	var fetches atomic.Int32
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fetches.Add(1) > 1 {
+			<-block
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jwt.JWK{ecJWK("a", key)}})
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(block) })
+
+	jwks := jwt.NewJWKS(srv.URL)
+	jwks.MinRefreshInterval = 10 * time.Millisecond
+	v := &jwt.Validator{Keys: jwks}
+
+	_, err := v.Validate(context.Background(), sign(t, jwt.ES256, "a", key, validClaims()))
+	require.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+
+	// Tokens with unknown keys wait on a single fetch, which hangs.
+	token := sign(t, jwt.ES256, "unknown", key, validClaims())
+	errs := make(chan error, 10)
+	for range cap(errs) {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+
+			_, err := v.Validate(ctx, token)
+			errs <- err
+		}()
+	}
+
+	require.Eventually(t, func() bool { return fetches.Load() == 2 }, time.Second, time.Millisecond)
+
+	// Meanwhile, tokens with cached keys are still validated.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = v.Validate(ctx, sign(t, jwt.ES256, "a", key, validClaims()))
+	require.NoError(t, err)
+
+	for range cap(errs) {
+		require.ErrorIs(t, <-errs, context.DeadlineExceeded)
+	}
+	require.EqualValues(t, 2, fetches.Load())
+}
+
+func TestMiddleware(t *testing.T) {
+	secret := []byte("super-secret")
+	v := &jwt.Validator{
+		Keys:       jwt.StaticKeys{"": secret},
+		Algorithms: []string{jwt.HS256},
+	}
+
+	h := jwt.Middleware(v, jwt.Options{Realm: "api"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := jwt.Get(r.Context())
+		require.True(t, ok)
+
+		p, ok := auth.Get(r.Context())
+		require.True(t, ok)
+		require.Equal(t, claims.Subject, p.Subject)
+		require.True(t, p.HasScope("orders:write"))
+
+		w.Write([]byte(p.Subject))
+	}))
+
+	tests := []struct {
+		name      string
+		header    string
+		status    int
+		challenge string
+		body      string
+	}{
+		{
+			name:      "MISSING",
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="api"`,
+			body:      `"code":"MISSING_TOKEN"`,
+		},
+		{
+			name:      "INVALID",
+			header:    "Bearer " + sign(t, jwt.HS256, "", []byte("wrong"), validClaims()),
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="api", error="invalid_token", error_description="invalid signature"`,
+			body:      `"code":"INVALID_TOKEN"`,
+		},
+		{
+			name:      "EXPIRED",
+			header:    "Bearer " + sign(t, jwt.HS256, "", secret, withClaim("exp", time.Now().Add(-time.Hour).Unix())),
+			status:    http.StatusUnauthorized,
+			challenge: `Bearer realm="api", error="invalid_token", error_description="token expired"`,
+			body:      `"code":"INVALID_TOKEN"`,
+		},
+		{
+			name:   "VALID",
+			header: "Bearer " + sign(t, jwt.HS256, "", secret, validClaims()),
+			status: http.StatusOK,
+			body:   "user-123",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if test.header != "" {
+				r.Header.Set("Authorization", test.header)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			require.Equal(t, test.status, w.Code)
+			require.Equal(t, test.challenge, w.Header().Get("WWW-Authenticate"))
+			require.Contains(t, w.Body.String(), test.body)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jimmysawczuk/kit/web/auth"
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/rs/zerolog"
+)
+
+type ctxKey int
+
+const (
+	claimsKey ctxKey = 1 << iota
+)
+
+// Get attempts to get the validated Claims from the provided context.Context. It'll return false if the
+// context doesn't contain any.
+func Get(ctx context.Context) (*Claims, bool) {
+	v, ok := ctx.Value(claimsKey).(*Claims)
+	return v, ok
+}
+
+// Set returns a copy of the provided context.Context with the provided Claims as a value.
+func Set(parent context.Context, c *Claims) context.Context {
+	return context.WithValue(parent, claimsKey, c)
+}
+
+// Options configures the JWT middleware.
+type Options struct {
+	// Realm is included in WWW-Authenticate challenges.
+	Realm string
+
+	// Optional lets requests without a bearer token through unauthenticated. Requests with an invalid
+	// token are still rejected.
+	Optional bool
+
+	// Principal builds the auth.Principal for validated claims. By default, the subject and scopes are used.
+	Principal func(*Claims) auth.Principal
+}
+
+func defaultPrincipal(c *Claims) auth.Principal {
+	return auth.Principal{
+		Subject: c.Subject,
+		Scopes:  c.Scopes(),
+		Method:  "jwt",
+	}
+}
+
+// Middleware authenticates requests with a bearer token validated by v, then sets the Claims and an
+// auth.Principal on the context and the subject on the logger. Requests without a valid token are
+// rejected with a 401 and an RFC 6750 WWW-Authenticate challenge.
+func Middleware(v *Validator, opts Options) func(http.Handler) http.Handler {
+	if opts.Principal == nil {
+		opts.Principal = defaultPrincipal
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			token, ok := bearerToken(r)
+			if !ok {
+				if opts.Optional {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				challenge(ctx, w, opts.Realm, http.StatusUnauthorized, "", "MISSING_TOKEN", errors.New("missing bearer token"))
+				return
+			}
+
+			claims, err := v.Validate(ctx, token)
+			if err != nil {
+				zerolog.Ctx(ctx).Info().Err(err).Msg("jwt: invalid token")
+				challenge(ctx, w, opts.Realm, http.StatusUnauthorized, "invalid_token", "INVALID_TOKEN", errors.New(describe(err)))
+				return
+			}
+
+			ctx = Set(ctx, claims)
+			ctx = auth.Set(ctx, opts.Principal(claims))
+
+			zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("@auth.sub", claims.Subject)
+			})
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// descriptions are what clients are told about why their token was rejected. The errors themselves can
+// carry details that shouldn't be, like why a JWKS couldn't be fetched, so they're only logged.
+var descriptions = []struct {
+	err         error
+	description string
+}{
+	{ErrMalformed, "malformed token"},
+	{ErrUnsupportedAlg, "unsupported algorithm"},
+	{ErrInvalidSignature, "invalid signature"},
+	{ErrExpired, "token expired"},
+	{ErrNotYetValid, "token not valid yet"},
+	{ErrInvalidIssuer, "invalid issuer"},
+	{ErrInvalidAudience, "invalid audience"},
+	{ErrKeyNotFound, "unknown signing key"},
+}
+
+// describe returns the RFC 6750 error description for a validation error.
+func describe(err error) string {
+	for _, d := range descriptions {
+		if errors.Is(err, d.err) {
+			return d.description
+		}
+	}
+	return "invalid token"
+}
+
+// challenge responds with an error and a WWW-Authenticate header as described in RFC 6750 section 3.
+func challenge(ctx context.Context, w http.ResponseWriter, realm string, status int, errCode, code string, err error) {
+	params := []string{}
+	if realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", realm))
+	}
+
+	if errCode != "" {
+		params = append(params, fmt.Sprintf("error=%q", errCode))
+		params = append(params, fmt.Sprintf("error_description=%q", strings.ReplaceAll(err.Error(), `"`, "'")))
+	}
+
+	value := "Bearer"
+	if len(params) > 0 {
+		value += " " + strings.Join(params, ", ")
+	}
+
+	resp := respond.CodedError(ctx, status, code, err)
+	resp.WithHeader(func(h http.Header) http.Header {
+		h.Set("WWW-Authenticate", value)
+		return h
+	})
+	resp.Write(w)
+}
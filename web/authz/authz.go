@@ -0,0 +1,218 @@
+// Package authz enforces authorization policies against the auth.Principal of a request. Policies are
+// attached to routes, groups and modules as middleware:
+//
+//	r.Post("/orders", createOrder, authz.Require("orders:write"))
+//
+// The policies protecting each route are reported in its router.Route Annotations.
+package authz
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jimmysawczuk/kit/web/auth"
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/rs/zerolog"
+)
+
+// Policy decides whether a principal may make a request.
+type Policy interface {
+	Allow(r *http.Request, p auth.Principal) bool
+
+	// String describes the policy, i.e. "scope:orders:write".
+	String() string
+}
+
+type scopes struct {
+	scopes []string
+	any    bool
+}
+
+// Scopes returns a Policy requiring every one of the scopes.
+func Scopes(s ...string) Policy {
+	return scopes{scopes: s}
+}
+
+// AnyScope returns a Policy requiring at least one of the scopes.
+func AnyScope(s ...string) Policy {
+	return scopes{scopes: s, any: true}
+}
+
+func (s scopes) Allow(_ *http.Request, p auth.Principal) bool {
+	if s.any {
+		return slices.ContainsFunc(s.scopes, p.HasScope)
+	}
+
+	for _, v := range s.scopes {
+		if !p.HasScope(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s scopes) String() string {
+	if s.any {
+		return "scope:" + strings.Join(s.scopes, "|")
+	}
+	return "scope:" + strings.Join(s.scopes, "+")
+}
+
+type roles []string
+
+// Roles returns a Policy requiring at least one of the roles.
+func Roles(r ...string) Policy {
+	return roles(r)
+}
+
+func (r roles) Allow(_ *http.Request, p auth.Principal) bool {
+	return slices.ContainsFunc(r, p.HasRole)
+}
+
+func (r roles) String() string {
+	return "role:" + strings.Join(r, "|")
+}
+
+type predicate struct {
+	name string
+	fn   func(*http.Request, auth.Principal) bool
+}
+
+// Predicate returns a Policy that allows a request when fn returns true. name describes the policy.
+func Predicate(name string, fn func(*http.Request, auth.Principal) bool) Policy {
+	return predicate{name: name, fn: fn}
+}
+
+func (p predicate) Allow(r *http.Request, pr auth.Principal) bool {
+	return p.fn(r, pr)
+}
+
+func (p predicate) String() string {
+	return p.name
+}
+
+type all []Policy
+
+// All returns a Policy that allows a request only if every one of policies does.
+func All(policies ...Policy) Policy {
+	return all(policies)
+}
+
+func (a all) Allow(r *http.Request, p auth.Principal) bool {
+	for _, v := range a {
+		if !v.Allow(r, p) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a all) String() string {
+	s := make([]string, len(a))
+	for i, v := range a {
+		s[i] = v.String()
+	}
+	return strings.Join(s, " & ")
+}
+
+type anyOf []Policy
+
+// Any returns a Policy that allows a request if at least one of policies does.
+func Any(policies ...Policy) Policy {
+	return anyOf(policies)
+}
+
+func (a anyOf) Allow(r *http.Request, p auth.Principal) bool {
+	for _, v := range a {
+		if v.Allow(r, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a anyOf) String() string {
+	s := make([]string, len(a))
+	for i, v := range a {
+		s[i] = v.String()
+	}
+	return "(" + strings.Join(s, " | ") + ")"
+}
+
+// Require is a shortcut for Enforce(Scopes(scopes...)).
+func Require(scopes ...string) router.Middleware {
+	return Enforce(Scopes(scopes...))
+}
+
+// RequireRole is a shortcut for Enforce(Roles(roles...)).
+func RequireRole(roles ...string) router.Middleware {
+	return Enforce(Roles(roles...))
+}
+
+// Enforce returns middleware that only lets requests through if policy allows the request's principal.
+// Unauthenticated requests get a 401 and denied requests a 403. Every decision is logged.
+func Enforce(policy Policy) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return &enforcer{policy: policy, next: next}
+	}
+}
+
+type enforcer struct {
+	policy Policy
+	next   http.Handler
+}
+
+var _ router.Annotator = &enforcer{}
+
+// Annotations implements router.Annotator.
+func (e *enforcer) Annotations() []string {
+	return []string{e.policy.String()}
+}
+
+func (e *enforcer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	p, ok := auth.Get(ctx)
+	if !ok {
+		audit(ctx, r, e.policy, p, "unauthenticated")
+		respond.CodedError(ctx, http.StatusUnauthorized, "UNAUTHENTICATED", errors.New("authentication required")).Write(w)
+		return
+	}
+
+	if !e.policy.Allow(r, p) {
+		audit(ctx, r, e.policy, p, "deny")
+
+		err := respond.ErrWithInfo(errors.New("permission denied"), struct {
+			Required string `json:"required"`
+		}{
+			Required: e.policy.String(),
+		})
+
+		respond.CodedError(ctx, http.StatusForbidden, "FORBIDDEN", err).Write(w)
+		return
+	}
+
+	audit(ctx, r, e.policy, p, "allow")
+	e.next.ServeHTTP(w, r)
+}
+
+func audit(ctx context.Context, r *http.Request, policy Policy, p auth.Principal, decision string) {
+	log := zerolog.Ctx(ctx)
+
+	ev := log.Info()
+	if decision != "allow" {
+		ev = log.Warn()
+	}
+
+	ev.Str("@authz.decision", decision).
+		Str("@authz.policy", policy.String()).
+		Str("@authz.sub", p.Subject).
+		Str("@authz.method", p.Method).
+		Str("@req.method", r.Method).
+		Str("@req.path", r.URL.Path).
+		Msg("authz: " + decision)
+}
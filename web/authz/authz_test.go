@@ -0,0 +1,107 @@
+package authz_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jimmysawczuk/kit/web"
+	"github.com/jimmysawczuk/kit/web/auth"
+	"github.com/jimmysawczuk/kit/web/authz"
+	"github.com/jimmysawczuk/kit/web/middleware"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/stretchr/testify/require"
+)
+
+// withPrincipal authenticates requests as the principal described by the X-Test-* headers.
+func withPrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sub := r.Header.Get("X-Test-Sub")
+		if sub == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := auth.Set(r.Context(), auth.Principal{
+			Subject: sub,
+			Scopes:  strings.Fields(r.Header.Get("X-Test-Scopes")),
+			Roles:   strings.Fields(r.Header.Get("X-Test-Roles")),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type ordersModule struct{}
+
+func (ordersModule) Route(r router.Router) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	r.Getf("/orders", ok)
+	r.Postf("/orders", ok, authz.Require("orders:write"))
+	r.Deletef("/orders/{id}", ok, middleware.WithHeader("X-Test", "1"), authz.Enforce(authz.Any(
+		authz.Roles("admin"),
+		authz.Predicate("owner", func(r *http.Request, p auth.Principal) bool {
+			return p.Subject == r.URL.Query().Get("owner")
+		}),
+	)))
+}
+
+func newApp() *web.App {
+	r := router.New()
+	r.Use(withPrincipal)
+
+	return web.NewApp().WithRouter(r).WithModule(ordersModule{}, authz.Enforce(authz.AnyScope("orders:read", "orders:admin")))
+}
+
+func TestEnforce(t *testing.T) {
+	a := newApp()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		sub    string
+		scopes string
+		roles  string
+		status int
+		body   string
+	}{
+		{"UNAUTHENTICATED", "GET", "/orders", "", "", "", http.StatusUnauthorized, `"code":"UNAUTHENTICATED"`},
+		{"MODULE_SCOPE_MISSING", "GET", "/orders", "u1", "profile", "", http.StatusForbidden, `"info":{"required":"scope:orders:read|orders:admin"}`},
+		{"MODULE_SCOPE", "GET", "/orders", "u1", "orders:read", "", http.StatusOK, ""},
+		{"ROUTE_SCOPE_MISSING", "POST", "/orders", "u1", "orders:read", "", http.StatusForbidden, `"code":"FORBIDDEN"`},
+		{"ROUTE_SCOPE", "POST", "/orders", "u1", "orders:read orders:write", "", http.StatusOK, ""},
+		{"ROLE", "DELETE", "/orders/1", "u1", "orders:admin", "admin", http.StatusOK, ""},
+		{"PREDICATE", "DELETE", "/orders/1?owner=u1", "u1", "orders:admin", "", http.StatusOK, ""},
+		{"PREDICATE_DENIED", "DELETE", "/orders/1?owner=u2", "u1", "orders:admin", "", http.StatusForbidden, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, nil)
+			r.Header.Set("X-Test-Sub", test.sub)
+			r.Header.Set("X-Test-Scopes", test.scopes)
+			r.Header.Set("X-Test-Roles", test.roles)
+
+			w := httptest.NewRecorder()
+			a.ServeHTTP(w, r)
+
+			require.Equal(t, test.status, w.Code)
+			require.Contains(t, w.Body.String(), test.body)
+		})
+	}
+}
+
+func TestRoutesAnnotations(t *testing.T) {
+	got := map[string][]string{}
+	for _, r := range newApp().Routes() {
+		got[r.Method+" "+r.Path] = r.Annotations
+	}
+
+	require.Equal(t, map[string][]string{
+		"GET /orders":         {"scope:orders:read|orders:admin"},
+		"POST /orders":        {"scope:orders:read|orders:admin", "scope:orders:write"},
+		"DELETE /orders/{id}": {"scope:orders:read|orders:admin", "(role:admin | owner)"},
+	}, got)
+}
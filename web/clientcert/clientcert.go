@@ -0,0 +1,105 @@
+// Package clientcert extracts the identity of a client from its verified TLS certificate.
+package clientcert
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"strings"
+)
+
+type ctxKey int
+
+const (
+	identityKey ctxKey = 1 << iota
+)
+
+// Identity describes the subject of a verified client certificate.
+type Identity struct {
+	CommonName   string
+	URIs         []string
+	DNSNames     []string
+	SerialNumber string
+
+	Certificate *x509.Certificate
+}
+
+// FromRequest returns the Identity of the client that made r. It returns false if the connection isn't
+// TLS or the client's certificate wasn't verified against the server's client CAs.
+func FromRequest(r *http.Request) (Identity, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return Identity{}, false
+	}
+
+	return FromCertificate(r.TLS.VerifiedChains[0][0]), true
+}
+
+// FromCertificate returns the Identity described by cert.
+func FromCertificate(cert *x509.Certificate) Identity {
+	id := Identity{
+		CommonName:   cert.Subject.CommonName,
+		DNSNames:     cert.DNSNames,
+		SerialNumber: cert.SerialNumber.String(),
+		Certificate:  cert,
+	}
+
+	for _, u := range cert.URIs {
+		id.URIs = append(id.URIs, u.String())
+	}
+
+	return id
+}
+
+// SPIFFEID returns the first spiffe:// URI SAN on the certificate, or an empty string if there isn't one.
+func (i Identity) SPIFFEID() string {
+	for _, u := range i.URIs {
+		if strings.HasPrefix(u, "spiffe://") {
+			return u
+		}
+	}
+	return ""
+}
+
+// Name returns the most specific name for the identity: its SPIFFE ID if it has one, otherwise its
+// common name.
+func (i Identity) Name() string {
+	if id := i.SPIFFEID(); id != "" {
+		return id
+	}
+	return i.CommonName
+}
+
+// Matches reports whether any of the identity's common name or URI SANs match pattern. A pattern ending
+// in "*" matches any name with the preceding prefix, i.e. "spiffe://example.org/ns/prod/*".
+func (i Identity) Matches(pattern string) bool {
+	match := func(name string) bool {
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			return strings.HasPrefix(name, prefix)
+		}
+		return name == pattern
+	}
+
+	if i.CommonName != "" && match(i.CommonName) {
+		return true
+	}
+
+	for _, u := range i.URIs {
+		if match(u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Get attempts to get the client's Identity from the provided context.Context. It'll return false if
+// the context doesn't contain an Identity.
+func Get(ctx context.Context) (Identity, bool) {
+	v, ok := ctx.Value(identityKey).(Identity)
+	return v, ok
+}
+
+// Set returns a copy of the provided context.Context with the provided Identity as a value.
+func Set(parent context.Context, id Identity) context.Context {
+	return context.WithValue(parent, identityKey, id)
+}
@@ -0,0 +1,78 @@
+package clientcert_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/jimmysawczuk/kit/web/clientcert"
+	"github.com/stretchr/testify/require"
+)
+
+func testCertificate() *x509.Certificate {
+	u, _ := url.Parse("spiffe://example.org/ns/prod/sa/orders")
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "orders"},
+		URIs:         []*url.URL{u},
+		DNSNames:     []string{"orders.internal"},
+	}
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+
+	_, ok := clientcert.FromRequest(r)
+	require.False(t, ok)
+
+	cert := testCertificate()
+
+	// A presented but unverified certificate isn't an identity.
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	_, ok = clientcert.FromRequest(r)
+	require.False(t, ok)
+
+	r.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	id, ok := clientcert.FromRequest(r)
+	require.True(t, ok)
+	require.Equal(t, "orders", id.CommonName)
+	require.Equal(t, "42", id.SerialNumber)
+	require.Equal(t, []string{"orders.internal"}, id.DNSNames)
+	require.Equal(t, "spiffe://example.org/ns/prod/sa/orders", id.SPIFFEID())
+	require.Equal(t, "spiffe://example.org/ns/prod/sa/orders", id.Name())
+}
+
+func TestMatches(t *testing.T) {
+	id := clientcert.FromCertificate(testCertificate())
+
+	for _, tt := range []struct {
+		pattern string
+		want    bool
+	}{
+		{"orders", true},
+		{"order", false},
+		{"spiffe://example.org/ns/prod/sa/orders", true},
+		{"spiffe://example.org/ns/prod/*", true},
+		{"spiffe://example.org/ns/dev/*", false},
+		{"*", true},
+	} {
+		require.Equal(t, tt.want, id.Matches(tt.pattern), tt.pattern)
+	}
+}
+
+func TestSetAndGet(t *testing.T) {
+	ctx := context.Background()
+
+	_, ok := clientcert.Get(ctx)
+	require.False(t, ok)
+
+	ctx = clientcert.Set(ctx, clientcert.Identity{CommonName: "orders"})
+	id, ok := clientcert.Get(ctx)
+	require.True(t, ok)
+	require.Equal(t, "orders", id.CommonName)
+}
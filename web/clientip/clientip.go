@@ -0,0 +1,196 @@
+// Package clientip resolves the IP address of the client that originated a request, taking into account
+// any trusted proxies (load balancers, CDNs, API gateways) that the request passed through.
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type ctxKey int
+
+const (
+	clientIPKey ctxKey = 1 << iota
+)
+
+// Headers that can carry the client's address. Only X-Forwarded-For is consulted by default.
+const (
+	HeaderForwarded     = "Forwarded"
+	HeaderXForwardedFor = "X-Forwarded-For"
+	HeaderXRealIP       = "X-Real-Ip"
+)
+
+// ParsePrefixes parses a list of CIDRs (i.e. 10.0.0.0/8) or bare addresses into prefixes suitable for
+// Resolver.TrustedProxies.
+func ParsePrefixes(in ...string) ([]netip.Prefix, error) {
+	tbr := make([]netip.Prefix, 0, len(in))
+	for _, v := range in {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("netip: parse addr: %w", err)
+			}
+			tbr = append(tbr, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("netip: parse prefix: %w", err)
+		}
+		tbr = append(tbr, p.Masked())
+	}
+	return tbr, nil
+}
+
+// Resolver determines the client's IP address for a request. Forwarding headers are only believed when
+// the immediate peer is one of TrustedProxies; otherwise the peer's address is the client's address.
+type Resolver struct {
+	TrustedProxies []netip.Prefix
+
+	// Headers lists the forwarding headers to consult, in order. The first one that yields an address
+	// wins. If empty, only X-Forwarded-For is used, since that's the header proxies like ALB and CloudFront
+	// append to; they pass Forwarded and X-Real-Ip through from the client untouched. Only list Forwarded
+	// if every trusted proxy appends to it, and X-Real-Ip if the nearest one overwrites it, since its
+	// value is believed as-is.
+	Headers []string
+}
+
+// DefaultResolver trusts no proxies, so it always resolves to the immediate peer.
+var DefaultResolver = &Resolver{}
+
+var defaultHeaders = []string{HeaderXForwardedFor}
+
+func (res *Resolver) trusted(addr netip.Addr) bool {
+	for _, p := range res.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the client's IP address for r, or the zero netip.Addr if it can't be determined.
+func (res *Resolver) Resolve(r *http.Request) netip.Addr {
+	peer := parseAddr(r.RemoteAddr)
+	if !peer.IsValid() || !res.trusted(peer) {
+		return peer
+	}
+
+	headers := res.Headers
+	if len(headers) == 0 {
+		headers = defaultHeaders
+	}
+
+	for _, h := range headers {
+		var hops []netip.Addr
+
+		switch http.CanonicalHeaderKey(h) {
+		case HeaderForwarded:
+			hops = forwardedHops(r.Header.Values(h))
+		case HeaderXRealIP:
+			if addr := parseAddr(r.Header.Get(h)); addr.IsValid() {
+				return addr
+			}
+			continue
+		default:
+			hops = listHops(r.Header.Values(h))
+		}
+
+		if len(hops) == 0 {
+			continue
+		}
+
+		// Walk the chain from the nearest hop back toward the client; the first address we don't trust
+		// is the furthest we can believe.
+		for i := len(hops) - 1; i >= 0; i-- {
+			if !res.trusted(hops[i]) {
+				return hops[i]
+			}
+		}
+
+		return hops[0]
+	}
+
+	return peer
+}
+
+// listHops parses comma-separated address lists, i.e. X-Forwarded-For. Unparseable entries are dropped.
+func listHops(values []string) []netip.Addr {
+	var tbr []netip.Addr
+	for _, v := range values {
+		for _, part := range strings.Split(v, ",") {
+			if addr := parseAddr(part); addr.IsValid() {
+				tbr = append(tbr, addr)
+			}
+		}
+	}
+	return tbr
+}
+
+// forwardedHops parses the for= parameters of RFC 7239 Forwarded headers.
+func forwardedHops(values []string) []netip.Addr {
+	var tbr []netip.Addr
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(elem, ";") {
+				k, v, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(k, "for") {
+					continue
+				}
+
+				if addr := parseAddr(strings.Trim(v, `"`)); addr.IsValid() {
+					tbr = append(tbr, addr)
+				}
+			}
+		}
+	}
+	return tbr
+}
+
+// parseAddr parses an address that might have a port and/or IPv6 brackets attached.
+func parseAddr(in string) netip.Addr {
+	in = strings.TrimSpace(in)
+	if in == "" {
+		return netip.Addr{}
+	}
+
+	if host, _, err := net.SplitHostPort(in); err == nil {
+		in = host
+	}
+
+	in = strings.TrimSuffix(strings.TrimPrefix(in, "["), "]")
+
+	addr, err := netip.ParseAddr(in)
+	if err != nil {
+		return netip.Addr{}
+	}
+
+	return addr.Unmap()
+}
+
+// Resolve wraps DefaultResolver.Resolve.
+func Resolve(r *http.Request) netip.Addr {
+	return DefaultResolver.Resolve(r)
+}
+
+// Get attempts to get the client's IP address from the provided context.Context. It'll return the zero
+// netip.Addr if the context doesn't contain one.
+func Get(ctx context.Context) netip.Addr {
+	v, _ := ctx.Value(clientIPKey).(netip.Addr)
+	return v
+}
+
+// Set returns a copy of the provided context.Context with the provided address as a value.
+func Set(parent context.Context, addr netip.Addr) context.Context {
+	return context.WithValue(parent, clientIPKey, addr)
+}
@@ -0,0 +1,125 @@
+package clientip_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/jimmysawczuk/kit/web/clientip"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePrefixes(t *testing.T) {
+	p, err := clientip.ParsePrefixes("10.0.0.0/8", "192.168.1.1", " ", "2001:db8::/32")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.1/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}, p)
+
+	_, err = clientip.ParsePrefixes("not-an-ip")
+	require.Error(t, err)
+}
+
+func TestResolve(t *testing.T) {
+	trusted, err := clientip.ParsePrefixes("10.0.0.0/8")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		remote   string
+		headers  map[string]string
+		use      []string
+		expected string
+	}{
+		{
+			name:     "NO_HEADERS",
+			remote:   "203.0.113.5:1234",
+			expected: "203.0.113.5",
+		},
+		{
+			name:     "UNTRUSTED_PEER_IGNORES_HEADERS",
+			remote:   "203.0.113.5:1234",
+			headers:  map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			expected: "203.0.113.5",
+		},
+		{
+			name:     "X_FORWARDED_FOR",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.2"},
+			expected: "198.51.100.1",
+		},
+		{
+			name:     "X_FORWARDED_FOR_SPOOFED",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.2"},
+			expected: "198.51.100.1",
+		},
+		{
+			name:     "X_FORWARDED_FOR_ALL_TRUSTED",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			expected: "10.0.0.3",
+		},
+		{
+			name:     "FORWARDED",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https, for=10.0.0.2`},
+			use:      []string{"Forwarded", "X-Forwarded-For"},
+			expected: "2001:db8::1",
+		},
+		{
+			name:     "X_REAL_IP",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string]string{"X-Real-Ip": "198.51.100.7"},
+			use:      []string{"X-Real-Ip"},
+			expected: "198.51.100.7",
+		},
+		{
+			name:     "FORWARDED_SPOOFED",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string]string{"Forwarded": "for=1.2.3.4", "X-Forwarded-For": "198.51.100.1"},
+			expected: "198.51.100.1",
+		},
+		{
+			name:     "X_REAL_IP_SPOOFED",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string]string{"X-Real-Ip": "1.2.3.4", "X-Forwarded-For": "198.51.100.1"},
+			expected: "198.51.100.1",
+		},
+		{
+			name:     "NOT_OPTED_IN",
+			remote:   "10.0.0.1:1234",
+			headers:  map[string]string{"Forwarded": "for=1.2.3.4", "X-Real-Ip": "1.2.3.4"},
+			expected: "10.0.0.1",
+		},
+		{
+			name:     "BARE_REMOTE_ADDR",
+			remote:   "198.51.100.9",
+			expected: "198.51.100.9",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remote
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+
+			res := &clientip.Resolver{TrustedProxies: trusted, Headers: test.use}
+			require.Equal(t, test.expected, res.Resolve(r).String())
+		})
+	}
+}
+
+func TestSetAndGet(t *testing.T) {
+	ctx := context.Background()
+	require.False(t, clientip.Get(ctx).IsValid())
+
+	ctx = clientip.Set(ctx, netip.MustParseAddr("198.51.100.1"))
+	require.Equal(t, "198.51.100.1", clientip.Get(ctx).String())
+}
@@ -0,0 +1,335 @@
+// Package compress compresses responses using the best encoding a client accepts. Gzip is built in;
+// other encodings, i.e. brotli or zstd, can be plugged in with a Codec.
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jimmysawczuk/kit/internal/httpwrap"
+	"github.com/jimmysawczuk/kit/web/router"
+)
+
+// Writer is a compressing writer that can be reused. *gzip.Writer, and the writers from most brotli and
+// zstd packages, implement it.
+type Writer interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// Codec describes a content encoding.
+type Codec struct {
+	// Encoding is the Content-Encoding token, i.e. "br".
+	Encoding string
+
+	// New returns a Writer compressing to w. Writers are pooled and reused with Reset.
+	New func(w io.Writer) Writer
+}
+
+// Gzip returns a Codec for gzip at level, i.e. gzip.DefaultCompression.
+func Gzip(level int) Codec {
+	return Codec{
+		Encoding: "gzip",
+		New: func(w io.Writer) Writer {
+			gz, err := gzip.NewWriterLevel(w, level)
+			if err != nil {
+				// The level is invalid; fall back to the default rather than failing every response.
+				gz = gzip.NewWriter(w)
+			}
+			return gz
+		},
+	}
+}
+
+// DefaultSkipTypes are content types that are already compressed.
+var DefaultSkipTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/octet-stream",
+	"application/pdf",
+}
+
+// Options configures the compression middleware.
+type Options struct {
+	// Codecs lists the encodings to offer, most preferred first. Defaults to gzip at the default level.
+	Codecs []Codec
+
+	// MinSize is the smallest response that will be compressed, in bytes. Defaults to 1024.
+	MinSize int
+
+	// SkipTypes lists content type prefixes that won't be compressed. Defaults to DefaultSkipTypes.
+	// image/svg+xml is always compressed.
+	SkipTypes []string
+}
+
+type pooledCodec struct {
+	Codec
+	pool sync.Pool
+}
+
+// Middleware compresses responses according to the request's Accept-Encoding header and opts. Responses
+// that are too small, already compressed, or of a skipped type are sent as-is. Every response is marked
+// with Vary: Accept-Encoding.
+func Middleware(opts Options) router.Middleware {
+	if len(opts.Codecs) == 0 {
+		opts.Codecs = []Codec{Gzip(gzip.DefaultCompression)}
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = 1024
+	}
+	if opts.SkipTypes == nil {
+		opts.SkipTypes = DefaultSkipTypes
+	}
+
+	codecs := make([]*pooledCodec, len(opts.Codecs))
+	for i, c := range opts.Codecs {
+		codecs[i] = &pooledCodec{Codec: c}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			httpwrap.AddVary(w.Header(), "Accept-Encoding")
+
+			codec := negotiate(r.Header.Get("Accept-Encoding"), codecs)
+			if codec == nil || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				Writer:    httpwrap.Writer{ResponseWriter: w},
+				codec:     codec,
+				minSize:   opts.MinSize,
+				skipTypes: opts.SkipTypes,
+			}
+			defer cw.Close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiate picks the codec with the highest quality in accept, breaking ties by server preference.
+func negotiate(accept string, codecs []*pooledCodec) *pooledCodec {
+	if accept == "" {
+		return nil
+	}
+
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = f
+			}
+		}
+		qualities[strings.ToLower(strings.TrimSpace(name))] = q
+	}
+
+	var best *pooledCodec
+	bestQ := 0.0
+	for _, c := range codecs {
+		q, ok := qualities[c.Encoding]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if !ok || q <= bestQ {
+			continue
+		}
+
+		best, bestQ = c, q
+	}
+
+	return best
+}
+
+// compressWriter holds back the start of a response until it knows whether it's worth compressing.
+type compressWriter struct {
+	httpwrap.Writer
+
+	codec     *pooledCodec
+	minSize   int
+	skipTypes []string
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     Writer
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.status != 0 {
+		return
+	}
+
+	if code < 200 {
+		// Informational responses go straight out.
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	cw.status = code
+	if !cw.eligible() {
+		cw.decide(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.minSize {
+			return len(b), nil
+		}
+
+		cw.decide(true)
+		buf := cw.buf
+		cw.buf = nil
+		if _, err := cw.write(buf); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	return cw.write(b)
+}
+
+func (cw *compressWriter) write(b []byte) (int, error) {
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// eligible reports whether the response, as described by its status and headers so far, may be
+// compressed.
+func (cw *compressWriter) eligible() bool {
+	h := cw.Header()
+
+	switch cw.status {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+
+	if cl, err := strconv.Atoi(h.Get("Content-Length")); err == nil && cl < cw.minSize {
+		return false
+	}
+
+	ct := strings.ToLower(h.Get("Content-Type"))
+	if strings.HasPrefix(ct, "image/svg+xml") {
+		return true
+	}
+	for _, t := range cw.skipTypes {
+		if strings.HasPrefix(ct, t) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// decide writes the response's headers, compressing the body if compress is true. It's called before
+// the buffered start of the body is written.
+func (cw *compressWriter) decide(compress bool) {
+	if cw.decided {
+		return
+	}
+	cw.decided = true
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+
+	h := cw.Header()
+
+	// net/http sniffs the type of responses without one, but it can't once the body is compressed, so
+	// sniff it here, which also lets SkipTypes apply to it. Like net/http, a nil Content-Type opts out.
+	if _, ok := h["Content-Type"]; compress && !ok && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	if compress && cw.eligible() {
+		h.Set("Content-Encoding", cw.codec.Encoding)
+		h.Del("Content-Length")
+
+		// The compressed body is a different representation, so a strong validator no longer applies.
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		if enc, ok := cw.codec.pool.Get().(Writer); ok {
+			enc.Reset(cw.ResponseWriter)
+			cw.enc = enc
+		} else {
+			cw.enc = cw.codec.New(cw.ResponseWriter)
+		}
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does. Flushing commits to compressing
+// the response, however small it is so far, since more is presumably on its way.
+func (cw *compressWriter) Flush() {
+	httpwrap.Flush(cw.ResponseWriter, func() {
+		if !cw.decided {
+			cw.decide(true)
+			buf := cw.buf
+			cw.buf = nil
+			cw.write(buf)
+		}
+
+		if cw.enc != nil {
+			cw.enc.Flush()
+		}
+	})
+}
+
+// Close sends whatever is still buffered and returns the encoder to its pool.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.status == 0 && len(cw.buf) == 0 {
+			// The handler didn't write anything; let net/http write its default response.
+			return nil
+		}
+
+		cw.decide(false)
+		buf := cw.buf
+		cw.buf = nil
+		if _, err := cw.write(buf); err != nil {
+			return err
+		}
+	}
+
+	if cw.enc == nil {
+		return nil
+	}
+
+	err := cw.enc.Close()
+	cw.enc.Reset(io.Discard)
+	cw.codec.pool.Put(cw.enc)
+	cw.enc = nil
+
+	return err
+}
@@ -0,0 +1,151 @@
+package compress_test
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jimmysawczuk/kit/web/compress"
+	"github.com/stretchr/testify/require"
+)
+
+// deflate stands in for a pluggable codec like brotli or zstd.
+func deflate() compress.Codec {
+	return compress.Codec{
+		Encoding: "deflate",
+		New: func(w io.Writer) compress.Writer {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		},
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	large := strings.Repeat("hello, world! ", 200)
+
+	h := compress.Middleware(compress.Options{
+		Codecs: []compress.Codec{deflate(), compress.Gzip(gzip.BestSpeed)},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/small":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("tiny"))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(large))
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write([]byte(large))
+		case "/sniffed":
+			w.Write([]byte("<html>" + large))
+		case "/sniffed-image":
+			w.Write([]byte("\x89PNG\x0D\x0A\x1A\x0A" + large))
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("ETag", `"abc"`)
+			w.Write([]byte(large[:500]))
+			w.Write([]byte(large[500:]))
+		}
+	}))
+
+	tests := []struct {
+		name        string
+		path        string
+		accept      string
+		encoding    string
+		contentType string
+	}{
+		{"GZIP", "/", "gzip", "gzip", "text/plain"},
+		{"SERVER_PREFERENCE", "/", "gzip, deflate", "deflate", "text/plain"},
+		{"CLIENT_QUALITY", "/", "gzip;q=1.0, deflate;q=0.5", "gzip", "text/plain"},
+		{"WILDCARD", "/", "*", "deflate", "text/plain"},
+		{"REFUSED", "/", "gzip;q=0, deflate;q=0", "", "text/plain"},
+		{"NONE", "/", "", "", "text/plain"},
+		{"UNSUPPORTED", "/", "br", "", "text/plain"},
+		{"SMALL", "/small", "gzip", "", "text/plain"},
+		{"SKIPPED_TYPE", "/image", "gzip", "", "image/png"},
+		{"ALREADY_ENCODED", "/encoded", "deflate", "gzip", ""},
+		{"SNIFFED_TYPE", "/sniffed", "gzip", "gzip", "text/html; charset=utf-8"},
+		{"SNIFFED_SKIPPED_TYPE", "/sniffed-image", "gzip", "", "image/png"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", test.path, nil)
+			if test.accept != "" {
+				r.Header.Set("Accept-Encoding", test.accept)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			require.Equal(t, test.encoding, w.Header().Get("Content-Encoding"))
+			if test.contentType != "" {
+				require.Equal(t, test.contentType, w.Header().Get("Content-Type"))
+			}
+			require.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+			var body io.Reader = w.Body
+			switch {
+			case test.path == "/encoded":
+				return
+			case test.encoding == "gzip":
+				gz, err := gzip.NewReader(w.Body)
+				require.NoError(t, err)
+				body = gz
+			case test.encoding == "deflate":
+				body = flate.NewReader(w.Body)
+			}
+
+			by, err := io.ReadAll(body)
+			require.NoError(t, err)
+
+			switch test.path {
+			case "/":
+				require.Equal(t, large, string(by))
+				if test.encoding != "" {
+					require.Less(t, w.Body.Len(), len(large))
+					require.Equal(t, `W/"abc"`, w.Header().Get("ETag"))
+				}
+			case "/small":
+				require.Equal(t, "tiny", string(by))
+			case "/sniffed":
+				require.Equal(t, "<html>"+large, string(by))
+			}
+		})
+	}
+}
+
+func TestFlush(t *testing.T) {
+	srv := httptest.NewServer(compress.Middleware(compress.Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		for _, msg := range []string{"one", "two"} {
+			w.Write([]byte("data: " + msg + "\n\n"))
+			w.(http.Flusher).Flush()
+		}
+	})))
+	t.Cleanup(srv.Close)
+
+	req, err := http.NewRequest("GET", srv.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+
+	gz, err := gzip.NewReader(resp.Body)
+	require.NoError(t, err)
+
+	by, err := io.ReadAll(gz)
+	require.NoError(t, err)
+	require.Equal(t, "data: one\n\ndata: two\n\n", string(by))
+}
@@ -0,0 +1,235 @@
+// Package concurrency caps the number of requests that are handled at once, queueing briefly and then
+// shedding load once the cap is reached.
+package concurrency
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/rs/zerolog"
+)
+
+// Options configures a Limiter.
+type Options struct {
+	// Name identifies the Limiter in health check output. Defaults to "concurrency".
+	Name string
+
+	// Limit is the maximum number of requests handled at once. With Adaptive set, it's the starting limit.
+	Limit int
+
+	// MaxQueue is the maximum number of requests waiting for a slot. Requests beyond that are shed immediately.
+	MaxQueue int
+
+	// MaxWait is how long a request waits in the queue before being shed.
+	MaxWait time.Duration
+
+	// RetryAfter is sent to shed clients. Defaults to 1 second.
+	RetryAfter time.Duration
+
+	// Adaptive, if set, adjusts the limit based on observed latency.
+	Adaptive *AIMD
+}
+
+// AIMD adjusts a Limiter's limit with additive increase and multiplicative decrease: every request that
+// completes within Target nudges the limit up (by one per limit's worth of requests), and every request
+// that takes longer cuts it by Backoff.
+type AIMD struct {
+	MinLimit int
+	MaxLimit int
+
+	// Target is the latency above which the limit is decreased.
+	Target time.Duration
+
+	// Backoff is the factor the limit is multiplied by when a request exceeds Target. Defaults to 0.9.
+	Backoff float64
+}
+
+// Stats describes the current state of a Limiter.
+type Stats struct {
+	Limit    int   `json:"limit"`
+	InFlight int   `json:"inFlight"`
+	Queued   int   `json:"queued"`
+	Shed     int64 `json:"shed"`
+}
+
+// Limiter caps the number of requests in flight. Use a separate Limiter per route group to cap each group
+// separately.
+type Limiter struct {
+	opts Options
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	queue    *list.List
+	shed     int64
+}
+
+// New returns a Limiter configured with opts.
+func New(opts Options) *Limiter {
+	if opts.Name == "" {
+		opts.Name = "concurrency"
+	}
+
+	if opts.Limit <= 0 {
+		opts.Limit = 1
+	}
+
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = time.Second
+	}
+
+	if a := opts.Adaptive; a != nil {
+		if a.MinLimit <= 0 {
+			a.MinLimit = 1
+		}
+		if a.MaxLimit < a.MinLimit {
+			a.MaxLimit = max(a.MinLimit, opts.Limit)
+		}
+		if a.Backoff <= 0 || a.Backoff >= 1 {
+			a.Backoff = 0.9
+		}
+	}
+
+	return &Limiter{
+		opts:  opts,
+		limit: float64(opts.Limit),
+		queue: list.New(),
+	}
+}
+
+// waiter is a request waiting in the queue for a slot. granted is closed once it's been given one.
+type waiter struct {
+	granted chan struct{}
+}
+
+// acquire takes a slot, waiting in the queue if necessary. It returns false if the request should be shed.
+func (l *Limiter) acquire(ctx context.Context) bool {
+	l.mu.Lock()
+
+	if l.inFlight < int(l.limit) {
+		l.inFlight++
+		l.mu.Unlock()
+		return true
+	}
+
+	if l.opts.MaxWait <= 0 || l.queue.Len() >= l.opts.MaxQueue {
+		l.shed++
+		l.mu.Unlock()
+		return false
+	}
+
+	w := &waiter{granted: make(chan struct{})}
+	elem := l.queue.PushBack(w)
+	l.mu.Unlock()
+
+	t := time.NewTimer(l.opts.MaxWait)
+	defer t.Stop()
+
+	select {
+	case <-w.granted:
+		return true
+	case <-t.C:
+	case <-ctx.Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// We might have been granted a slot while we were giving up on waiting for one.
+	select {
+	case <-w.granted:
+		return true
+	default:
+	}
+
+	l.queue.Remove(elem)
+	l.shed++
+	return false
+}
+
+// release gives up a slot, adjusting the limit if it's adaptive and handing the slot to the next waiter.
+func (l *Limiter) release(latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+
+	if a := l.opts.Adaptive; a != nil {
+		if latency > a.Target {
+			l.limit = math.Max(float64(a.MinLimit), l.limit*a.Backoff)
+		} else {
+			l.limit = math.Min(float64(a.MaxLimit), l.limit+1/l.limit)
+		}
+	}
+
+	for l.queue.Len() > 0 && l.inFlight < int(l.limit) {
+		w := l.queue.Remove(l.queue.Front()).(*waiter)
+		l.inFlight++
+		close(w.granted)
+	}
+}
+
+// Middleware caps the number of requests in flight through the wrapped handler, shedding requests that
+// can't get a slot within MaxWait with a 503.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		if !l.acquire(ctx) {
+			zerolog.Ctx(ctx).Warn().
+				Str("limiter", l.opts.Name).
+				Msg("concurrency: request shed")
+
+			resp := respond.CodedError(ctx, http.StatusServiceUnavailable, "OVERLOADED", errors.New("too many requests in flight"))
+			resp.WithHeader(func(h http.Header) http.Header {
+				h.Set("Retry-After", strconv.Itoa(int(math.Ceil(l.opts.RetryAfter.Seconds()))))
+				return h
+			})
+			resp.Write(w)
+			return
+		}
+
+		start := time.Now()
+		defer func() {
+			l.release(time.Since(start))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Stats returns the current state of the Limiter.
+func (l *Limiter) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return Stats{
+		Limit:    int(l.limit),
+		InFlight: l.inFlight,
+		Queued:   l.queue.Len(),
+		Shed:     l.shed,
+	}
+}
+
+// Name implements web.HealthChecker.
+func (l *Limiter) Name() string {
+	return l.opts.Name
+}
+
+// HealthCheck implements web.HealthChecker. Shedding load is the Limiter working as intended, so it's
+// always healthy; its Stats are reported through HealthDetails.
+func (l *Limiter) HealthCheck(_ context.Context) error {
+	return nil
+}
+
+// HealthDetails implements web.HealthDetailer.
+func (l *Limiter) HealthDetails(_ context.Context) any {
+	return l.Stats()
+}
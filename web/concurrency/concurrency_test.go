@@ -0,0 +1,155 @@
+package concurrency_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web"
+	"github.com/jimmysawczuk/kit/web/concurrency"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ web.HealthChecker  = &concurrency.Limiter{}
+	_ web.HealthDetailer = &concurrency.Limiter{}
+)
+
+// blockingHandler returns a handler that blocks until release is closed, signalling started as each
+// request begins.
+func blockingHandler(started chan<- struct{}, release <-chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+}
+
+func serve(h http.Handler) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	return w
+}
+
+func TestLimiterSheds(t *testing.T) {
+	l := concurrency.New(concurrency.Options{Limit: 1, RetryAfter: 2 * time.Second})
+
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	h := l.Middleware(blockingHandler(started, release))
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		serve(h)
+	}()
+	<-started
+
+	require.Equal(t, 1, l.Stats().InFlight)
+
+	w := serve(h)
+	require.Equal(t, http.StatusServiceUnavailable, w.Code)
+	require.Equal(t, "2", w.Header().Get("Retry-After"))
+	require.Contains(t, w.Body.String(), `"code":"OVERLOADED"`)
+
+	close(release)
+	wg.Wait()
+
+	stats := l.Stats()
+	require.Equal(t, 0, stats.InFlight)
+	require.EqualValues(t, 1, stats.Shed)
+}
+
+func TestLimiterQueues(t *testing.T) {
+	l := concurrency.New(concurrency.Options{Limit: 1, MaxQueue: 1, MaxWait: 5 * time.Second})
+
+	started, release := make(chan struct{}, 2), make(chan struct{})
+	h := l.Middleware(blockingHandler(started, release))
+
+	codes := make(chan int, 2)
+	for range 2 {
+		go func() {
+			codes <- serve(h).Code
+		}()
+	}
+
+	<-started
+	require.Eventually(t, func() bool { return l.Stats().Queued == 1 }, time.Second, time.Millisecond)
+
+	// The queue is full, so this one is shed straight away.
+	require.Equal(t, http.StatusServiceUnavailable, serve(h).Code)
+
+	close(release)
+	<-started
+
+	require.Equal(t, http.StatusOK, <-codes)
+	require.Equal(t, http.StatusOK, <-codes)
+}
+
+func TestLimiterQueueTimeout(t *testing.T) {
+	l := concurrency.New(concurrency.Options{Limit: 1, MaxQueue: 1, MaxWait: 10 * time.Millisecond})
+
+	started, release := make(chan struct{}, 1), make(chan struct{})
+	h := l.Middleware(blockingHandler(started, release))
+
+	go serve(h)
+	<-started
+
+	require.Equal(t, http.StatusServiceUnavailable, serve(h).Code)
+	require.Equal(t, 0, l.Stats().Queued)
+
+	close(release)
+}
+
+func TestLimiterAdaptive(t *testing.T) {
+	l := concurrency.New(concurrency.Options{
+		Limit: 10,
+		Adaptive: &concurrency.AIMD{
+			MinLimit: 2,
+			MaxLimit: 11,
+			Target:   5 * time.Millisecond,
+			Backoff:  0.5,
+		},
+	})
+
+	slow := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+	}))
+	fast := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve(slow)
+	require.Equal(t, 5, l.Stats().Limit)
+
+	serve(slow)
+	serve(slow)
+	require.Equal(t, 2, l.Stats().Limit)
+
+	for range 100 {
+		serve(fast)
+	}
+	require.Equal(t, 11, l.Stats().Limit)
+}
+
+func TestLimiterHealthDetails(t *testing.T) {
+	l := concurrency.New(concurrency.Options{Name: "api", Limit: 4})
+
+	srv := httptest.NewServer(web.HealthCheckHandler(l))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var target struct {
+		Healthy bool                         `json:"healthy"`
+		Details map[string]concurrency.Stats `json:"details"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&target))
+	require.True(t, target.Healthy)
+	require.Equal(t, concurrency.Stats{Limit: 4}, target.Details["api"])
+
+	require.NoError(t, l.HealthCheck(context.Background()))
+}
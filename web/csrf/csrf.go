@@ -0,0 +1,310 @@
+// Package csrf protects cookie-authenticated endpoints from cross-site request forgery. Unsafe requests
+// must come from a trusted origin, as reported by the Sec-Fetch-Site, Origin or Referer headers, and
+// must carry a double-submit token matching the one in the CSRF cookie.
+package csrf
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/rs/zerolog"
+)
+
+type ctxKey int
+
+const (
+	tokenKey ctxKey = 1 << iota
+)
+
+// tokenLength is the length of a raw token, in bytes.
+const tokenLength = 32
+
+var (
+	// ErrOriginMismatch is returned when an unsafe request comes from an untrusted origin.
+	ErrOriginMismatch = errors.New("csrf: origin not trusted")
+
+	// ErrTokenMissing is returned when an unsafe request doesn't carry a token.
+	ErrTokenMissing = errors.New("csrf: token missing")
+
+	// ErrTokenInvalid is returned when an unsafe request's token doesn't match its cookie.
+	ErrTokenInvalid = errors.New("csrf: token invalid")
+)
+
+// Options configures the CSRF middleware.
+type Options struct {
+	// Cookie is the template for the token cookie. Name defaults to "csrf_token", Path to "/" and
+	// SameSite to Lax. The cookie is readable by scripts, so single-page apps can echo it in a header.
+	Cookie http.Cookie
+
+	// Header is the request header carrying the token. Defaults to "X-CSRF-Token". Safe requests also
+	// get the current token in this response header.
+	Header string
+
+	// FormField is the form field carrying the token for form posts. Defaults to "csrf_token".
+	FormField string
+
+	// TrustedOrigins lists origins other than the request's own that may make unsafe requests, i.e.
+	// "https://admin.example.com".
+	TrustedOrigins []string
+
+	// Exempt lists route patterns, i.e. "/webhooks/*", that aren't checked. Use it for endpoints that
+	// authenticate requests some other way.
+	Exempt []string
+
+	// Skip exempts requests for which it returns true.
+	Skip func(*http.Request) bool
+
+	// DisableTokens turns off double-submit token checks, relying on origin verification alone. Only
+	// browsers that send Sec-Fetch-Site or Origin on every unsafe request are protected.
+	DisableTokens bool
+}
+
+func (o Options) cookieName() string {
+	if o.Cookie.Name == "" {
+		return "csrf_token"
+	}
+	return o.Cookie.Name
+}
+
+func (o Options) header() string {
+	if o.Header == "" {
+		return "X-CSRF-Token"
+	}
+	return o.Header
+}
+
+func (o Options) formField() string {
+	if o.FormField == "" {
+		return "csrf_token"
+	}
+	return o.FormField
+}
+
+func (o Options) exempt(r *http.Request) bool {
+	if o.Skip != nil && o.Skip(r) {
+		return true
+	}
+
+	return slices.ContainsFunc(o.Exempt, func(pattern string) bool {
+		return router.Route{Path: pattern}.Match(r.URL.Path)
+	})
+}
+
+// Middleware rejects unsafe requests (anything other than GET, HEAD, OPTIONS and TRACE) that fail CSRF
+// checks with a 403 and the code "CSRF_FAILED". Every request gets a token on its context, available via
+// Token and TemplateField, and a token cookie if it doesn't have one.
+func Middleware(opts Options) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			token := cookieToken(r, opts.cookieName())
+			if token == nil {
+				token = newToken()
+				setCookie(w, opts, token)
+			}
+
+			ctx = context.WithValue(ctx, tokenKey, token)
+			r = r.WithContext(ctx)
+
+			if safe(r.Method) {
+				w.Header().Set(opts.header(), mask(token))
+				w.Header().Add("Vary", "Cookie")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if opts.exempt(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if err := check(r, opts, token); err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).
+					Str("@req.method", r.Method).
+					Str("@req.path", r.URL.Path).
+					Str("@req.origin", r.Header.Get("Origin")).
+					Msg("csrf: request rejected")
+
+				respond.CodedError(ctx, http.StatusForbidden, "CSRF_FAILED", err).Write(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func safe(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+func check(r *http.Request, opts Options, token []byte) error {
+	if err := checkOrigin(r, opts); err != nil {
+		return err
+	}
+
+	if opts.DisableTokens {
+		return nil
+	}
+
+	sent := r.Header.Get(opts.header())
+	if sent == "" {
+		ct := r.Header.Get("Content-Type")
+		if strings.HasPrefix(ct, "application/x-www-form-urlencoded") || strings.HasPrefix(ct, "multipart/form-data") {
+			sent = r.PostFormValue(opts.formField())
+		}
+	}
+
+	if sent == "" {
+		return ErrTokenMissing
+	}
+
+	if !verify(sent, token) {
+		return ErrTokenInvalid
+	}
+
+	return nil
+}
+
+// checkOrigin verifies the request came from its own origin or a trusted one. Sec-Fetch-Site is
+// preferred; Origin and then Referer are used for browsers that don't send it. Requests with none of
+// them aren't from a browser, or are from one old enough that the token check has to suffice.
+func checkOrigin(r *http.Request, opts Options) error {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return nil
+	case "":
+	default:
+		// same-site and cross-site requests are only allowed from trusted origins.
+		if origin := r.Header.Get("Origin"); origin != "" && slices.Contains(opts.TrustedOrigins, origin) {
+			return nil
+		}
+		return fmt.Errorf("%w (sec-fetch-site: %s)", ErrOriginMismatch, r.Header.Get("Sec-Fetch-Site"))
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		ref, err := url.Parse(r.Referer())
+		if r.Referer() == "" || err != nil || ref.Host == "" {
+			if origin == "null" {
+				return fmt.Errorf("%w (origin: null)", ErrOriginMismatch)
+			}
+			return nil
+		}
+		origin = ref.Scheme + "://" + ref.Host
+	}
+
+	if slices.Contains(opts.TrustedOrigins, origin) {
+		return nil
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || !strings.EqualFold(u.Host, r.Host) {
+		return fmt.Errorf("%w (origin: %s)", ErrOriginMismatch, origin)
+	}
+
+	return nil
+}
+
+func newToken() []byte {
+	buf := make([]byte, tokenLength)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Errorf("crypto/rand: read: %w", err))
+	}
+	return buf
+}
+
+func cookieToken(r *http.Request, name string) []byte {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return nil
+	}
+
+	token, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil || len(token) != tokenLength {
+		return nil
+	}
+
+	return token
+}
+
+func setCookie(w http.ResponseWriter, opts Options, token []byte) {
+	c := opts.Cookie
+	c.Name = opts.cookieName()
+	c.Value = base64.RawURLEncoding.EncodeToString(token)
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.SameSite == 0 {
+		c.SameSite = http.SameSiteLaxMode
+	}
+
+	http.SetCookie(w, &c)
+}
+
+// mask encrypts token with a one-time pad, so the value embedded in pages differs on every response and
+// can't be recovered through compression side channels like BREACH.
+func mask(token []byte) string {
+	pad := newToken()
+	out := make([]byte, 2*tokenLength)
+	copy(out, pad)
+	subtle.XORBytes(out[tokenLength:], pad, token)
+	return base64.RawURLEncoding.EncodeToString(out)
+}
+
+// verify reports whether sent is a masked or unmasked copy of token.
+func verify(sent string, token []byte) bool {
+	by, err := base64.RawURLEncoding.DecodeString(sent)
+	if err != nil {
+		return false
+	}
+
+	switch len(by) {
+	case tokenLength:
+		return subtle.ConstantTimeCompare(by, token) == 1
+	case 2 * tokenLength:
+		unmasked := make([]byte, tokenLength)
+		subtle.XORBytes(unmasked, by[:tokenLength], by[tokenLength:])
+		return subtle.ConstantTimeCompare(unmasked, token) == 1
+	}
+
+	return false
+}
+
+// Token returns a masked token for the request to include in a form, page or JSON response. Clients send
+// it back in the configured header or form field. It'll return an empty string if the context wasn't
+// passed through Middleware.
+func Token(ctx context.Context) string {
+	token, ok := ctx.Value(tokenKey).([]byte)
+	if !ok {
+		return ""
+	}
+	return mask(token)
+}
+
+// TemplateField returns a hidden form input carrying the request's token, for use in html/template.
+func TemplateField(ctx context.Context) template.HTML {
+	return TemplateFieldNamed(ctx, "csrf_token")
+}
+
+// TemplateFieldNamed is like TemplateField, for a Middleware configured with a custom FormField.
+func TemplateFieldNamed(ctx context.Context, field string) template.HTML {
+	return template.HTML(fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`,
+		template.HTMLEscapeString(field), Token(ctx)))
+}
@@ -0,0 +1,212 @@
+package csrf_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/jimmysawczuk/kit/web/csrf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	h := csrf.Middleware(csrf.Options{
+		TrustedOrigins: []string{"https://admin.example.com"},
+		Exempt:         []string{"/webhooks/*"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	// Fetch a token and its cookie the way a browser would.
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "https://example.com/form", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	cookie := cookies[0]
+	token := w.Header().Get("X-CSRF-Token")
+	require.NotEmpty(t, token)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		form    url.Values
+		cookie  bool
+		status  int
+		body    string
+	}{
+		{
+			name:   "SAFE_METHOD",
+			method: "GET",
+			path:   "/orders",
+			headers: map[string]string{
+				"Sec-Fetch-Site": "cross-site",
+			},
+			status: http.StatusOK,
+		},
+		{
+			name:   "HEADER_TOKEN",
+			method: "POST",
+			path:   "/orders",
+			headers: map[string]string{
+				"Sec-Fetch-Site": "same-origin",
+				"X-CSRF-Token":   token,
+			},
+			cookie: true,
+			status: http.StatusOK,
+		},
+		{
+			name:   "UNMASKED_COOKIE_TOKEN",
+			method: "POST",
+			path:   "/orders",
+			headers: map[string]string{
+				"Origin":       "https://example.com",
+				"X-CSRF-Token": cookie.Value,
+			},
+			cookie: true,
+			status: http.StatusOK,
+		},
+		{
+			name:   "FORM_TOKEN",
+			method: "POST",
+			path:   "/orders",
+			headers: map[string]string{
+				"Origin": "https://example.com",
+			},
+			form:   url.Values{"csrf_token": {token}},
+			cookie: true,
+			status: http.StatusOK,
+		},
+		{
+			name:   "TRUSTED_ORIGIN",
+			method: "DELETE",
+			path:   "/orders/1",
+			headers: map[string]string{
+				"Sec-Fetch-Site": "same-site",
+				"Origin":         "https://admin.example.com",
+				"X-CSRF-Token":   token,
+			},
+			cookie: true,
+			status: http.StatusOK,
+		},
+		{
+			name:   "CROSS_SITE",
+			method: "POST",
+			path:   "/orders",
+			headers: map[string]string{
+				"Sec-Fetch-Site": "cross-site",
+				"Origin":         "https://evil.example.net",
+				"X-CSRF-Token":   token,
+			},
+			cookie: true,
+			status: http.StatusForbidden,
+			body:   "csrf: origin not trusted",
+		},
+		{
+			name:   "FOREIGN_ORIGIN",
+			method: "POST",
+			path:   "/orders",
+			headers: map[string]string{
+				"Origin":       "https://evil.example.net",
+				"X-CSRF-Token": token,
+			},
+			cookie: true,
+			status: http.StatusForbidden,
+			body:   "csrf: origin not trusted",
+		},
+		{
+			name:   "FOREIGN_REFERER",
+			method: "POST",
+			path:   "/orders",
+			headers: map[string]string{
+				"Referer":      "https://evil.example.net/page",
+				"X-CSRF-Token": token,
+			},
+			cookie: true,
+			status: http.StatusForbidden,
+			body:   "csrf: origin not trusted",
+		},
+		{
+			name:   "MISSING_TOKEN",
+			method: "POST",
+			path:   "/orders",
+			headers: map[string]string{
+				"Sec-Fetch-Site": "same-origin",
+			},
+			cookie: true,
+			status: http.StatusForbidden,
+			body:   "csrf: token missing",
+		},
+		{
+			name:   "MISSING_COOKIE",
+			method: "POST",
+			path:   "/orders",
+			headers: map[string]string{
+				"Sec-Fetch-Site": "same-origin",
+				"X-CSRF-Token":   token,
+			},
+			status: http.StatusForbidden,
+			body:   "csrf: token invalid",
+		},
+		{
+			name:   "EXEMPT",
+			method: "POST",
+			path:   "/webhooks/stripe",
+			headers: map[string]string{
+				"Sec-Fetch-Site": "cross-site",
+			},
+			status: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var r *http.Request
+			if test.form != nil {
+				r = httptest.NewRequest(test.method, "https://example.com"+test.path, strings.NewReader(test.form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				r = httptest.NewRequest(test.method, "https://example.com"+test.path, nil)
+			}
+
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+			if test.cookie {
+				r.AddCookie(cookie)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			require.Equal(t, test.status, w.Code)
+			if test.status != http.StatusOK {
+				require.Contains(t, w.Body.String(), `"code":"CSRF_FAILED"`)
+				require.Contains(t, w.Body.String(), test.body)
+			}
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	require.Empty(t, csrf.Token(context.Background()))
+
+	var first, second string
+	var field string
+	h := csrf.Middleware(csrf.Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first, second = csrf.Token(r.Context()), csrf.Token(r.Context())
+		field = string(csrf.TemplateField(r.Context()))
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	require.NotEmpty(t, first)
+	require.NotEqual(t, first, second, "tokens should be masked differently each time")
+	require.Contains(t, field, `<input type="hidden" name="csrf_token" value="`)
+}
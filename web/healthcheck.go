@@ -9,6 +9,12 @@ type HealthChecker interface {
 	HealthCheck(context.Context) error
 }
 
+// HealthDetailer is an optional interface that HealthCheckers can implement to include details about
+// their state, i.e. counters or gauges, in the health check output.
+type HealthDetailer interface {
+	HealthDetails(context.Context) any
+}
+
 type HealthCheckerFunc func(context.Context) error
 
 func (h HealthCheckerFunc) HealthCheck(ctx context.Context) error {
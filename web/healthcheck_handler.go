@@ -16,6 +16,7 @@ import (
 func HealthCheckHandler(hc ...HealthChecker) Handler {
 	return func(ctx context.Context, log *zerolog.Logger, w http.ResponseWriter, r *http.Request) {
 		m := sync.Map{}
+		details := sync.Map{}
 
 		wg := sync.WaitGroup{}
 		wg.Add(len(hc))
@@ -36,6 +37,10 @@ func HealthCheckHandler(hc ...HealthChecker) Handler {
 
 				m.Store(h.Name(), err)
 
+				if d, ok := h.(HealthDetailer); ok {
+					details.Store(h.Name(), d.HealthDetails(ctx))
+				}
+
 				wg.Done()
 			}()
 		}
@@ -68,6 +73,15 @@ func HealthCheckHandler(hc ...HealthChecker) Handler {
 			return true
 		})
 
+		var detailed map[string]any
+		details.Range(func(key, value any) bool {
+			if detailed == nil {
+				detailed = map[string]any{}
+			}
+			detailed[key.(string)] = value
+			return true
+		})
+
 		code := http.StatusOK
 		if !healthy {
 			code = http.StatusServiceUnavailable
@@ -78,11 +92,13 @@ func HealthCheckHandler(hc ...HealthChecker) Handler {
 			Code    int             `json:"code"`
 			Time    time.Time       `json:"time"`
 			Results map[string]bool `json:"results"`
+			Details map[string]any  `json:"details,omitempty"`
 		}{
 			Healthy: healthy,
 			Code:    code,
 			Time:    time.Now().UTC(),
 			Results: results,
+			Details: detailed,
 		}).Write(w)
 	}
 }
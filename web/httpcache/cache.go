@@ -0,0 +1,314 @@
+package httpcache
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jimmysawczuk/kit/internal/httpwrap"
+	"github.com/jimmysawczuk/kit/web/requestid"
+)
+
+// Options configures a Cache.
+type Options struct {
+	// MaxEntries is the most responses kept at once; the least recently used are evicted first. Defaults
+	// to 1024.
+	MaxEntries int
+
+	// MaxEntrySize is the largest response body that will be cached. Defaults to 1 MiB.
+	MaxEntrySize int
+
+	// TTL is how long responses without a max-age or s-maxage directive are cached. Defaults to 1 minute.
+	TTL time.Duration
+
+	// Vary lists request headers whose values distinguish cached responses, i.e. "Accept-Language".
+	// Responses whose Vary header names any other header aren't cached.
+	Vary []string
+
+	// CredentialHeaders lists request headers that identify a user. Requests carrying any of them bypass
+	// the cache, so one user's response is never served to another, unless the header is also listed in
+	// Vary. Defaults to Authorization, Cookie and X-Api-Key.
+	CredentialHeaders []string
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+type entry struct {
+	key     string
+	pattern string
+	status  int
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+// Cache is an in-memory LRU cache of GET responses, shared by every route it's attached to.
+type Cache struct {
+	opts Options
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// New returns an empty Cache.
+func New(opts Options) *Cache {
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = 1024
+	}
+	if opts.MaxEntrySize <= 0 {
+		opts.MaxEntrySize = 1 << 20
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = time.Minute
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	if opts.CredentialHeaders == nil {
+		opts.CredentialHeaders = []string{"Authorization", "Cookie", "X-Api-Key"}
+	}
+	for i, v := range opts.Vary {
+		opts.Vary[i] = http.CanonicalHeaderKey(v)
+	}
+	for i, v := range opts.CredentialHeaders {
+		opts.CredentialHeaders[i] = http.CanonicalHeaderKey(v)
+	}
+
+	return &Cache{
+		opts:    opts,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// Len returns the number of cached responses.
+func (c *Cache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+// Purge removes the cached responses for every path matching the route pattern, i.e. "/users/{id}",
+// returning how many were removed. An empty pattern removes everything.
+func (c *Cache) Purge(pattern string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	n := 0
+	for key, el := range c.entries {
+		if pattern == "" || el.Value.(*entry).pattern == pattern {
+			c.lru.Remove(el)
+			delete(c.entries, key)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *Cache) key(r *http.Request) string {
+	var sb strings.Builder
+	sb.WriteString(r.Method)
+	sb.WriteByte(' ')
+	sb.WriteString(r.Host)
+	sb.WriteString(r.URL.RequestURI())
+	for _, h := range c.opts.Vary {
+		sb.WriteByte('\n')
+		sb.WriteString(h)
+		sb.WriteByte(':')
+		sb.WriteString(strings.Join(r.Header.Values(h), ","))
+	}
+	return sb.String()
+}
+
+func (c *Cache) get(key string, now time.Time) (*entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	e := el.Value.(*entry)
+	if !now.Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+func (c *Cache) put(e *entry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[e.key] = c.lru.PushFront(e)
+
+	for c.lru.Len() > c.opts.MaxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*entry).key)
+	}
+}
+
+// ttl returns how long a response with header may be cached, or false if it mustn't be.
+func (c *Cache) ttl(header http.Header) (time.Duration, bool) {
+	if header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+
+	for _, v := range header.Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			h = http.CanonicalHeaderKey(strings.TrimSpace(h))
+			if h == "*" || !slices.Contains(c.opts.Vary, h) {
+				return 0, false
+			}
+		}
+	}
+
+	ttl, maxAge, sMaxAge := c.opts.TTL, -1, -1
+	for _, v := range header.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+			switch strings.ToLower(name) {
+			case "no-store", "no-cache", "private":
+				return 0, false
+			case "max-age":
+				maxAge, _ = strconv.Atoi(value)
+			case "s-maxage":
+				sMaxAge, _ = strconv.Atoi(value)
+			}
+		}
+	}
+
+	switch {
+	case sMaxAge >= 0:
+		ttl = time.Duration(sMaxAge) * time.Second
+	case maxAge >= 0:
+		ttl = time.Duration(maxAge) * time.Second
+	}
+
+	return ttl, ttl > 0
+}
+
+// credentialed reports whether r carries credentials that aren't part of the cache key.
+func (c *Cache) credentialed(r *http.Request) bool {
+	return slices.ContainsFunc(c.opts.CredentialHeaders, func(h string) bool {
+		return r.Header.Get(h) != "" && !slices.Contains(c.opts.Vary, h)
+	})
+}
+
+// Middleware serves GET and HEAD requests from the cache when it can, and caches successful responses
+// otherwise. Cached responses get an ETag if they don't have one and answer conditional requests with a
+// 304. Whether the cache was hit is recorded in the access log as @cache.status.
+func (c *Cache) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || c.credentialed(r) {
+			logStatus(r, "bypass")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// HEAD requests are answered from cached GET responses.
+		get := r.Clone(r.Context())
+		get.Method = http.MethodGet
+		key := c.key(get)
+		now := c.opts.Now()
+
+		noCache := strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache")
+		if e, ok := c.get(key, now); ok && !noCache {
+			logStatus(r, "hit")
+			c.serve(w, r, e, now)
+			return
+		}
+
+		logStatus(r, "miss")
+		w.Header().Set("X-Cache", "MISS")
+
+		bw := &bufferedWriter{Writer: httpwrap.Writer{ResponseWriter: w}, limit: c.opts.MaxEntrySize}
+		next.ServeHTTP(bw, r)
+
+		if bw.passthrough || bw.status != http.StatusOK || r.Method != http.MethodGet {
+			bw.send()
+			return
+		}
+
+		if w.Header().Get("ETag") == "" {
+			w.Header().Set("ETag", etag(bw.body.Bytes()))
+		}
+
+		if ttl, ok := c.ttl(w.Header()); ok {
+			var pattern string
+			if rctx := chi.RouteContext(r.Context()); rctx != nil {
+				pattern = rctx.RoutePattern()
+			}
+
+			c.put(&entry{
+				key:     key,
+				pattern: pattern,
+				status:  bw.status,
+				header:  storedHeader(r.Context(), w.Header()),
+				body:    slices.Clone(bw.body.Bytes()),
+				stored:  now,
+				expires: now.Add(ttl),
+			})
+		}
+
+		if notModified(r, w.Header()) {
+			writeNotModified(w)
+			return
+		}
+
+		bw.send()
+	})
+}
+
+// perRequestHeaders describe a single response, so they aren't replayed from the cache. The request ID
+// header is too.
+var perRequestHeaders = []string{"Date", "X-Cache", "Age"}
+
+func storedHeader(ctx context.Context, h http.Header) http.Header {
+	tbr := h.Clone()
+	for _, k := range perRequestHeaders {
+		tbr.Del(k)
+	}
+	tbr.Del(requestid.Header(ctx))
+	return tbr
+}
+
+func (c *Cache) serve(w http.ResponseWriter, r *http.Request, e *entry, now time.Time) {
+	h := w.Header()
+	for k, v := range e.header {
+		h[k] = slices.Clone(v)
+	}
+	h.Set("Age", strconv.Itoa(int(now.Sub(e.stored).Seconds())))
+	h.Set("X-Cache", "HIT")
+
+	if notModified(r, h) {
+		writeNotModified(w)
+		return
+	}
+
+	w.WriteHeader(e.status)
+	if r.Method != http.MethodHead {
+		w.Write(e.body)
+	}
+}
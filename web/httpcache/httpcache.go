@@ -0,0 +1,182 @@
+// Package httpcache implements HTTP caching for handlers: ETags and conditional requests, per-route
+// Cache-Control, and an in-memory LRU cache of whole GET responses.
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jimmysawczuk/kit/internal/httpwrap"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/rs/zerolog"
+)
+
+// maxBufferSize is the largest response body ETag buffers to compute a hash; larger responses are passed
+// through without one.
+const maxBufferSize = 1 << 20
+
+// CacheControl returns middleware that sets the Cache-Control header of a route's responses to value,
+// i.e. "public, max-age=60", unless the handler sets one itself.
+func CacheControl(value string) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", value)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ETag adds a weak ETag, computed from the body, to successful GET and HEAD responses that don't have one,
+// i.e. those written with respond.Success, and answers conditional requests whose If-None-Match or
+// If-Modified-Since validators still match with a 304. Responses that are flushed while being written, or
+// are larger than 1 MiB, are streamed as-is.
+func ETag(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		bw := &bufferedWriter{Writer: httpwrap.Writer{ResponseWriter: w}, limit: maxBufferSize}
+		next.ServeHTTP(bw, r)
+
+		if bw.passthrough {
+			return
+		}
+
+		if bw.status == http.StatusOK && w.Header().Get("ETag") == "" {
+			w.Header().Set("ETag", etag(bw.body.Bytes()))
+		}
+
+		if bw.status == http.StatusOK && notModified(r, w.Header()) {
+			writeNotModified(w)
+			return
+		}
+
+		bw.send()
+	})
+}
+
+func etag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `W/"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+}
+
+// notModified reports whether the client's cached copy, described by r's validators, matches a response
+// with header.
+func notModified(r *http.Request, header http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		tag := header.Get("ETag")
+		if tag == "" {
+			return false
+		}
+
+		for _, v := range strings.Split(inm, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || weakMatch(v, tag) {
+				return true
+			}
+		}
+
+		// If-Modified-Since is ignored when If-None-Match is present.
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	lm, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+
+	return !lm.Truncate(time.Second).After(ims)
+}
+
+// weakMatch compares entity tags using the weak comparison function from RFC 9110 section 8.8.3.2.
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// writeNotModified answers with a 304, dropping the headers that describe a body.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	for _, k := range []string{"Content-Type", "Content-Length", "Content-Encoding", "Transfer-Encoding"} {
+		h.Del(k)
+	}
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// logStatus records how the cache handled the request in the access log.
+func logStatus(r *http.Request, status string) {
+	zerolog.Ctx(r.Context()).UpdateContext(func(c zerolog.Context) zerolog.Context {
+		return c.Str("@cache.status", status)
+	})
+}
+
+// bufferedWriter holds a response back so it can be inspected before it's sent. If the handler flushes,
+// or the body grows past limit, whatever has been buffered is sent and the rest passes straight through.
+type bufferedWriter struct {
+	httpwrap.Writer
+
+	limit       int
+	status      int
+	body        bytes.Buffer
+	passthrough bool
+}
+
+func (bw *bufferedWriter) WriteHeader(code int) {
+	if bw.passthrough {
+		bw.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	if bw.status == 0 {
+		bw.status = code
+	}
+}
+
+func (bw *bufferedWriter) Write(b []byte) (int, error) {
+	if bw.status == 0 {
+		bw.WriteHeader(http.StatusOK)
+	}
+
+	if bw.passthrough {
+		return bw.ResponseWriter.Write(b)
+	}
+
+	if bw.body.Len()+len(b) > bw.limit {
+		bw.send()
+		return bw.ResponseWriter.Write(b)
+	}
+
+	return bw.body.Write(b)
+}
+
+// send writes the buffered response and switches to passing writes through.
+func (bw *bufferedWriter) send() {
+	if bw.passthrough {
+		return
+	}
+	bw.passthrough = true
+
+	if bw.status == 0 {
+		bw.status = http.StatusOK
+	}
+
+	bw.ResponseWriter.WriteHeader(bw.status)
+	if bw.body.Len() > 0 {
+		bw.ResponseWriter.Write(bw.body.Bytes())
+	}
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (bw *bufferedWriter) Flush() {
+	httpwrap.Flush(bw.ResponseWriter, bw.send)
+}
@@ -0,0 +1,154 @@
+package httpcache_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web/httpcache"
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETag(t *testing.T) {
+	lastModified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	h := httpcache.ETag(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dated":
+			w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+			w.Write([]byte("dated"))
+		case "/missing":
+			respond.CodedError(r.Context(), http.StatusNotFound, "NOT_FOUND", nil).Write(w)
+		default:
+			respond.Success(r.Context(), http.StatusOK, map[string]string{"hello": "world"}).Write(w)
+		}
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	tag := w.Header().Get("ETag")
+	require.True(t, strings.HasPrefix(tag, `W/"`), tag)
+	require.JSONEq(t, `{"hello":"world"}`, w.Body.String())
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{"MATCHING_ETAG", "GET", "/", map[string]string{"If-None-Match": tag}, http.StatusNotModified},
+		{"MATCHING_ETAG_IN_LIST", "GET", "/", map[string]string{"If-None-Match": `"other", ` + strings.TrimPrefix(tag, "W/")}, http.StatusNotModified},
+		{"STALE_ETAG", "GET", "/", map[string]string{"If-None-Match": `W/"stale"`}, http.StatusOK},
+		{"NOT_MODIFIED_SINCE", "GET", "/dated", map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)}, http.StatusNotModified},
+		{"MODIFIED_SINCE", "GET", "/dated", map[string]string{"If-Modified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)}, http.StatusOK},
+		{"ERRORS_UNTOUCHED", "GET", "/missing", map[string]string{"If-None-Match": "*"}, http.StatusNotFound},
+		{"UNSAFE_METHOD", "POST", "/", map[string]string{"If-None-Match": tag}, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.path, nil)
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			require.Equal(t, test.status, w.Code)
+			if test.status == http.StatusNotModified {
+				require.Empty(t, w.Body.String())
+				require.Empty(t, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestCache(t *testing.T) {
+	var calls atomic.Int32
+	now := time.Now()
+
+	cache := httpcache.New(httpcache.Options{
+		MaxEntries: 2,
+		Vary:       []string{"Accept-Language"},
+		Now:        func() time.Time { return now },
+	})
+
+	rt := router.New()
+	rt.Use(cache.Middleware)
+	rt.Getf("/items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		fmt.Fprintf(w, "%s %s %d", r.URL.Path, r.Header.Get("Accept-Language"), n)
+	}, httpcache.CacheControl("public, max-age=60"))
+	rt.Getf("/private", func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+	}, httpcache.CacheControl("private"))
+
+	var logs strings.Builder
+	do := func(path string, headers ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", path, nil)
+		for i := 0; i < len(headers); i += 2 {
+			r.Header.Set(headers[i], headers[i+1])
+		}
+
+		log := zerolog.New(&logs)
+		r = r.WithContext(log.WithContext(r.Context()))
+
+		w := httptest.NewRecorder()
+		rt.ServeHTTP(w, r)
+		zerolog.Ctx(r.Context()).Info().Msg("request: finished")
+		return w
+	}
+
+	first := do("/items/1")
+	require.Equal(t, "MISS", first.Header().Get("X-Cache"))
+	require.Equal(t, "/items/1  1", first.Body.String())
+
+	second := do("/items/1")
+	require.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	require.Equal(t, first.Body.String(), second.Body.String())
+	require.Equal(t, "public, max-age=60", second.Header().Get("Cache-Control"))
+	require.EqualValues(t, 1, calls.Load())
+	require.Contains(t, logs.String(), `"@cache.status":"hit"`)
+
+	require.Equal(t, http.StatusNotModified, do("/items/1", "If-None-Match", first.Header().Get("ETag")).Code)
+
+	// Vary headers are part of the key.
+	require.Equal(t, "/items/1 fr 2", do("/items/1", "Accept-Language", "fr").Body.String())
+
+	// The least recently used entry is evicted.
+	do("/items/2")
+	require.Equal(t, 2, cache.Len())
+	require.Equal(t, "MISS", do("/items/1").Header().Get("X-Cache"))
+
+	// Entries expire.
+	now = now.Add(61 * time.Second)
+	require.Equal(t, "MISS", do("/items/2").Header().Get("X-Cache"))
+
+	// Private responses aren't cached.
+	do("/private")
+	do("/private")
+	require.Equal(t, "MISS", do("/private").Header().Get("X-Cache"))
+
+	// Requests carrying credentials are never served each other's responses.
+	alice := do("/items/3", "Cookie", "session=alice")
+	bob := do("/items/3", "Cookie", "session=bob")
+	require.NotEqual(t, alice.Body.String(), bob.Body.String())
+	require.Empty(t, bob.Header().Get("X-Cache"))
+	require.Empty(t, do("/items/3", "X-API-Key", "key_1").Header().Get("X-Cache"))
+	require.Empty(t, do("/items/3", "Authorization", "Bearer token").Header().Get("X-Cache"))
+	require.Equal(t, "MISS", do("/items/3").Header().Get("X-Cache"), "credentialed responses aren't stored")
+	require.Contains(t, logs.String(), `"@cache.status":"bypass"`)
+
+	require.Equal(t, 2, cache.Purge("/items/{id}"))
+	require.Equal(t, 0, cache.Len())
+}
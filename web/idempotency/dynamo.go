@@ -0,0 +1,194 @@
+package idempotency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/jimmysawczuk/kit/aws/dtable"
+)
+
+// DynamoStore is a Store that keeps records in a DynamoDB table, claiming keys with conditional writes.
+// Enable DynamoDB's TTL on TTLAttribute to have expired records cleaned up.
+type DynamoStore struct {
+	Table *dtable.Table
+
+	// Schema names the table's key and TTL attributes. Items are written with a sort key of "idempotency".
+	dtable.Schema
+}
+
+var _ Store = &DynamoStore{}
+
+// NewDynamoStore returns a DynamoStore for a table with a "pk" partition key and no sort key.
+func NewDynamoStore(t *dtable.Table) *DynamoStore {
+	return &DynamoStore{
+		Table: t,
+	}
+}
+
+func (d *DynamoStore) key(key string) map[string]*dynamodb.AttributeValue {
+	return d.ItemKey("idempotency", key)
+}
+
+// Lock implements Store.
+func (d *DynamoStore) Lock(ctx context.Context, key, fingerprint string, ttl time.Duration) (Record, string, error) {
+	now := time.Now()
+	token := newToken()
+
+	item := d.key(key)
+	item["fingerprint"] = &dynamodb.AttributeValue{S: aws.String(fingerprint)}
+	item["token"] = &dynamodb.AttributeValue{S: aws.String(token)}
+	item["completed"] = &dynamodb.AttributeValue{BOOL: aws.Bool(false)}
+	item[d.TTLAttributeName()] = dtable.Unix(now.Add(ttl))
+
+	_, err := d.Table.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#pk) OR #ttl <= :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#pk":  aws.String(d.PartitionKeyName()),
+			"#ttl": aws.String(d.TTLAttributeName()),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": dtable.Unix(now),
+		},
+	})
+	if err == nil {
+		return Record{}, token, nil
+	}
+	if !dtable.ConditionFailed(err) {
+		return Record{}, "", fmt.Errorf("dtable: put item: %w", err)
+	}
+
+	out, err := d.Table.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:            d.key(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return Record{}, "", fmt.Errorf("dtable: get item: %w", err)
+	}
+
+	if len(out.Item) == 0 {
+		// The record was unlocked in the meantime; the client can retry.
+		return Record{Fingerprint: fingerprint}, "", nil
+	}
+
+	rec := Record{}
+	if av := out.Item["fingerprint"]; av != nil && av.S != nil {
+		rec.Fingerprint = *av.S
+	}
+	if av := out.Item["completed"]; av != nil && av.BOOL != nil {
+		rec.Completed = *av.BOOL
+	}
+	if av := out.Item["unrecorded"]; av != nil && av.BOOL != nil {
+		rec.Unrecorded = *av.BOOL
+	}
+	if av := out.Item["status"]; av != nil && av.N != nil {
+		if rec.Status, err = strconv.Atoi(*av.N); err != nil {
+			return Record{}, "", fmt.Errorf("strconv: atoi (attribute: status): %w", err)
+		}
+	}
+	if av := out.Item["header"]; av != nil && len(av.B) > 0 {
+		if err := json.Unmarshal(av.B, &rec.Header); err != nil {
+			return Record{}, "", fmt.Errorf("json: unmarshal: %w", err)
+		}
+	}
+	if av := out.Item["body"]; av != nil {
+		rec.Body = av.B
+	}
+
+	return rec, "", nil
+}
+
+// heldBy is the condition that token holds the claim on an item, with its names and values.
+func heldBy(token string) (*string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	return aws.String("#token = :token AND #completed = :false"),
+		map[string]*string{
+			"#token":     aws.String("token"),
+			"#completed": aws.String("completed"),
+		},
+		map[string]*dynamodb.AttributeValue{
+			":token": {S: aws.String(token)},
+			":false": {BOOL: aws.Bool(false)},
+		}
+}
+
+// Extend implements Store.
+func (d *DynamoStore) Extend(ctx context.Context, key, token string, ttl time.Duration) error {
+	cond, names, values := heldBy(token)
+	names["#ttl"] = aws.String(d.TTLAttributeName())
+	values[":ttl"] = dtable.Unix(time.Now().Add(ttl))
+
+	_, err := d.Table.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		Key:                       d.key(key),
+		UpdateExpression:          aws.String("SET #ttl = :ttl"),
+		ConditionExpression:       cond,
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if dtable.ConditionFailed(err) {
+		return ErrLockLost
+	}
+	if err != nil {
+		return fmt.Errorf("dtable: update item: %w", err)
+	}
+
+	return nil
+}
+
+// Complete implements Store.
+func (d *DynamoStore) Complete(ctx context.Context, key, token string, rec Record, ttl time.Duration) error {
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return fmt.Errorf("json: marshal: %w", err)
+	}
+
+	item := d.key(key)
+	item["fingerprint"] = &dynamodb.AttributeValue{S: aws.String(rec.Fingerprint)}
+	item["token"] = &dynamodb.AttributeValue{S: aws.String(token)}
+	item["completed"] = &dynamodb.AttributeValue{BOOL: aws.Bool(true)}
+	item["unrecorded"] = &dynamodb.AttributeValue{BOOL: aws.Bool(rec.Unrecorded)}
+	item["status"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(rec.Status))}
+	item["header"] = &dynamodb.AttributeValue{B: header}
+	item[d.TTLAttributeName()] = dtable.Unix(time.Now().Add(ttl))
+
+	// DynamoDB doesn't allow empty binary attributes.
+	if len(rec.Body) > 0 {
+		item["body"] = &dynamodb.AttributeValue{B: rec.Body}
+	}
+
+	cond, names, values := heldBy(token)
+	_, err = d.Table.PutItem(ctx, &dynamodb.PutItemInput{
+		Item:                      item,
+		ConditionExpression:       cond,
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if dtable.ConditionFailed(err) {
+		return ErrLockLost
+	}
+	if err != nil {
+		return fmt.Errorf("dtable: put item: %w", err)
+	}
+
+	return nil
+}
+
+// Unlock implements Store.
+func (d *DynamoStore) Unlock(ctx context.Context, key, token string) error {
+	cond, names, values := heldBy(token)
+	_, err := d.Table.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		Key:                       d.key(key),
+		ConditionExpression:       cond,
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+	if err != nil && !dtable.ConditionFailed(err) {
+		return fmt.Errorf("dtable: delete item: %w", err)
+	}
+
+	return nil
+}
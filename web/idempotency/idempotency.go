@@ -0,0 +1,407 @@
+// Package idempotency makes retried requests safe by running a handler once per Idempotency-Key and
+// replaying its stored response to retries.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jimmysawczuk/kit/internal/httpwrap"
+	"github.com/jimmysawczuk/kit/web/auth"
+	"github.com/jimmysawczuk/kit/web/requestid"
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/rs/zerolog"
+)
+
+// Record is the state stored for an idempotency key.
+type Record struct {
+	// Fingerprint identifies the request the key was first used with.
+	Fingerprint string
+
+	// Completed is false while the first request is still being handled.
+	Completed bool
+
+	// Unrecorded is set on completed Records whose response couldn't be stored, i.e. because it was too
+	// large. Retries are rejected rather than replayed, since running the request again isn't safe.
+	Unrecorded bool
+
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+// ErrLockLost is returned by a Store when a claim's lock has expired and the key has been claimed again
+// or completed by another request.
+var ErrLockLost = errors.New("idempotency: lock lost")
+
+// Store keeps Records. Implementations must make Lock atomic, so only one request can claim a key, and
+// must only let the holder of a claim's token extend, complete or unlock it.
+type Store interface {
+	// Lock claims key for a request with fingerprint, storing an in-progress Record that expires after
+	// ttl, and returns a token identifying the claim. If the key is already claimed and hasn't expired,
+	// the existing Record is returned with an empty token.
+	Lock(ctx context.Context, key, fingerprint string, ttl time.Duration) (Record, string, error)
+
+	// Extend pushes back the expiry of the claim on key held by token to ttl from now. It returns
+	// ErrLockLost if token no longer holds the claim.
+	Extend(ctx context.Context, key, token string, ttl time.Duration) error
+
+	// Complete replaces the Record for the claim on key held by token with a completed one that expires
+	// after ttl. It returns ErrLockLost if token no longer holds the claim.
+	Complete(ctx context.Context, key, token string, rec Record, ttl time.Duration) error
+
+	// Unlock removes the Record for the claim on key held by token, so the request can be retried. It does
+	// nothing if token no longer holds the claim.
+	Unlock(ctx context.Context, key, token string) error
+}
+
+// Options configures the idempotency middleware.
+type Options struct {
+	// Header is the request header carrying the key. Defaults to "Idempotency-Key".
+	Header string
+
+	// Methods lists the methods keys are honored for. Defaults to POST and PATCH.
+	Methods []string
+
+	// Required rejects requests using Methods that don't carry a key.
+	Required bool
+
+	// TTL is how long completed responses are kept for replay. Defaults to 24 hours.
+	TTL time.Duration
+
+	// LockTimeout is how long a key stays claimed by a request that never completes, i.e. because the
+	// instance handling it crashed. Claims are extended while the handler runs, so it can take longer.
+	// Defaults to 1 minute.
+	LockTimeout time.Duration
+
+	// MaxBodySize is the largest request body that will be fingerprinted, and the largest response body
+	// that will be stored. Defaults to 1 MiB.
+	MaxBodySize int64
+
+	// Scope returns a namespace for keys, so different callers can't replay each other's responses.
+	// Defaults to the subject of the request's auth.Principal.
+	Scope func(*http.Request) string
+}
+
+func (o Options) header() string {
+	if o.Header == "" {
+		return "Idempotency-Key"
+	}
+	return o.Header
+}
+
+func (o Options) methods() []string {
+	if len(o.Methods) == 0 {
+		return []string{http.MethodPost, http.MethodPatch}
+	}
+	return o.Methods
+}
+
+func (o Options) ttl() time.Duration {
+	if o.TTL <= 0 {
+		return 24 * time.Hour
+	}
+	return o.TTL
+}
+
+func (o Options) lockTimeout() time.Duration {
+	if o.LockTimeout <= 0 {
+		return time.Minute
+	}
+	return o.LockTimeout
+}
+
+func (o Options) maxBodySize() int64 {
+	if o.MaxBodySize <= 0 {
+		return 1 << 20
+	}
+	return o.MaxBodySize
+}
+
+func (o Options) scope(r *http.Request) string {
+	if o.Scope != nil {
+		return o.Scope(r)
+	}
+	return auth.Subject(r.Context())
+}
+
+// maxKeyLength is the longest key accepted.
+const maxKeyLength = 255
+
+// Middleware runs requests carrying an idempotency key once, storing the response in store and replaying
+// it to retries with the same key. Retries that don't match the original request get a 409, as do
+// retries made while the original is still in progress. Responses with a 5xx status aren't stored, so the
+// request can be retried. Other responses that can't be stored, i.e. because they're larger than
+// MaxBodySize, leave the key marked completed, so retries get a 409 rather than running the request again.
+func Middleware(store Store, opts Options) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			log := zerolog.Ctx(ctx)
+
+			if !slices.Contains(opts.methods(), r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := r.Header.Get(opts.header())
+			if key == "" {
+				if opts.Required {
+					respond.CodedError(ctx, http.StatusBadRequest, "IDEMPOTENCY_KEY_REQUIRED", fmt.Errorf("missing %s header", opts.header())).Write(w)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if len(key) > maxKeyLength {
+				respond.CodedError(ctx, http.StatusBadRequest, "INVALID_IDEMPOTENCY_KEY", fmt.Errorf("%s header is longer than %d characters", opts.header(), maxKeyLength)).Write(w)
+				return
+			}
+
+			fingerprint, err := fingerprintRequest(r, opts.maxBodySize())
+			if err != nil {
+				respond.CodedError(ctx, http.StatusRequestEntityTooLarge, "REQUEST_TOO_LARGE", err).Write(w)
+				return
+			}
+
+			storeKey := opts.scope(r) + "|" + key
+
+			log.UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("@req.idempotency_key", key)
+			})
+
+			rec, token, err := store.Lock(ctx, storeKey, fingerprint, opts.lockTimeout())
+			if err != nil {
+				log.Error().Err(err).Msg("idempotency: couldn't lock key")
+				respond.CodedError(ctx, http.StatusServiceUnavailable, "IDEMPOTENCY_UNAVAILABLE", errors.New("couldn't lock idempotency key")).Write(w)
+				return
+			}
+
+			if token == "" {
+				switch {
+				case rec.Fingerprint != fingerprint:
+					respond.CodedError(ctx, http.StatusConflict, "IDEMPOTENCY_KEY_MISMATCH", errors.New("idempotency key was used with a different request")).Write(w)
+				case rec.Unrecorded:
+					respond.CodedError(ctx, http.StatusConflict, "IDEMPOTENCY_RESPONSE_UNAVAILABLE", errors.New("a request with this idempotency key completed, but its response wasn't stored")).Write(w)
+				case !rec.Completed:
+					resp := respond.CodedError(ctx, http.StatusConflict, "IDEMPOTENCY_KEY_IN_PROGRESS", errors.New("a request with this idempotency key is in progress"))
+					resp.WithHeader(func(h http.Header) http.Header {
+						h.Set("Retry-After", "1")
+						return h
+					})
+					resp.Write(w)
+				default:
+					replay(w, rec)
+				}
+				return
+			}
+
+			rw := &recordingWriter{Writer: httpwrap.Writer{ResponseWriter: w}, limit: opts.maxBodySize(), requestIDHeader: requestid.Header(ctx)}
+
+			release := hold(store, storeKey, token, opts.lockTimeout(), log)
+
+			defer func() {
+				// Release the key even if the handler panics, then let the panic carry on.
+				if p := recover(); p != nil {
+					release()
+					unlock(store, storeKey, token, log)
+					panic(p)
+				}
+			}()
+
+			next.ServeHTTP(rw, r)
+			release()
+
+			status := rw.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			if status >= 500 {
+				unlock(store, storeKey, token, log)
+				return
+			}
+
+			// The handler has run, so from here on the key mustn't be released. If the response can't be
+			// stored, the key is marked completed without it, and if even that fails it stays claimed until
+			// the lock expires.
+			unrecorded := Record{
+				Fingerprint: fingerprint,
+				Completed:   true,
+				Unrecorded:  true,
+				Status:      status,
+			}
+
+			cctx := context.WithoutCancel(ctx)
+			if rw.overflow {
+				log.Warn().Int64("limit", opts.maxBodySize()).Msg("idempotency: response too large to store")
+				err = store.Complete(cctx, storeKey, token, unrecorded, opts.ttl())
+			} else {
+				err = store.Complete(cctx, storeKey, token, Record{
+					Fingerprint: fingerprint,
+					Completed:   true,
+					Status:      status,
+					Header:      rw.header,
+					Body:        rw.body.Bytes(),
+				}, opts.ttl())
+				if err != nil && !errors.Is(err, ErrLockLost) {
+					log.Error().Err(err).Msg("idempotency: couldn't store response")
+					err = store.Complete(cctx, storeKey, token, unrecorded, opts.ttl())
+				}
+			}
+			if err != nil {
+				log.Error().Err(err).Msg("idempotency: couldn't complete key")
+			}
+		})
+	}
+}
+
+// hold keeps the claim on key held by token while the handler runs, extending it every third of ttl. It
+// returns a func that stops extending it.
+func hold(store Store, key, token string, ttl time.Duration, log *zerolog.Logger) func() {
+	stop := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		t := time.NewTicker(ttl / 3)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-t.C:
+				err := store.Extend(context.Background(), key, token, ttl)
+				if errors.Is(err, ErrLockLost) {
+					log.Warn().Msg("idempotency: lock lost while handling request")
+					return
+				}
+				if err != nil {
+					log.Error().Err(err).Msg("idempotency: couldn't extend lock")
+				}
+			}
+		}
+	}()
+
+	return sync.OnceFunc(func() {
+		close(stop)
+		wg.Wait()
+	})
+}
+
+func unlock(store Store, key, token string, log *zerolog.Logger) {
+	if err := store.Unlock(context.Background(), key, token); err != nil {
+		log.Error().Err(err).Msg("idempotency: couldn't unlock key")
+	}
+}
+
+// newToken returns a random token identifying a claim on a key.
+func newToken() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Errorf("crypto/rand: read: %w", err))
+	}
+	return hex.EncodeToString(buf)
+}
+
+// fingerprintRequest hashes the parts of r that identify it, restoring the body for the handler.
+func fingerprintRequest(r *http.Request, limit int64) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.RequestURI()+"\n")
+
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+		if err != nil {
+			return "", fmt.Errorf("read body: %w", err)
+		}
+		if int64(len(body)) > limit {
+			return "", fmt.Errorf("request body is larger than %d bytes", limit)
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func replay(w http.ResponseWriter, rec Record) {
+	for k, v := range rec.Header {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(rec.Status)
+	w.Write(rec.Body)
+}
+
+// skipHeaders aren't stored with responses, since they shouldn't be replayed. The request ID header
+// isn't either.
+var skipHeaders = []string{"Date", "Set-Cookie"}
+
+// recordingWriter passes a response through while keeping a copy of it.
+type recordingWriter struct {
+	httpwrap.Writer
+
+	limit    int64
+	status   int
+	header   http.Header
+	body     bytes.Buffer
+	overflow bool
+
+	// requestIDHeader is where the request's ID is echoed, which isn't stored either.
+	requestIDHeader string
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+		rw.header = rw.Header().Clone()
+		for _, k := range skipHeaders {
+			rw.header.Del(k)
+		}
+		rw.header.Del(rw.requestIDHeader)
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if !rw.overflow {
+		if int64(rw.body.Len()+len(b)) > rw.limit {
+			rw.overflow = true
+			rw.body.Reset()
+		} else {
+			rw.body.Write(b)
+		}
+	}
+
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (rw *recordingWriter) Flush() {
+	httpwrap.Flush(rw.ResponseWriter, func() {
+		if rw.status == 0 {
+			rw.WriteHeader(http.StatusOK)
+		}
+	})
+}
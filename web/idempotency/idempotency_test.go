@@ -0,0 +1,271 @@
+package idempotency_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web/idempotency"
+	"github.com/jimmysawczuk/kit/web/middleware"
+	"github.com/jimmysawczuk/kit/web/requestid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	h := idempotency.Middleware(idempotency.NewMemoryStore(), idempotency.Options{
+		Scope: func(r *http.Request) string { return r.Header.Get("X-Client") },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+
+		switch r.URL.Path {
+		case "/slow":
+			<-release
+		case "/fail":
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+
+		w.Header().Set("Location", "/payments/"+fmt.Sprint(n))
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "payment %d: %s", n, body)
+	}))
+
+	do := func(method, path, key, client, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		r.Header.Set("X-Client", client)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("REPLAY", func(t *testing.T) {
+		calls.Store(0)
+
+		first := do("POST", "/payments", "k1", "a", `{"amount":100}`)
+		require.Equal(t, http.StatusCreated, first.Code)
+		require.Equal(t, "payment 1: {\"amount\":100}", first.Body.String())
+		require.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+		second := do("POST", "/payments", "k1", "a", `{"amount":100}`)
+		require.Equal(t, http.StatusCreated, second.Code)
+		require.Equal(t, first.Body.String(), second.Body.String())
+		require.Equal(t, "/payments/1", second.Header().Get("Location"))
+		require.Equal(t, "true", second.Header().Get("Idempotent-Replayed"))
+
+		require.EqualValues(t, 1, calls.Load())
+	})
+
+	t.Run("MISMATCH", func(t *testing.T) {
+		do("POST", "/payments", "k2", "a", `{"amount":100}`)
+
+		w := do("POST", "/payments", "k2", "a", `{"amount":999}`)
+		require.Equal(t, http.StatusConflict, w.Code)
+		require.Contains(t, w.Body.String(), `"code":"IDEMPOTENCY_KEY_MISMATCH"`)
+	})
+
+	t.Run("SCOPED_BY_CLIENT", func(t *testing.T) {
+		calls.Store(0)
+
+		do("POST", "/payments", "k3", "a", `{}`)
+		w := do("POST", "/payments", "k3", "b", `{}`)
+		require.Equal(t, http.StatusCreated, w.Code)
+		require.Empty(t, w.Header().Get("Idempotent-Replayed"))
+		require.EqualValues(t, 2, calls.Load())
+	})
+
+	t.Run("IN_PROGRESS", func(t *testing.T) {
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			done <- do("POST", "/slow", "k4", "a", `{}`)
+		}()
+
+		require.Eventually(t, func() bool {
+			w := do("POST", "/slow", "k4", "a", `{}`)
+			return w.Code == http.StatusConflict && strings.Contains(w.Body.String(), `"code":"IDEMPOTENCY_KEY_IN_PROGRESS"`)
+		}, time.Second, 5*time.Millisecond)
+
+		close(release)
+		require.Equal(t, http.StatusCreated, (<-done).Code)
+	})
+
+	t.Run("SERVER_ERRORS_NOT_STORED", func(t *testing.T) {
+		calls.Store(0)
+
+		require.Equal(t, http.StatusBadGateway, do("POST", "/fail", "k5", "a", `{}`).Code)
+		require.Equal(t, http.StatusBadGateway, do("POST", "/fail", "k5", "a", `{}`).Code)
+		require.EqualValues(t, 2, calls.Load())
+	})
+
+	t.Run("NO_KEY", func(t *testing.T) {
+		calls.Store(0)
+
+		do("POST", "/payments", "", "a", `{}`)
+		do("POST", "/payments", "", "a", `{}`)
+		require.EqualValues(t, 2, calls.Load())
+	})
+
+	t.Run("SAFE_METHOD", func(t *testing.T) {
+		calls.Store(0)
+
+		do("GET", "/payments", "k6", "a", "")
+		do("GET", "/payments", "k6", "a", "")
+		require.EqualValues(t, 2, calls.Load())
+	})
+}
+
+func TestRequired(t *testing.T) {
+	h := idempotency.Middleware(idempotency.NewMemoryStore(), idempotency.Options{Required: true})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/", nil))
+
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), `"code":"IDEMPOTENCY_KEY_REQUIRED"`)
+}
+
+func TestRequestIDNotReplayed(t *testing.T) {
+	g := &requestid.Generator{Prefix: "req", ResponseHeader: "X-Correlation-Id"}
+
+	h := middleware.RequestIDFrom(g)(idempotency.Middleware(idempotency.NewMemoryStore(), idempotency.Options{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})))
+
+	var ids []string
+	for range 2 {
+		r := httptest.NewRequest("POST", "/payments", nil)
+		r.Header.Set("Idempotency-Key", "k1")
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		require.Equal(t, http.StatusCreated, w.Code)
+		ids = append(ids, w.Header().Values("X-Correlation-Id")...)
+	}
+
+	require.Equal(t, []string{"req-000000001", "req-000000002"}, ids)
+}
+
+func TestMemoryStoreTokens(t *testing.T) {
+	ctx := context.Background()
+	store := idempotency.NewMemoryStore()
+
+	_, stale, err := store.Lock(ctx, "k1", "f", time.Millisecond)
+	require.NoError(t, err)
+	require.NotEmpty(t, stale)
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, token, err := store.Lock(ctx, "k1", "f", time.Minute)
+	require.NoError(t, err)
+	require.NotEmpty(t, token)
+	require.NotEqual(t, stale, token)
+
+	require.ErrorIs(t, store.Extend(ctx, "k1", stale, time.Minute), idempotency.ErrLockLost)
+	require.ErrorIs(t, store.Complete(ctx, "k1", stale, idempotency.Record{Fingerprint: "f", Completed: true}, time.Minute), idempotency.ErrLockLost)
+	require.NoError(t, store.Unlock(ctx, "k1", stale))
+
+	rec, again, err := store.Lock(ctx, "k1", "f", time.Minute)
+	require.NoError(t, err)
+	require.Empty(t, again, "the stale token shouldn't have released the new claim")
+	require.False(t, rec.Completed)
+
+	require.NoError(t, store.Extend(ctx, "k1", token, time.Minute))
+	require.NoError(t, store.Complete(ctx, "k1", token, idempotency.Record{Fingerprint: "f", Completed: true, Status: 201}, time.Minute))
+	require.ErrorIs(t, store.Extend(ctx, "k1", token, time.Minute), idempotency.ErrLockLost)
+}
+
+// failingStore fails to store responses, but can still mark keys completed without one.
+type failingStore struct {
+	*idempotency.MemoryStore
+}
+
+func (f failingStore) Complete(ctx context.Context, key, token string, rec idempotency.Record, ttl time.Duration) error {
+	if !rec.Unrecorded {
+		return errors.New("item too large")
+	}
+	return f.MemoryStore.Complete(ctx, key, token, rec, ttl)
+}
+
+func TestNotRerun(t *testing.T) {
+	tests := []struct {
+		name  string
+		store idempotency.Store
+		opts  idempotency.Options
+		delay time.Duration
+	}{
+		{
+			name:  "LOCK_EXTENDED",
+			store: idempotency.NewMemoryStore(),
+			opts:  idempotency.Options{LockTimeout: 30 * time.Millisecond},
+			delay: 150 * time.Millisecond,
+		},
+		{
+			name:  "RESPONSE_TOO_LARGE",
+			store: idempotency.NewMemoryStore(),
+			opts:  idempotency.Options{MaxBodySize: 8},
+		},
+		{
+			name:  "COMPLETE_FAILED",
+			store: failingStore{idempotency.NewMemoryStore()},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+			h := idempotency.Middleware(test.store, test.opts)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls.Add(1)
+				time.Sleep(test.delay)
+				w.WriteHeader(http.StatusCreated)
+				io.WriteString(w, "payment created")
+			}))
+
+			do := func() *httptest.ResponseRecorder {
+				r := httptest.NewRequest("POST", "/payments", nil)
+				r.Header.Set("Idempotency-Key", "k1")
+
+				w := httptest.NewRecorder()
+				h.ServeHTTP(w, r)
+				return w
+			}
+
+			first := make(chan *httptest.ResponseRecorder)
+			go func() {
+				first <- do()
+			}()
+
+			if test.delay > 0 {
+				// Retry after the lock would have expired if it weren't extended.
+				time.Sleep(test.delay / 2)
+				w := do()
+				require.Equal(t, http.StatusConflict, w.Code)
+				require.Contains(t, w.Body.String(), `"code":"IDEMPOTENCY_KEY_IN_PROGRESS"`)
+			}
+
+			require.Equal(t, http.StatusCreated, (<-first).Code)
+
+			if test.delay == 0 {
+				w := do()
+				require.Equal(t, http.StatusConflict, w.Code)
+				require.Contains(t, w.Body.String(), `"code":"IDEMPOTENCY_RESPONSE_UNAVAILABLE"`)
+			}
+
+			require.EqualValues(t, 1, calls.Load())
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package idempotency
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many locks a MemoryStore allows between sweeps for expired records.
+const sweepEvery = 1024
+
+type memoryEntry struct {
+	rec     Record
+	token   string
+	expires time.Time
+}
+
+// MemoryStore is a Store that keeps records in memory. It only protects against retries handled by the
+// same instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	locks   int
+}
+
+var _ Store = &MemoryStore{}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: map[string]memoryEntry{},
+	}
+}
+
+// Lock implements Store.
+func (m *MemoryStore) Lock(_ context.Context, key, fingerprint string, ttl time.Duration) (Record, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	if e, ok := m.entries[key]; ok && now.Before(e.expires) {
+		return e.rec, "", nil
+	}
+
+	token := newToken()
+	m.entries[key] = memoryEntry{rec: Record{Fingerprint: fingerprint}, token: token, expires: now.Add(ttl)}
+
+	m.locks++
+	if m.locks%sweepEvery == 0 {
+		for k, e := range m.entries {
+			if now.After(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+	}
+
+	return Record{}, token, nil
+}
+
+// held reports whether token holds the claim on key. m.mu must be held.
+func (m *MemoryStore) held(key, token string) bool {
+	e, ok := m.entries[key]
+	return ok && e.token == token && !e.rec.Completed
+}
+
+// Extend implements Store.
+func (m *MemoryStore) Extend(_ context.Context, key, token string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.held(key, token) {
+		return ErrLockLost
+	}
+
+	e := m.entries[key]
+	e.expires = time.Now().Add(ttl)
+	m.entries[key] = e
+	return nil
+}
+
+// Complete implements Store.
+func (m *MemoryStore) Complete(_ context.Context, key, token string, rec Record, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.held(key, token) {
+		return ErrLockLost
+	}
+
+	m.entries[key] = memoryEntry{rec: rec, token: token, expires: time.Now().Add(ttl)}
+	return nil
+}
+
+// Unlock implements Store.
+func (m *MemoryStore) Unlock(_ context.Context, key, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.held(key, token) {
+		delete(m.entries, key)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package idempotency
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+// errDuplicateEntry is MySQL's error number for a primary key conflict.
+const errDuplicateEntry = 1062
+
+// MySQLStore is a Store that keeps records in a MySQL table with the following schema:
+//
+//	CREATE TABLE idempotency_keys (
+//		id VARCHAR(512) NOT NULL PRIMARY KEY,
+//		fingerprint CHAR(64) NOT NULL,
+//		token CHAR(32) NOT NULL,
+//		completed BOOLEAN NOT NULL,
+//		unrecorded BOOLEAN NOT NULL DEFAULT FALSE,
+//		status INT NOT NULL,
+//		header BLOB NULL,
+//		body MEDIUMBLOB NULL,
+//		expires_at DATETIME(6) NOT NULL,
+//		INDEX (expires_at)
+//	);
+//
+// Call DeleteExpired periodically to remove expired records.
+type MySQLStore struct {
+	DB *sqlx.DB
+
+	// Table is the name of the records table. Defaults to "idempotency_keys".
+	Table string
+}
+
+var _ Store = &MySQLStore{}
+
+// NewMySQLStore returns a MySQLStore using the "idempotency_keys" table.
+func NewMySQLStore(db *sqlx.DB) *MySQLStore {
+	return &MySQLStore{
+		DB: db,
+	}
+}
+
+func (m *MySQLStore) table() string {
+	if m.Table == "" {
+		return "idempotency_keys"
+	}
+	return m.Table
+}
+
+type recordRow struct {
+	Fingerprint string `db:"fingerprint"`
+	Completed   bool   `db:"completed"`
+	Unrecorded  bool   `db:"unrecorded"`
+	Status      int    `db:"status"`
+	Header      []byte `db:"header"`
+	Body        []byte `db:"body"`
+}
+
+// Lock implements Store.
+func (m *MySQLStore) Lock(ctx context.Context, key, fingerprint string, ttl time.Duration) (Record, string, error) {
+	now := time.Now().UTC()
+	token := newToken()
+
+	_, err := m.DB.ExecContext(ctx, "INSERT INTO `"+m.table()+"` (`id`, `fingerprint`, `token`, `completed`, `status`, `expires_at`) "+
+		"VALUES (?, ?, ?, FALSE, 0, ?)", key, fingerprint, token, now.Add(ttl))
+	if err == nil {
+		return Record{}, token, nil
+	}
+
+	var merr *mysql.MySQLError
+	if !errors.As(err, &merr) || merr.Number != errDuplicateEntry {
+		return Record{}, "", fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	// Take over the key if its record has expired.
+	res, err := m.DB.ExecContext(ctx, "UPDATE `"+m.table()+"` SET `fingerprint` = ?, `token` = ?, `completed` = FALSE, `unrecorded` = FALSE, "+
+		"`status` = 0, `header` = NULL, `body` = NULL, `expires_at` = ? WHERE `id` = ? AND `expires_at` <= ?", fingerprint, token, now.Add(ttl), key, now)
+	if err != nil {
+		return Record{}, "", fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return Record{}, "", fmt.Errorf("sql: rows affected: %w", err)
+	} else if n == 1 {
+		return Record{}, token, nil
+	}
+
+	var row recordRow
+	err = m.DB.GetContext(ctx, &row, "SELECT `fingerprint`, `completed`, `unrecorded`, `status`, `header`, `body` FROM `"+m.table()+"` WHERE `id` = ?", key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The record was unlocked in the meantime; the client can retry.
+		return Record{Fingerprint: fingerprint}, "", nil
+	}
+	if err != nil {
+		return Record{}, "", fmt.Errorf("sqlx: get: %w", err)
+	}
+
+	rec := Record{
+		Fingerprint: row.Fingerprint,
+		Completed:   row.Completed,
+		Unrecorded:  row.Unrecorded,
+		Status:      row.Status,
+		Body:        row.Body,
+	}
+
+	if len(row.Header) > 0 {
+		if err := json.Unmarshal(row.Header, &rec.Header); err != nil {
+			return Record{}, "", fmt.Errorf("json: unmarshal: %w", err)
+		}
+	}
+
+	return rec, "", nil
+}
+
+// Extend implements Store.
+func (m *MySQLStore) Extend(ctx context.Context, key, token string, ttl time.Duration) error {
+	res, err := m.DB.ExecContext(ctx, "UPDATE `"+m.table()+"` SET `expires_at` = ? WHERE `id` = ? AND `token` = ? AND `completed` = FALSE",
+		time.Now().UTC().Add(ttl), key, token)
+	if err != nil {
+		return fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	return lockHeld(res)
+}
+
+// Complete implements Store.
+func (m *MySQLStore) Complete(ctx context.Context, key, token string, rec Record, ttl time.Duration) error {
+	header, err := json.Marshal(rec.Header)
+	if err != nil {
+		return fmt.Errorf("json: marshal: %w", err)
+	}
+
+	res, err := m.DB.ExecContext(ctx, "UPDATE `"+m.table()+"` SET `completed` = TRUE, `unrecorded` = ?, `status` = ?, `header` = ?, `body` = ?, "+
+		"`expires_at` = ? WHERE `id` = ? AND `token` = ? AND `completed` = FALSE", rec.Unrecorded, rec.Status, header, rec.Body, time.Now().UTC().Add(ttl), key, token)
+	if err != nil {
+		return fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	return lockHeld(res)
+}
+
+// lockHeld returns ErrLockLost if an update conditioned on holding a claim didn't change anything.
+func lockHeld(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("sql: rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrLockLost
+	}
+	return nil
+}
+
+// Unlock implements Store.
+func (m *MySQLStore) Unlock(ctx context.Context, key, token string) error {
+	if _, err := m.DB.ExecContext(ctx, "DELETE FROM `"+m.table()+"` WHERE `id` = ? AND `token` = ? AND `completed` = FALSE", key, token); err != nil {
+		return fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpired removes expired records, returning how many were removed.
+func (m *MySQLStore) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := m.DB.ExecContext(ctx, "DELETE FROM `"+m.table()+"` WHERE `expires_at` <= ?", time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("sql: rows affected: %w", err)
+	}
+
+	return n, nil
+}
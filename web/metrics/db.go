@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"database/sql"
+)
+
+// DBStatser is implemented by *sql.DB and *sqlx.DB.
+type DBStatser interface {
+	Stats() sql.DBStats
+}
+
+// DB exposes the connection pool stats of db, i.e. a *sqlx.DB from db/mysql.Open, labelled with name.
+// Stats are read when the Registry is scraped.
+func (m *Metrics) DB(name string, db DBStatser) {
+	labels := []Label{{Name: "db", Value: name}}
+
+	m.Registry.Register(CollectorFunc(func() []Family {
+		s := db.Stats()
+
+		gauge := func(n, help string, v float64) Family {
+			return Family{Name: n, Help: help, Type: GaugeType, Samples: []Sample{{Labels: labels, Value: v}}}
+		}
+		counter := func(n, help string, v float64) Family {
+			return Family{Name: n, Help: help, Type: CounterType, Samples: []Sample{{Labels: labels, Value: v}}}
+		}
+
+		return []Family{
+			gauge("db_pool_max_open_connections", "Maximum number of open connections to the database.", float64(s.MaxOpenConnections)),
+			gauge("db_pool_open_connections", "Established connections, in use and idle.", float64(s.OpenConnections)),
+			gauge("db_pool_in_use_connections", "Connections currently in use.", float64(s.InUse)),
+			gauge("db_pool_idle_connections", "Idle connections.", float64(s.Idle)),
+			counter("db_pool_wait_count_total", "Connections waited for.", float64(s.WaitCount)),
+			counter("db_pool_wait_duration_seconds_total", "Time spent waiting for connections.", s.WaitDuration.Seconds()),
+			counter("db_pool_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.", float64(s.MaxIdleClosed)),
+			counter("db_pool_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.", float64(s.MaxIdleTimeClosed)),
+			counter("db_pool_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.", float64(s.MaxLifetimeClosed)),
+		}
+	}))
+}
@@ -0,0 +1,194 @@
+// Package metrics exposes application metrics in the Prometheus text exposition format, without
+// depending on a Prometheus client library. Metrics covers HTTP requests, health checks, shutdowns and
+// database connection pools, and serves everything in its Registry from an App endpoint:
+//
+//	m := metrics.New(metrics.NewRegistry())
+//	m.DB("primary", db)
+//
+//	r := router.New()
+//	r.Use(m.Middleware)
+//
+//	app := web.NewApp().
+//		WithRouter(r).
+//		WithHealthCheck(m.HealthChecker(dbCheck)).
+//		WithModule(m)
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jimmysawczuk/kit/internal/httpwrap"
+	"github.com/jimmysawczuk/kit/web"
+	"github.com/jimmysawczuk/kit/web/router"
+)
+
+// Metrics records the standard set of metrics into a Registry.
+type Metrics struct {
+	Registry *Registry
+
+	// Path is where the metrics endpoint is mounted when Metrics is used as a Module. Defaults to
+	// "/metrics".
+	Path string
+
+	requests *CounterVec
+	duration *HistogramVec
+	inFlight *GaugeVec
+
+	healthStatus   *GaugeVec
+	healthDuration *HistogramVec
+
+	shutdownDuration *GaugeVec
+	shutdownSuccess  *GaugeVec
+}
+
+var _ router.Module = &Metrics{}
+
+// New registers the standard metrics in reg and returns a Metrics recording into them.
+func New(reg *Registry) *Metrics {
+	return &Metrics{
+		Registry: reg,
+
+		requests: reg.Counter("http_requests_total", "Total HTTP requests handled.", "method", "route", "status"),
+		duration: reg.Histogram("http_request_duration_seconds", "HTTP request latency.", nil, "method", "route", "status"),
+		inFlight: reg.Gauge("http_requests_in_flight", "HTTP requests currently being handled.", "method", "route"),
+
+		healthStatus:   reg.Gauge("health_check_status", "Result of the last health check: 1 if healthy, 0 if not.", "name"),
+		healthDuration: reg.Histogram("health_check_duration_seconds", "Health check latency.", nil, "name"),
+
+		shutdownDuration: reg.Gauge("shutdown_duration_seconds", "How long each Shutdowner took to shut down.", "name"),
+		shutdownSuccess:  reg.Gauge("shutdown_success", "Whether each Shutdowner shut down cleanly: 1 if so, 0 if not.", "name"),
+	}
+}
+
+// unmatchedRoute labels requests that didn't match a route, so arbitrary paths don't each create a series.
+const unmatchedRoute = "unmatched"
+
+// Middleware records request counts, latencies and in-flight requests, labelled by method, route pattern
+// and status class (i.e. "2xx"). Attach it to the top-level router with Use so every request is counted.
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		route := unmatchedRoute
+		rctx := chi.RouteContext(r.Context())
+		if rctx != nil && rctx.Routes != nil {
+			// Middleware added with Use runs before the router has matched the request, so look the route
+			// up ahead of time.
+			tctx := chi.NewRouteContext()
+			if rctx.Routes.Match(tctx, r.Method, r.URL.Path) {
+				route = tctx.RoutePattern()
+			}
+		}
+
+		inFlight := m.inFlight.With(r.Method, route)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		sw := httpwrap.NewStatusWriter(w)
+		next.ServeHTTP(sw, r)
+
+		if rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+
+		status := statusClass(sw.Status)
+		m.requests.With(r.Method, route, status).Inc()
+		m.duration.With(r.Method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+func statusClass(code int) string {
+	if code == 0 {
+		code = http.StatusOK
+	}
+	return strconv.Itoa(code/100) + "xx"
+}
+
+// Route implements router.Module, serving the Registry at Path.
+func (m *Metrics) Route(r router.Router) {
+	path := m.Path
+	if path == "" {
+		path = "/metrics"
+	}
+
+	r.Get(path, Handler(m.Registry))
+}
+
+// Handler serves reg in the Prometheus text exposition format.
+func Handler(reg *Registry) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.WriteTo(w)
+	})
+}
+
+// HealthChecker wraps h so each check's result and latency are recorded.
+func (m *Metrics) HealthChecker(h web.HealthChecker) web.HealthChecker {
+	hc := &healthChecker{HealthChecker: h, m: m}
+	if d, ok := h.(web.HealthDetailer); ok {
+		return &detailedHealthChecker{healthChecker: hc, detailer: d}
+	}
+	return hc
+}
+
+type healthChecker struct {
+	web.HealthChecker
+
+	m *Metrics
+}
+
+// HealthCheck implements web.HealthChecker.
+func (h *healthChecker) HealthCheck(ctx context.Context) error {
+	start := time.Now()
+	err := h.HealthChecker.HealthCheck(ctx)
+
+	h.m.healthDuration.With(h.Name()).Observe(time.Since(start).Seconds())
+	if err != nil {
+		h.m.healthStatus.With(h.Name()).Set(0)
+	} else {
+		h.m.healthStatus.With(h.Name()).Set(1)
+	}
+
+	return err
+}
+
+type detailedHealthChecker struct {
+	*healthChecker
+
+	detailer web.HealthDetailer
+}
+
+// HealthDetails implements web.HealthDetailer.
+func (d *detailedHealthChecker) HealthDetails(ctx context.Context) any {
+	return d.detailer.HealthDetails(ctx)
+}
+
+// Shutdowner wraps s so how long it takes to shut down, and whether it succeeds, are recorded.
+func (m *Metrics) Shutdowner(s web.Shutdowner) web.Shutdowner {
+	return &shutdowner{Shutdowner: s, m: m}
+}
+
+type shutdowner struct {
+	web.Shutdowner
+
+	m *Metrics
+}
+
+// Shutdown implements web.Shutdowner.
+func (s *shutdowner) Shutdown(ctx context.Context) error {
+	start := time.Now()
+	err := s.Shutdowner.Shutdown(ctx)
+
+	s.m.shutdownDuration.With(s.Name()).Set(time.Since(start).Seconds())
+	if err != nil {
+		s.m.shutdownSuccess.With(s.Name()).Set(0)
+	} else {
+		s.m.shutdownSuccess.With(s.Name()).Set(1)
+	}
+
+	return err
+}
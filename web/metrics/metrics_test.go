@@ -0,0 +1,116 @@
+package metrics_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jimmysawczuk/kit/web"
+	"github.com/jimmysawczuk/kit/web/metrics"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := metrics.NewRegistry()
+
+	c := reg.Counter("jobs_total", "Jobs run.", "queue")
+	c.With("default").Inc()
+	c.With("default").Add(2)
+	c.With(`we"ird`).Inc()
+
+	g := reg.Gauge("workers", "Busy workers.\nMultiline help.")
+	g.With().Set(4)
+	g.With().Dec()
+
+	h := reg.Histogram("job_duration_seconds", "", []float64{1, 0.1})
+	h.With().Observe(0.05)
+	h.With().Observe(0.5)
+	h.With().Observe(5)
+
+	var sb strings.Builder
+	_, err := reg.WriteTo(&sb)
+	require.NoError(t, err)
+
+	require.Equal(t, `# TYPE job_duration_seconds histogram
+job_duration_seconds_bucket{le="0.1"} 1
+job_duration_seconds_bucket{le="1"} 2
+job_duration_seconds_bucket{le="+Inf"} 3
+job_duration_seconds_sum 5.55
+job_duration_seconds_count 3
+# HELP jobs_total Jobs run.
+# TYPE jobs_total counter
+jobs_total{queue="default"} 3
+jobs_total{queue="we\"ird"} 1
+# HELP workers Busy workers.\nMultiline help.
+# TYPE workers gauge
+workers 3
+`, sb.String())
+}
+
+type stats struct{}
+
+func (stats) Stats() sql.DBStats {
+	return sql.DBStats{MaxOpenConnections: 10, OpenConnections: 3, InUse: 2, Idle: 1, WaitCount: 7}
+}
+
+func TestMetrics(t *testing.T) {
+	m := metrics.New(metrics.NewRegistry())
+	m.DB("primary", stats{})
+
+	r := router.New()
+	r.Use(m.Middleware)
+	r.Getf("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	r.Getf("/fail", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	healthy := m.HealthChecker(web.NamedHealthCheckFunc("cache", func(context.Context) error { return nil }))
+	unhealthy := m.HealthChecker(web.NamedHealthCheckFunc("queue", func(context.Context) error { return errors.New("down") }))
+
+	app := web.NewApp().
+		WithRouter(r).
+		WithHealthCheck(healthy).
+		WithHealthCheck(unhealthy).
+		WithShutdown(m.Shutdowner(web.NamedShutdownFunc("server", func(context.Context) error { return nil }))).
+		WithHealthCheckHandler("/health").
+		WithModule(m)
+
+	for _, path := range []string{"/users/1", "/users/2", "/fail", "/nowhere", "/health"} {
+		app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	for _, s := range app.Shutdowners() {
+		require.NoError(t, s.Shutdown(context.Background()))
+	}
+
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "text/plain; version=0.0.4; charset=utf-8", w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/users/{id}",status="2xx"} 2`,
+		`http_requests_total{method="GET",route="/fail",status="5xx"} 1`,
+		`http_requests_total{method="GET",route="unmatched",status="4xx"} 1`,
+		`http_requests_total{method="GET",route="/health",status="5xx"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/users/{id}",status="2xx"} 2`,
+		`http_requests_in_flight{method="GET",route="/metrics"} 1`,
+		`http_requests_in_flight{method="GET",route="/users/{id}"} 0`,
+		`health_check_status{name="cache"} 1`,
+		`health_check_status{name="queue"} 0`,
+		`health_check_duration_seconds_count{name="queue"} 1`,
+		`shutdown_success{name="server"} 1`,
+		`db_pool_open_connections{db="primary"} 3`,
+		`db_pool_wait_count_total{db="primary"} 7`,
+	} {
+		require.Contains(t, body, line+"\n")
+	}
+}
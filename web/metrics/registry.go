@@ -0,0 +1,470 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Type is a Prometheus metric type.
+type Type string
+
+const (
+	CounterType   Type = "counter"
+	GaugeType     Type = "gauge"
+	HistogramType Type = "histogram"
+)
+
+// Sample is a single value in a Family.
+type Sample struct {
+	// Suffix is appended to the family's name, i.e. "_bucket" for histogram buckets.
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// Label is a name/value pair identifying a Sample.
+type Label struct {
+	Name, Value string
+}
+
+// Family is a named group of samples, as exposed to Prometheus.
+type Family struct {
+	Name    string
+	Help    string
+	Type    Type
+	Samples []Sample
+}
+
+// Collector produces metric families when the Registry is scraped. Implement it to expose values that are
+// cheaper to read on demand than to keep up to date, i.e. connection pool stats.
+type Collector interface {
+	Collect() []Family
+}
+
+// CollectorFunc adapts a function to a Collector.
+type CollectorFunc func() []Family
+
+// Collect implements Collector.
+func (f CollectorFunc) Collect() []Family {
+	return f()
+}
+
+// Registry holds metrics and renders them in the Prometheus text exposition format.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds c to the Registry.
+func (r *Registry) Register(c Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, c)
+}
+
+// Counter registers and returns a counter with the provided label names.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	v := &CounterVec{vec: newVec[*Counter](name, help, labels, func() *Counter { return &Counter{} })}
+	r.Register(v)
+	return v
+}
+
+// Gauge registers and returns a gauge with the provided label names.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	v := &GaugeVec{vec: newVec[*Gauge](name, help, labels, func() *Gauge { return &Gauge{} })}
+	r.Register(v)
+	return v
+}
+
+// Histogram registers and returns a histogram with the provided upper bounds and label names. If buckets
+// is nil, DefaultBuckets is used.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	v := &HistogramVec{
+		vec:     newVec[*Histogram](name, help, labels, func() *Histogram { return newHistogram(buckets) }),
+		buckets: buckets,
+	}
+	r.Register(v)
+	return v
+}
+
+// Gather collects every registered family, merged by name and sorted.
+func (r *Registry) Gather() []Family {
+	r.mu.Lock()
+	collectors := slices.Clone(r.collectors)
+	r.mu.Unlock()
+
+	byName := map[string]*Family{}
+	var names []string
+	for _, c := range collectors {
+		for _, f := range c.Collect() {
+			if existing, ok := byName[f.Name]; ok {
+				existing.Samples = append(existing.Samples, f.Samples...)
+				continue
+			}
+
+			f := f
+			byName[f.Name] = &f
+			names = append(names, f.Name)
+		}
+	}
+
+	sort.Strings(names)
+
+	tbr := make([]Family, len(names))
+	for i, n := range names {
+		tbr[i] = *byName[n]
+	}
+	return tbr
+}
+
+// WriteTo writes every metric to w in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	for _, f := range r.Gather() {
+		if f.Help != "" {
+			fmt.Fprintf(cw, "# HELP %s %s\n", f.Name, escapeHelp(f.Help))
+		}
+		fmt.Fprintf(cw, "# TYPE %s %s\n", f.Name, f.Type)
+
+		for _, s := range f.Samples {
+			cw.WriteString(f.Name + s.Suffix)
+			writeLabels(cw, s.Labels)
+			cw.WriteString(" " + formatFloat(s.Value) + "\n")
+		}
+	}
+
+	if err := cw.w.(*bufio.Writer).Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+
+	return cw.n, cw.err
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+func (c *countingWriter) WriteString(s string) {
+	c.Write([]byte(s))
+}
+
+func writeLabels(w *countingWriter, labels []Label) {
+	if len(labels) == 0 {
+		return
+	}
+
+	w.WriteString("{")
+	for i, l := range labels {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		w.WriteString(l.Name + `="` + escapeLabel(l.Value) + `"`)
+	}
+	w.WriteString("}")
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// atomicFloat is a float64 that can be updated concurrently.
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) Load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
+
+func (f *atomicFloat) Store(v float64) {
+	f.bits.Store(math.Float64bits(v))
+}
+
+func (f *atomicFloat) Add(v float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// vec holds one series per combination of label values.
+type vec[T any] struct {
+	name   string
+	help   string
+	labels []string
+	newFn  func() T
+
+	mu     sync.RWMutex
+	series map[string]T
+	values map[string][]string
+}
+
+func newVec[T any](name, help string, labels []string, newFn func() T) *vec[T] {
+	return &vec[T]{
+		name:   name,
+		help:   help,
+		labels: labels,
+		newFn:  newFn,
+		series: map[string]T{},
+		values: map[string][]string{},
+	}
+}
+
+func (v *vec[T]) with(values ...string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s: expected %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if s, ok := v.series[key]; ok {
+		return s
+	}
+
+	s = v.newFn()
+	v.series[key] = s
+	v.values[key] = slices.Clone(values)
+	return s
+}
+
+// each calls fn for every series, in a stable order.
+func (v *vec[T]) each(fn func(labels []Label, s T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	v.mu.RUnlock()
+
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v.mu.RLock()
+		s, values := v.series[k], v.values[k]
+		v.mu.RUnlock()
+
+		labels := make([]Label, len(values))
+		for i, val := range values {
+			labels[i] = Label{Name: v.labels[i], Value: val}
+		}
+		fn(labels, s)
+	}
+}
+
+// Counter is a value that only goes up.
+type Counter struct {
+	v atomicFloat
+}
+
+// Inc adds 1 to the counter.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add adds v, which must not be negative, to the counter.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters can't decrease")
+	}
+	c.v.Add(v)
+}
+
+// Value returns the counter's current value.
+func (c *Counter) Value() float64 {
+	return c.v.Load()
+}
+
+// CounterVec is a set of counters partitioned by labels.
+type CounterVec struct {
+	vec *vec[*Counter]
+}
+
+// With returns the counter for the provided label values, in the order the labels were registered.
+func (c *CounterVec) With(values ...string) *Counter {
+	return c.vec.with(values...)
+}
+
+// Collect implements Collector.
+func (c *CounterVec) Collect() []Family {
+	f := Family{Name: c.vec.name, Help: c.vec.help, Type: CounterType}
+	c.vec.each(func(labels []Label, s *Counter) {
+		f.Samples = append(f.Samples, Sample{Labels: labels, Value: s.Value()})
+	})
+	return []Family{f}
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	v atomicFloat
+}
+
+// Set sets the gauge to v.
+func (g *Gauge) Set(v float64) {
+	g.v.Store(v)
+}
+
+// Add adds v to the gauge.
+func (g *Gauge) Add(v float64) {
+	g.v.Add(v)
+}
+
+// Inc adds 1 to the gauge.
+func (g *Gauge) Inc() {
+	g.v.Add(1)
+}
+
+// Dec subtracts 1 from the gauge.
+func (g *Gauge) Dec() {
+	g.v.Add(-1)
+}
+
+// Value returns the gauge's current value.
+func (g *Gauge) Value() float64 {
+	return g.v.Load()
+}
+
+// GaugeVec is a set of gauges partitioned by labels.
+type GaugeVec struct {
+	vec *vec[*Gauge]
+}
+
+// With returns the gauge for the provided label values, in the order the labels were registered.
+func (g *GaugeVec) With(values ...string) *Gauge {
+	return g.vec.with(values...)
+}
+
+// Collect implements Collector.
+func (g *GaugeVec) Collect() []Family {
+	f := Family{Name: g.vec.name, Help: g.vec.help, Type: GaugeType}
+	g.vec.each(func(labels []Label, s *Gauge) {
+		f.Samples = append(f.Samples, Sample{Labels: labels, Value: s.Value()})
+	})
+	return []Family{f}
+}
+
+// DefaultBuckets are latency buckets, in seconds, suitable for most HTTP handlers.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into buckets.
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64
+	count  atomic.Uint64
+	sum    atomicFloat
+}
+
+func newHistogram(upper []float64) *Histogram {
+	return &Histogram{
+		upper:  upper,
+		counts: make([]atomic.Uint64, len(upper)),
+	}
+}
+
+// Observe records v.
+func (h *Histogram) Observe(v float64) {
+	if i := sort.SearchFloat64s(h.upper, v); i < len(h.upper) {
+		h.counts[i].Add(1)
+	}
+	h.count.Add(1)
+	h.sum.Add(v)
+}
+
+// HistogramVec is a set of histograms partitioned by labels.
+type HistogramVec struct {
+	vec     *vec[*Histogram]
+	buckets []float64
+}
+
+// With returns the histogram for the provided label values, in the order the labels were registered.
+func (h *HistogramVec) With(values ...string) *Histogram {
+	return h.vec.with(values...)
+}
+
+// Collect implements Collector.
+func (h *HistogramVec) Collect() []Family {
+	f := Family{Name: h.vec.name, Help: h.vec.help, Type: HistogramType}
+	h.vec.each(func(labels []Label, s *Histogram) {
+		var cumulative uint64
+		for i, upper := range s.upper {
+			cumulative += s.counts[i].Load()
+			f.Samples = append(f.Samples, Sample{
+				Suffix: "_bucket",
+				Labels: append(slices.Clip(labels), Label{Name: "le", Value: formatFloat(upper)}),
+				Value:  float64(cumulative),
+			})
+		}
+
+		f.Samples = append(f.Samples,
+			Sample{Suffix: "_bucket", Labels: append(slices.Clip(labels), Label{Name: "le", Value: "+Inf"}), Value: float64(s.count.Load())},
+			Sample{Suffix: "_sum", Labels: labels, Value: s.sum.Load()},
+			Sample{Suffix: "_count", Labels: labels, Value: float64(s.count.Load())},
+		)
+	})
+	return []Family{f}
+}
@@ -0,0 +1,242 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jimmysawczuk/kit/internal/httpwrap"
+	"github.com/jimmysawczuk/kit/web/respond"
+)
+
+// BodyLimitOptions configures the BodyLimit middleware.
+type BodyLimitOptions struct {
+	// MaxBytes is the largest request body accepted, as sent over the wire.
+	MaxBytes int64
+
+	// Decompress decodes gzip-encoded request bodies before the handler reads them.
+	Decompress bool
+
+	// MaxDecodedBytes is the largest a decompressed body may grow to, protecting against compression
+	// bombs. Defaults to 10 times MaxBytes, or DefaultMaxDecodedBytes if MaxBytes isn't set.
+	MaxDecodedBytes int64
+
+	// AllowedTypes, if set, lists the media types requests with a body may have, i.e. "application/json".
+	AllowedTypes []string
+}
+
+// DefaultMaxDecodedBytes is the default MaxDecodedBytes when no MaxBytes is set.
+const DefaultMaxDecodedBytes = 100 << 20
+
+// LimitBody is a shortcut for BodyLimit(BodyLimitOptions{MaxBytes: n}).
+func LimitBody(n int64) func(http.Handler) http.Handler {
+	return BodyLimit(BodyLimitOptions{MaxBytes: n})
+}
+
+// BodyLimit caps the size of request bodies according to opts. Requests that declare a Content-Length
+// over the limit are rejected up front with a 413. Otherwise reads past the limit fail with an error that
+// BodyTooLarge recognizes, and whatever the handler responds with after that is replaced with a 413.
+// Requests with an unsupported Content-Encoding or Content-Type are rejected with a 415. Attach it to the
+// routes or groups that need a different limit.
+func BodyLimit(opts BodyLimitOptions) func(http.Handler) http.Handler {
+	if opts.MaxDecodedBytes <= 0 {
+		opts.MaxDecodedBytes = DefaultMaxDecodedBytes
+		if opts.MaxBytes > 0 {
+			opts.MaxDecodedBytes = 10 * opts.MaxBytes
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if opts.MaxBytes > 0 && r.ContentLength > opts.MaxBytes {
+				respond.CodedError(ctx, http.StatusRequestEntityTooLarge, "REQUEST_TOO_LARGE", fmt.Errorf("request body must be at most %d bytes", opts.MaxBytes)).Write(w)
+				return
+			}
+
+			if len(opts.AllowedTypes) > 0 {
+				mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				if err != nil || !slices.Contains(opts.AllowedTypes, mt) {
+					respond.CodedError(ctx, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE", fmt.Errorf("content type must be one of %s", strings.Join(opts.AllowedTypes, ", "))).Write(w)
+					return
+				}
+			}
+
+			if opts.MaxBytes > 0 {
+				r.Body = http.MaxBytesReader(w, r.Body, opts.MaxBytes)
+			}
+
+			switch enc := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); {
+			case enc == "" || enc == "identity":
+			case enc == "gzip" && opts.Decompress:
+				gz, err := gzip.NewReader(r.Body)
+				if err != nil {
+					if BodyTooLarge(err) {
+						respond.CodedError(ctx, http.StatusRequestEntityTooLarge, "REQUEST_TOO_LARGE", err).Write(w)
+						return
+					}
+
+					respond.CodedError(ctx, http.StatusBadRequest, "INVALID_CONTENT_ENCODING", fmt.Errorf("gzip: new reader: %w", err)).Write(w)
+					return
+				}
+
+				r.Body = &decodedBody{Reader: gz, gz: gz, body: r.Body, remaining: opts.MaxDecodedBytes, limit: opts.MaxDecodedBytes}
+				r.Header.Del("Content-Encoding")
+				r.Header.Del("Content-Length")
+				r.ContentLength = -1
+			default:
+				resp := respond.CodedError(ctx, http.StatusUnsupportedMediaType, "UNSUPPORTED_CONTENT_ENCODING", fmt.Errorf("content encoding %q is not supported", enc))
+				resp.WithHeader(func(h http.Header) http.Header {
+					if opts.Decompress {
+						h.Set("Accept-Encoding", "gzip")
+					} else {
+						h.Set("Accept-Encoding", "identity")
+					}
+					return h
+				})
+				resp.Write(w)
+				return
+			}
+
+			body := &limitedBody{ReadCloser: r.Body}
+			r.Body = body
+
+			lw := &limitWriter{Writer: httpwrap.Writer{ResponseWriter: w}, ctx: ctx, body: body, header: w.Header().Clone()}
+			next.ServeHTTP(lw, r)
+
+			if !lw.wroteHeader && body.err != nil {
+				lw.replace()
+			}
+		})
+	}
+}
+
+// BodyTooLarge reports whether err came from reading past a request body limit set by BodyLimit.
+func BodyTooLarge(err error) bool {
+	var mbe *http.MaxBytesError
+	return errors.As(err, &mbe)
+}
+
+// decodedBody limits how much can be read from a decompressed request body.
+type decodedBody struct {
+	io.Reader
+
+	gz        *gzip.Reader
+	body      io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	if d.remaining <= 0 {
+		// Check whether there's anything left before declaring the body too large.
+		var one [1]byte
+		if n, err := d.Reader.Read(one[:]); n == 0 {
+			return 0, err
+		}
+		return 0, &http.MaxBytesError{Limit: d.limit}
+	}
+
+	if int64(len(p)) > d.remaining {
+		p = p[:d.remaining]
+	}
+
+	n, err := d.Reader.Read(p)
+	d.remaining -= int64(n)
+	return n, err
+}
+
+func (d *decodedBody) Close() error {
+	d.gz.Close()
+	return d.body.Close()
+}
+
+// limitedBody records whether the handler read past the body limit.
+type limitedBody struct {
+	io.ReadCloser
+
+	err error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && b.err == nil && BodyTooLarge(err) {
+		b.err = err
+	}
+	return n, err
+}
+
+// limitWriter replaces the handler's response with a 413 if it read past the body limit before
+// responding.
+type limitWriter struct {
+	httpwrap.Writer
+
+	ctx  context.Context
+	body *limitedBody
+
+	// header is the response header as it was before the handler ran, so the handler's headers can be
+	// dropped along with its response.
+	header http.Header
+
+	wroteHeader bool
+	replaced    bool
+}
+
+func (lw *limitWriter) WriteHeader(code int) {
+	if lw.wroteHeader {
+		return
+	}
+	lw.wroteHeader = true
+
+	if lw.body.err == nil || code == http.StatusRequestEntityTooLarge {
+		lw.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	lw.replace()
+}
+
+// replace responds with a 413 in place of the handler's response, dropping its headers.
+func (lw *limitWriter) replace() {
+	lw.wroteHeader = true
+	lw.replaced = true
+
+	h := lw.ResponseWriter.Header()
+	clear(h)
+	for k, v := range lw.header {
+		h[k] = v
+	}
+
+	respond.CodedError(lw.ctx, http.StatusRequestEntityTooLarge, "REQUEST_TOO_LARGE", lw.body.err).Write(lw.ResponseWriter)
+}
+
+func (lw *limitWriter) Write(b []byte) (int, error) {
+	if !lw.wroteHeader {
+		lw.WriteHeader(http.StatusOK)
+	}
+	if lw.replaced {
+		return len(b), nil
+	}
+	return lw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (lw *limitWriter) Flush() {
+	httpwrap.Flush(lw.ResponseWriter, func() {
+		if !lw.wroteHeader {
+			lw.WriteHeader(http.StatusOK)
+		}
+	})
+}
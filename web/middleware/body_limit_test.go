@@ -0,0 +1,133 @@
+package middleware_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jimmysawczuk/kit/web/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(s))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestBodyLimit(t *testing.T) {
+	h := middleware.BodyLimit(middleware.BodyLimitOptions{
+		MaxBytes:        100,
+		Decompress:      true,
+		MaxDecodedBytes: 1000,
+		AllowedTypes:    []string{"application/json", "text/plain"},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The middleware replaces whatever the handler responds with once the body is too large.
+		body, err := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Write(body)
+	}))
+
+	bomb := gzipped(t, strings.Repeat("a", 100000))
+	require.Less(t, len(bomb), 1000)
+
+	tests := []struct {
+		name     string
+		body     []byte
+		headers  map[string]string
+		chunked  bool
+		status   int
+		expected string
+	}{
+		{"OK", []byte("hello"), nil, false, http.StatusOK, "hello"},
+		{"DECLARED_TOO_LARGE", bytes.Repeat([]byte("a"), 101), nil, false, http.StatusRequestEntityTooLarge, `"code":"REQUEST_TOO_LARGE"`},
+		{"STREAMED_TOO_LARGE", bytes.Repeat([]byte("a"), 101), nil, true, http.StatusRequestEntityTooLarge, `"code":"REQUEST_TOO_LARGE"`},
+		{"GZIP", gzipped(t, "hello, gzip"), map[string]string{"Content-Encoding": "gzip"}, false, http.StatusOK, "hello, gzip"},
+		{"GZIP_BOMB", bomb, map[string]string{"Content-Encoding": "gzip"}, false, http.StatusRequestEntityTooLarge, `"code":"REQUEST_TOO_LARGE"`},
+		{"INVALID_GZIP", []byte("not gzip"), map[string]string{"Content-Encoding": "gzip"}, false, http.StatusBadRequest, `"code":"INVALID_CONTENT_ENCODING"`},
+		{"UNSUPPORTED_ENCODING", []byte("hello"), map[string]string{"Content-Encoding": "br"}, false, http.StatusUnsupportedMediaType, `"code":"UNSUPPORTED_CONTENT_ENCODING"`},
+		{"UNSUPPORTED_TYPE", []byte("<a/>"), map[string]string{"Content-Type": "application/xml"}, false, http.StatusUnsupportedMediaType, `"code":"UNSUPPORTED_MEDIA_TYPE"`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var body io.Reader = bytes.NewReader(test.body)
+			if test.chunked {
+				// Hide the length so the limit is only discovered while reading.
+				body = io.MultiReader(body)
+			}
+
+			r := httptest.NewRequest("POST", "/", body)
+			r.Header.Set("Content-Type", "text/plain; charset=utf-8")
+			if test.chunked {
+				r.ContentLength = -1
+			}
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			require.Equal(t, test.status, w.Code)
+			require.Contains(t, w.Body.String(), test.expected)
+			if test.status == http.StatusRequestEntityTooLarge {
+				require.Equal(t, []string{"application/json; charset=utf-8"}, w.Header().Values("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestBodyLimitNoResponse(t *testing.T) {
+	h := middleware.BodyLimit(middleware.BodyLimitOptions{
+		MaxBytes: 10,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handled", "true")
+		io.ReadAll(r.Body)
+	}))
+
+	r := httptest.NewRequest("POST", "/", io.MultiReader(strings.NewReader(strings.Repeat("a", 11))))
+	r.ContentLength = -1
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	require.Contains(t, w.Body.String(), `"code":"REQUEST_TOO_LARGE"`)
+	require.Equal(t, []string{"application/json; charset=utf-8"}, w.Header().Values("Content-Type"))
+	require.Empty(t, w.Header().Get("X-Handled"))
+}
+
+func TestBodyLimitDecompressOnly(t *testing.T) {
+	h := middleware.BodyLimit(middleware.BodyLimitOptions{
+		Decompress: true,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		w.Write(body)
+	}))
+
+	r := httptest.NewRequest("POST", "/", bytes.NewReader(gzipped(t, "hello, gzip")))
+	r.Header.Set("Content-Encoding", "gzip")
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "hello, gzip", w.Body.String())
+}
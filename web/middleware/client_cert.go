@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jimmysawczuk/kit/web/clientcert"
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/rs/zerolog"
+)
+
+// ClientCert requires the request to have been made with a verified client certificate, then sets the
+// client's identity on the context and logger. If any allow patterns are provided, the certificate's
+// common name or one of its URI SANs must match one of them (see clientcert.Identity.Matches). Attach
+// it to a group to enforce a different allow-list for that group's routes.
+func ClientCert(allow ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			id, ok := clientcert.FromRequest(r)
+			if !ok {
+				respond.CodedError(ctx, http.StatusUnauthorized, "CLIENT_CERT_REQUIRED", errors.New("a verified client certificate is required")).Write(w)
+				return
+			}
+
+			if len(allow) > 0 && !allowed(id, allow) {
+				respond.CodedError(ctx, http.StatusForbidden, "CLIENT_CERT_NOT_ALLOWED", fmt.Errorf("client %q is not allowed", id.Name())).Write(w)
+				return
+			}
+
+			ctx = clientcert.Set(ctx, id)
+
+			zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("@client.id", id.Name()).
+					Str("@client.serial", id.SerialNumber)
+			})
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func allowed(id clientcert.Identity, allow []string) bool {
+	for _, pattern := range allow {
+		if id.Matches(pattern) {
+			return true
+		}
+	}
+	return false
+}
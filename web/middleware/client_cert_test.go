@@ -0,0 +1,53 @@
+package middleware_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jimmysawczuk/kit/web/clientcert"
+	"github.com/jimmysawczuk/kit/web/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCert(t *testing.T) {
+	h := middleware.ClientCert("billing", "spiffe://example.org/*")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := clientcert.Get(r.Context())
+		w.Write([]byte(id.CommonName))
+	}))
+
+	verified := func(cn string) *tls.ConnectionState {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}, SerialNumber: big.NewInt(1)}
+		return &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{cert},
+			VerifiedChains:   [][]*x509.Certificate{{cert}},
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		tls    *tls.ConnectionState
+		status int
+		body   string
+	}{
+		{"NO_TLS", nil, http.StatusUnauthorized, `"code":"CLIENT_CERT_REQUIRED"`},
+		{"UNVERIFIED", &tls.ConnectionState{}, http.StatusUnauthorized, `"code":"CLIENT_CERT_REQUIRED"`},
+		{"NOT_ALLOWED", verified("orders"), http.StatusForbidden, `"code":"CLIENT_CERT_NOT_ALLOWED"`},
+		{"ALLOWED", verified("billing"), http.StatusOK, "billing"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.TLS = tt.tls
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			require.Equal(t, tt.status, w.Code)
+			require.Contains(t, w.Body.String(), tt.body)
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/jimmysawczuk/kit/web/clientip"
+	"github.com/rs/zerolog"
+)
+
+// ClientIP resolves the client's IP address using res, then sets it on the context and logger. If res
+// is nil, clientip.DefaultResolver is used.
+func ClientIP(res *clientip.Resolver) func(http.Handler) http.Handler {
+	if res == nil {
+		res = clientip.DefaultResolver
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addr := res.Resolve(r)
+			if !addr.IsValid() {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := clientip.Set(r.Context(), addr)
+
+			zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("@req.ip", addr.String())
+			})
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
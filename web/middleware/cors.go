@@ -0,0 +1,202 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jimmysawczuk/kit/internal/httpwrap"
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/jimmysawczuk/kit/web/router"
+)
+
+// CORSOptions configures the CORS middleware.
+type CORSOptions struct {
+	// AllowedOrigins lists the origins that may make cross-origin requests, i.e. "https://example.com".
+	// A "*" in place of the leftmost label allows any subdomain ("https://*.example.com"), and a bare
+	// "*" allows any origin.
+	AllowedOrigins []string
+
+	// AllowedHeaders lists the request headers that may be sent. If empty, whatever headers the browser
+	// asks for in a preflight are allowed.
+	AllowedHeaders []string
+
+	// ExposedHeaders lists the response headers that scripts are allowed to read.
+	ExposedHeaders []string
+
+	// AllowCredentials allows cookies and other credentials to be sent with cross-origin requests.
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache a preflight response.
+	MaxAge time.Duration
+
+	// Routes returns the route table used to compute the methods allowed for a path in a preflight
+	// response, i.e. App.Routes or Router.Routes. If nil, AllowedMethods is used for every path. It's
+	// called once, on the first preflight, so every route should be registered before the app serves.
+	Routes func() []router.Route
+
+	// AllowedMethods is used when Routes is nil. Defaults to GET, HEAD, POST, PUT, PATCH and DELETE.
+	AllowedMethods []string
+}
+
+var defaultCORSMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// CORS handles cross-origin requests according to opts. Preflight requests are answered directly,
+// without calling the wrapped handler, so CORS should be attached to the top-level router (via Use)
+// rather than to a group or route, which chi only runs once a route has matched.
+func CORS(opts CORSOptions) func(http.Handler) http.Handler {
+	if opts.Routes == nil && len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = defaultCORSMethods
+	}
+	if opts.Routes != nil {
+		opts.Routes = sync.OnceValue(opts.Routes)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Responses differ by origin even when there isn't one, since they're sent without any CORS
+			// headers, so a shared cache mustn't serve them to cross-origin requests.
+			httpwrap.AddVary(w.Header(), "Origin")
+			if r.Method == http.MethodOptions {
+				httpwrap.AddVary(w.Header(), "Access-Control-Request-Method")
+				httpwrap.AddVary(w.Header(), "Access-Control-Request-Headers")
+			}
+
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if !opts.originAllowed(origin) {
+				if preflight {
+					respond.CodedError(r.Context(), http.StatusForbidden, "CORS_ORIGIN_NOT_ALLOWED", fmt.Errorf("origin %s is not allowed", origin)).Write(w)
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			opts.setOrigin(w.Header(), origin)
+
+			if !preflight {
+				if len(opts.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(opts.ExposedHeaders, ", "))
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			methods := opts.methods(r.URL.Path)
+			if len(methods) == 0 {
+				// There's nothing here to allow; let the router respond as it would to any other unmatched request.
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+			if headers := opts.headers(r.Header.Get("Access-Control-Request-Headers")); headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+
+			if opts.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+func (o CORSOptions) originAllowed(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+
+	for _, allowed := range o.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+
+		scheme, host, ok := strings.Cut(allowed, "://")
+		if !ok || !strings.EqualFold(scheme, u.Scheme) {
+			continue
+		}
+
+		if suffix, ok := strings.CutPrefix(host, "*."); ok {
+			if len(u.Host) > len(suffix)+1 && strings.HasSuffix(strings.ToLower(u.Host), "."+strings.ToLower(suffix)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (o CORSOptions) setOrigin(h http.Header, origin string) {
+	if slices.Contains(o.AllowedOrigins, "*") && !o.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if o.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// methods returns the methods that are registered for path, or AllowedMethods if there's no route table.
+func (o CORSOptions) methods(path string) []string {
+	if o.Routes == nil {
+		return o.AllowedMethods
+	}
+
+	var tbr []string
+	for _, route := range o.Routes() {
+		if route.Match(path) && !slices.Contains(tbr, route.Method) {
+			tbr = append(tbr, route.Method)
+		}
+	}
+
+	slices.Sort(tbr)
+	return tbr
+}
+
+// headers returns the value for Access-Control-Allow-Headers given the headers requested by the browser.
+func (o CORSOptions) headers(requested string) string {
+	if len(o.AllowedHeaders) == 0 {
+		return requested
+	}
+
+	var tbr []string
+	for _, h := range strings.Split(requested, ",") {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+
+		if slices.ContainsFunc(o.AllowedHeaders, func(a string) bool { return strings.EqualFold(a, h) }) {
+			tbr = append(tbr, h)
+		}
+	}
+
+	return strings.Join(tbr, ", ")
+}
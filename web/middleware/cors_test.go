@@ -0,0 +1,142 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web/middleware"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORS(t *testing.T) {
+	r := router.New()
+	r.Use(middleware.CORS(middleware.CORSOptions{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		ExposedHeaders:   []string{"X-Request-Id"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+		Routes:           r.Routes,
+	}))
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	r.Getf("/orders/{orderID}", ok)
+	r.Patchf("/orders/{orderID}", ok)
+	r.Deletef("/orders/{orderID}", ok)
+	r.Postf("/orders", ok)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		headers map[string]string
+		status  int
+		expect  map[string]string
+	}{
+		{
+			name:   "NO_ORIGIN",
+			method: http.MethodGet,
+			path:   "/orders/1",
+			status: http.StatusOK,
+			expect: map[string]string{"Access-Control-Allow-Origin": "", "Vary": "Origin"},
+		},
+		{
+			name:    "SIMPLE_ALLOWED",
+			method:  http.MethodGet,
+			path:    "/orders/1",
+			headers: map[string]string{"Origin": "https://app.example.com"},
+			status:  http.StatusOK,
+			expect: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "X-Request-Id",
+				"Vary":                             "Origin",
+			},
+		},
+		{
+			name:    "SIMPLE_WILDCARD_SUBDOMAIN",
+			method:  http.MethodGet,
+			path:    "/orders/1",
+			headers: map[string]string{"Origin": "https://a.b.example.org"},
+			status:  http.StatusOK,
+			expect:  map[string]string{"Access-Control-Allow-Origin": "https://a.b.example.org"},
+		},
+		{
+			name:    "SIMPLE_WILDCARD_APEX_NOT_ALLOWED",
+			method:  http.MethodGet,
+			path:    "/orders/1",
+			headers: map[string]string{"Origin": "https://example.org"},
+			status:  http.StatusOK,
+			expect:  map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "PREFLIGHT",
+			method: http.MethodOptions,
+			path:   "/orders/1",
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  "PATCH",
+				"Access-Control-Request-Headers": "content-type, x-unknown",
+			},
+			status: http.StatusNoContent,
+			expect: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "DELETE, GET, PATCH",
+				"Access-Control-Allow-Headers": "content-type",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name:   "PREFLIGHT_OTHER_PATH",
+			method: http.MethodOptions,
+			path:   "/orders",
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "POST",
+			},
+			status: http.StatusNoContent,
+			expect: map[string]string{"Access-Control-Allow-Methods": "POST"},
+		},
+		{
+			name:   "PREFLIGHT_ORIGIN_NOT_ALLOWED",
+			method: http.MethodOptions,
+			path:   "/orders/1",
+			headers: map[string]string{
+				"Origin":                        "https://evil.example.com",
+				"Access-Control-Request-Method": "GET",
+			},
+			status: http.StatusForbidden,
+			expect: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name:   "PREFLIGHT_UNKNOWN_PATH",
+			method: http.MethodOptions,
+			path:   "/missing",
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": "GET",
+			},
+			status: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.path, nil)
+			for k, v := range test.headers {
+				req.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			require.Equal(t, test.status, w.Code)
+			for k, v := range test.expect {
+				require.Equal(t, v, w.Header().Get(k), k)
+			}
+		})
+	}
+}
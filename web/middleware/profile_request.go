@@ -4,11 +4,12 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/jimmysawczuk/kit/internal/httpwrap"
 	"github.com/rs/zerolog"
 )
 
 type loggableResponseWriter struct {
-	http.ResponseWriter
+	httpwrap.Writer
 
 	start time.Time
 	end   time.Time
@@ -22,8 +23,8 @@ type loggableResponseWriter struct {
 func ProfileRequest(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		lrw := &loggableResponseWriter{
-			ResponseWriter: w,
-			start:          time.Now(),
+			Writer: httpwrap.Writer{ResponseWriter: w},
+			start:  time.Now(),
 		}
 
 		log := zerolog.Ctx(r.Context())
@@ -1,32 +1,92 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	rdebug "runtime/debug"
 
+	"github.com/jimmysawczuk/kit/internal/httpwrap"
 	"github.com/jimmysawczuk/kit/web/respond"
 	"github.com/rs/zerolog"
 )
 
+// PanicHook is called with the value and stack of a recovered panic, i.e. to report it to an external
+// error tracker.
+type PanicHook func(ctx context.Context, r *http.Request, p any, stack []byte)
+
 // Recoverer catches any panics that the wrapped Handler might cause.
 func Recoverer(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		defer func() {
-			if p := recover(); p != nil {
-				log := zerolog.Ctx(r.Context())
+	return RecovererWithHook(nil)(h)
+}
+
+// RecovererWithHook catches any panics that the wrapped Handler might cause, logs them along with their
+// stack and writes a 500 to the client if a response hasn't already been started. If hook is non-nil,
+// it's called with every recovered panic. Panics with http.ErrAbortHandler are passed through so the
+// server can abort the response.
+func RecovererWithHook(hook PanicHook) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &recoverWriter{Writer: httpwrap.Writer{ResponseWriter: w}}
+
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+
+				ctx := r.Context()
+				log := zerolog.Ctx(ctx)
+				stack := rdebug.Stack()
 
 				err := fmt.Errorf("panic: %v", p)
 
 				log.Error().
 					Err(err).
+					Str("stack", string(stack)).
 					Msg("recovered from panic")
 
-				rdebug.PrintStack()
-				respond.Error(r.Context(), http.StatusInternalServerError, err)
-			}
-		}()
+				if hook != nil {
+					hook(ctx, r, p, stack)
+				}
+
+				if rw.wroteHeader {
+					return
+				}
+
+				respond.Error(ctx, http.StatusInternalServerError, err).Write(w)
+			}()
+
+			h.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// recoverWriter tracks whether the response has been started, so we know whether it's still possible to
+// write an error response.
+type recoverWriter struct {
+	httpwrap.Writer
+
+	wroteHeader bool
+}
+
+func (rw *recoverWriter) WriteHeader(code int) {
+	rw.wroteHeader = true
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recoverWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(b)
+}
 
-		h.ServeHTTP(w, r)
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (rw *recoverWriter) Flush() {
+	httpwrap.Flush(rw.ResponseWriter, func() {
+		rw.wroteHeader = true
 	})
 }
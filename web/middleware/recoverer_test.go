@@ -0,0 +1,65 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jimmysawczuk/kit/web/middleware"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecoverer(t *testing.T) {
+	buf := bytes.Buffer{}
+	log := zerolog.New(&buf)
+
+	var hooked any
+	h := middleware.RecovererWithHook(func(ctx context.Context, r *http.Request, p any, stack []byte) {
+		hooked = p
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r = r.WithContext(log.WithContext(r.Context()))
+	w := httptest.NewRecorder()
+
+	h.ServeHTTP(w, r)
+
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+	require.JSONEq(t, `{"error":"panic: boom","status":500}`, w.Body.String())
+	require.Equal(t, "boom", hooked)
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	require.Equal(t, "panic: boom", entry["error"])
+	require.Contains(t, entry["stack"], "TestRecoverer")
+}
+
+func TestRecovererHeadersSent(t *testing.T) {
+	h := middleware.Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("partial"))
+		panic("boom")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Equal(t, "partial", w.Body.String())
+}
+
+func TestRecovererAbortHandler(t *testing.T) {
+	h := middleware.Recoverer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	require.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	})
+}
@@ -8,18 +8,29 @@ import (
 )
 
 // RequestID determines whether a request ID should be created or gleaned from the request, then
-// sets it on the context.
+// sets it on the context and echoes it on the response. It uses requestid.DefaultGenerator.
 func RequestID(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		id := requestid.Next(r)
+	return RequestIDFrom(requestid.DefaultGenerator)(h)
+}
 
-		ctx := r.Context()
-		ctx = requestid.Set(ctx, id)
+// RequestIDFrom is RequestID using the provided Generator, i.e. to read request IDs from other headers or
+// generate them in another format.
+func RequestIDFrom(g *requestid.Generator) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := g.Next(r)
 
-		zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
-			return c.Str("@id", id)
-		})
+			ctx := r.Context()
+			ctx = g.Set(ctx, id)
+
+			zerolog.Ctx(ctx).UpdateContext(func(c zerolog.Context) zerolog.Context {
+				return c.Str("@id", id)
+			})
 
-		h.ServeHTTP(w, r.WithContext(ctx))
-	})
+			// Set before the handler runs so every response carries it, whoever writes it.
+			w.Header().Set(g.Header(), id)
+
+			h.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
 }
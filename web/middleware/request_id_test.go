@@ -0,0 +1,57 @@
+package middleware_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jimmysawczuk/kit/web/middleware"
+	"github.com/jimmysawczuk/kit/web/requestid"
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestID(t *testing.T) {
+	g := &requestid.Generator{
+		Prefix:         "test",
+		Headers:        []string{"X-Request-Id", "Traceparent"},
+		ResponseHeader: "X-Correlation-Id",
+	}
+
+	tests := []struct {
+		name    string
+		inbound string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"PLAIN_RESPONSE", "abc-123", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		}, "abc-123"},
+		{"JSON_ERROR", "abc-123", func(w http.ResponseWriter, r *http.Request) {
+			respond.CodedError(r.Context(), http.StatusTeapot, "TEAPOT", errors.New("short and stout")).Write(w)
+		}, "abc-123"},
+		{"REJECTED_INBOUND", "abc\r\n123", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, "test-000000001"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var seen string
+			h := middleware.RequestIDFrom(g)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestid.Get(r.Context())
+				test.handler(w, r)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header["X-Request-Id"] = []string{test.inbound}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			require.Equal(t, test.want, seen)
+			require.Equal(t, []string{test.want}, w.Header().Values("X-Correlation-Id"))
+			require.Empty(t, w.Header().Values("X-Request-Id"))
+		})
+	}
+}
@@ -32,13 +32,15 @@ func WithTimeout(timeout time.Duration) func(http.Handler) http.Handler {
 			doneCh := make(chan bool, 1)
 			panicCh := make(chan error, 1)
 			go func() {
-				// If our handler panics, print the stack from here so we get a nice clean stack trace. Then
+				// If our handler panics, capture the stack from here so we get a nice clean stack trace. Then
 				// write an Internal Server Error to the ResponseWriter.
 				defer func() {
 					if p := recover(); p != nil {
 						err := fmt.Errorf("panic: %v", p)
-						log.Error().Err(err).Msg("with timeout: recovered from panic")
-						rdebug.PrintStack()
+						log.Error().
+							Err(err).
+							Str("stack", string(rdebug.Stack())).
+							Msg("with timeout: recovered from panic")
 						panicCh <- err
 					}
 				}()
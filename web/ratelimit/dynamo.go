@@ -0,0 +1,126 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/jimmysawczuk/kit/aws/dtable"
+)
+
+// DynamoStore is a Store that keeps state in a DynamoDB table, so limits can be shared between instances.
+// Each key is stored as its own item, updated with conditional writes. Enable DynamoDB's TTL on
+// TTLAttribute to have expired items cleaned up.
+type DynamoStore struct {
+	Table *dtable.Table
+
+	// Schema names the table's key and TTL attributes. Items are written with a sort key of "ratelimit".
+	dtable.Schema
+}
+
+var _ Store = &DynamoStore{}
+
+// NewDynamoStore returns a DynamoStore for a table with a "pk" partition key and no sort key.
+func NewDynamoStore(t *dtable.Table) *DynamoStore {
+	return &DynamoStore{
+		Table: t,
+	}
+}
+
+func (d *DynamoStore) key(key string) map[string]*dynamodb.AttributeValue {
+	return d.ItemKey("ratelimit", key)
+}
+
+func number(v string) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(v)}
+}
+
+func parseNumber(item map[string]*dynamodb.AttributeValue, name string) (float64, error) {
+	av, ok := item[name]
+	if !ok || av.N == nil {
+		return 0, fmt.Errorf("missing attribute %s", name)
+	}
+
+	v, err := strconv.ParseFloat(*av.N, 64)
+	if err != nil {
+		return 0, fmt.Errorf("strconv: parse float (attribute: %s): %w", name, err)
+	}
+
+	return v, nil
+}
+
+// Get implements Store.
+func (d *DynamoStore) Get(ctx context.Context, key string) (State, error) {
+	out, err := d.Table.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:            d.key(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return State{}, fmt.Errorf("dtable: get item: %w", err)
+	}
+
+	if len(out.Item) == 0 {
+		return State{}, nil
+	}
+
+	if expired, err := d.Expired(out.Item, time.Now()); err != nil || expired {
+		return State{}, err
+	}
+
+	var s State
+	var vals [4]float64
+	for i, name := range []string{"count", "prev", "stamp", "version"} {
+		if vals[i], err = parseNumber(out.Item, name); err != nil {
+			return State{}, err
+		}
+	}
+
+	s.Count, s.Prev = vals[0], vals[1]
+	s.Stamp = time.Unix(0, int64(vals[2]))
+	s.Version = int64(vals[3])
+
+	return s, nil
+}
+
+// CompareAndSwap implements Store.
+func (d *DynamoStore) CompareAndSwap(ctx context.Context, key string, prev, next State, ttl time.Duration) (bool, error) {
+	item := d.key(key)
+	item["count"] = number(strconv.FormatFloat(next.Count, 'f', -1, 64))
+	item["prev"] = number(strconv.FormatFloat(next.Prev, 'f', -1, 64))
+	item["stamp"] = number(strconv.FormatInt(next.Stamp.UnixNano(), 10))
+	item["version"] = number(strconv.FormatInt(next.Version, 10))
+	item[d.TTLAttributeName()] = dtable.Unix(time.Now().Add(ttl))
+
+	in := &dynamodb.PutItemInput{
+		Item: item,
+	}
+
+	if prev.Version == 0 {
+		in.ConditionExpression = aws.String("attribute_not_exists(#pk) OR #ttl < :now")
+		in.ExpressionAttributeNames = map[string]*string{
+			"#pk":  aws.String(d.PartitionKeyName()),
+			"#ttl": aws.String(d.TTLAttributeName()),
+		}
+		in.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":now": dtable.Unix(time.Now()),
+		}
+	} else {
+		in.ConditionExpression = aws.String("#version = :version")
+		in.ExpressionAttributeNames = map[string]*string{"#version": aws.String("version")}
+		in.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":version": number(strconv.FormatInt(prev.Version, 10)),
+		}
+	}
+
+	if _, err := d.Table.PutItem(ctx, in); err != nil {
+		if dtable.ConditionFailed(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("dtable: put item: %w", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,75 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many writes a MemoryStore allows between sweeps for expired keys.
+const sweepEvery = 1024
+
+type memoryEntry struct {
+	state   State
+	expires time.Time
+}
+
+// MemoryStore is a Store that keeps state in memory. It's only suitable for limits enforced by a single
+// instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	writes  int
+}
+
+var _ Store = &MemoryStore{}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: map[string]memoryEntry{},
+	}
+}
+
+// Get implements Store.
+func (m *MemoryStore) Get(_ context.Context, key string) (State, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		return State{}, nil
+	}
+
+	return e.state, nil
+}
+
+// CompareAndSwap implements Store.
+func (m *MemoryStore) CompareAndSwap(_ context.Context, key string, prev, next State, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+
+	e, ok := m.entries[key]
+	if ok && now.After(e.expires) {
+		ok = false
+	}
+
+	if (ok && e.state.Version != prev.Version) || (!ok && prev.Version != 0) {
+		return false, nil
+	}
+
+	m.entries[key] = memoryEntry{state: next, expires: now.Add(ttl)}
+
+	m.writes++
+	if m.writes%sweepEvery == 0 {
+		for k, e := range m.entries {
+			if now.After(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/jimmysawczuk/kit/web/auth"
+	"github.com/jimmysawczuk/kit/web/clientip"
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/rs/zerolog"
+)
+
+// KeyFunc returns the key that a request is limited by. If it returns an empty string, the request
+// isn't limited.
+type KeyFunc func(*http.Request) (string, error)
+
+// ByClientIP limits requests by the client's IP address, as set by middleware.ClientIP. If it hasn't been
+// set, the address is resolved with clientip.DefaultResolver.
+func ByClientIP(r *http.Request) (string, error) {
+	addr := clientip.Get(r.Context())
+	if !addr.IsValid() {
+		addr = clientip.Resolve(r)
+	}
+
+	if !addr.IsValid() {
+		return "", fmt.Errorf("couldn't determine client ip")
+	}
+
+	return addr.String(), nil
+}
+
+// ByHeader limits requests by the value of the named request header.
+func ByHeader(name string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		return r.Header.Get(name), nil
+	}
+}
+
+// ByContext limits requests by a value taken from the request's context, i.e. the authenticated principal.
+func ByContext(fn func(context.Context) string) KeyFunc {
+	return func(r *http.Request) (string, error) {
+		return fn(r.Context()), nil
+	}
+}
+
+// ByPrincipal limits requests by the subject of the authenticated auth.Principal. Unauthenticated requests
+// aren't limited, so pair it with another limit keyed by ByClientIP.
+func ByPrincipal(r *http.Request) (string, error) {
+	return auth.Subject(r.Context()), nil
+}
+
+// Options configures the rate limiting middleware.
+type Options struct {
+	// Name distinguishes this limit's keys from any other limits that share a Store.
+	Name string
+
+	// Key determines which key a request is limited by. Defaults to ByClientIP.
+	Key KeyFunc
+
+	// PerRoute limits each route pattern separately, rather than sharing the limit among every route the
+	// middleware is attached to.
+	PerRoute bool
+
+	// FailOpen allows requests through if the Store returns an error, rather than responding with a 503.
+	FailOpen bool
+}
+
+// Middleware limits requests using l. Every response gets RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers; requests over the limit get a 429 with a Retry-After header.
+func Middleware(l *Limiter, opts Options) func(http.Handler) http.Handler {
+	if opts.Key == nil {
+		opts.Key = ByClientIP
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			log := zerolog.Ctx(ctx)
+
+			key, err := opts.Key(r)
+			if err != nil {
+				log.Error().Err(err).Msg("ratelimit: couldn't determine key")
+				respond.Error(ctx, http.StatusInternalServerError, errors.New("couldn't determine rate limit key")).Write(w)
+				return
+			}
+
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if opts.PerRoute {
+				if rctx := chi.RouteContext(ctx); rctx != nil {
+					key = r.Method + " " + routePattern(rctx, r) + "|" + key
+				}
+			}
+
+			if opts.Name != "" {
+				key = opts.Name + "|" + key
+			}
+
+			res, err := l.Allow(ctx, key)
+			if err != nil {
+				log.Error().Err(err).Str("key", key).Msg("ratelimit: allow")
+
+				if opts.FailOpen {
+					next.ServeHTTP(w, r)
+					return
+				}
+
+				respond.CodedError(ctx, http.StatusServiceUnavailable, "RATE_LIMIT_UNAVAILABLE", errors.New("rate limit unavailable")).Write(w)
+				return
+			}
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			h.Set("RateLimit-Reset", ceilSeconds(res.Reset))
+
+			if !res.Allowed {
+				h.Set("Retry-After", ceilSeconds(res.RetryAfter))
+
+				log.Warn().
+					Str("key", key).
+					Dur("retryAfter", res.RetryAfter).
+					Msg("ratelimit: limit exceeded")
+
+				respond.CodedError(ctx, http.StatusTooManyRequests, "RATE_LIMITED", errors.New("rate limit exceeded")).Write(w)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func ceilSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// routePattern returns the pattern of the route r will be handled by. Middleware added with Use runs
+// before the router has matched the request, and middleware in a Group or Route before the rest of the
+// pattern is known, so the route is looked up ahead of time.
+func routePattern(rctx *chi.Context, r *http.Request) string {
+	if rctx.Routes != nil {
+		tctx := chi.NewRouteContext()
+		if rctx.Routes.Match(tctx, r.Method, r.URL.Path) {
+			return tctx.RoutePattern()
+		}
+	}
+	return rctx.RoutePattern()
+}
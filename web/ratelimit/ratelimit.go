@@ -0,0 +1,211 @@
+// Package ratelimit limits how often clients can make requests, using state kept in a pluggable Store so
+// that limits can be shared between instances.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// ErrContention is returned when a Limiter can't update a key's state because other requests keep
+// updating it first.
+var ErrContention = errors.New("ratelimit: too much contention")
+
+// State is the stored state of a single rate limit key. Its meaning depends on the Algorithm.
+type State struct {
+	// Count is the number of tokens left in a token bucket, or the number of requests made in the current
+	// window of a sliding window.
+	Count float64
+
+	// Prev is the number of requests made in the previous window of a sliding window.
+	Prev float64
+
+	// Stamp is when a token bucket was last refilled, or when the current window of a sliding window started.
+	Stamp time.Time
+
+	// Version is incremented every time the state is stored. A Version of 0 means there's no stored state.
+	Version int64
+}
+
+// Result describes the outcome of taking from a rate limit.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+
+	// Reset is how long until the limit is fully replenished.
+	Reset time.Duration
+
+	// RetryAfter is how long until a request would be allowed, if this one wasn't.
+	RetryAfter time.Duration
+}
+
+// Algorithm decides whether a request is allowed given a key's state.
+type Algorithm interface {
+	// Take attempts to take a single request from s at now, returning the new state and the result.
+	Take(now time.Time, s State) (State, Result)
+
+	// TTL is how long a key's state needs to be kept after it's last updated.
+	TTL() time.Duration
+}
+
+type tokenBucket struct {
+	limit int
+	per   time.Duration
+}
+
+// TokenBucket allows bursts of up to limit requests, refilling continuously at a rate of limit requests
+// per period.
+func TokenBucket(limit int, per time.Duration) Algorithm {
+	return tokenBucket{limit: limit, per: per}
+}
+
+func (tb tokenBucket) TTL() time.Duration {
+	return tb.per
+}
+
+func (tb tokenBucket) Take(now time.Time, s State) (State, Result) {
+	capacity := float64(tb.limit)
+	rate := capacity / tb.per.Seconds()
+
+	tokens := capacity
+	if s.Version > 0 {
+		tokens = math.Min(capacity, s.Count+now.Sub(s.Stamp).Seconds()*rate)
+	}
+
+	res := Result{Limit: tb.limit}
+	if tokens >= 1 {
+		res.Allowed = true
+		tokens--
+	} else {
+		res.RetryAfter = seconds((1 - tokens) / rate)
+	}
+
+	res.Remaining = int(tokens)
+	res.Reset = seconds((capacity - tokens) / rate)
+
+	s.Count = tokens
+	s.Stamp = now
+
+	return s, res
+}
+
+type slidingWindow struct {
+	limit  int
+	window time.Duration
+}
+
+// SlidingWindow allows limit requests in any window-long period, approximated by weighting the count from
+// the previous fixed window by how much of it overlaps the sliding window.
+func SlidingWindow(limit int, window time.Duration) Algorithm {
+	return slidingWindow{limit: limit, window: window}
+}
+
+func (sw slidingWindow) TTL() time.Duration {
+	return 2 * sw.window
+}
+
+func (sw slidingWindow) Take(now time.Time, s State) (State, Result) {
+	start := now.Truncate(sw.window)
+
+	if !s.Stamp.Equal(start) {
+		if s.Version > 0 && s.Stamp.Equal(start.Add(-sw.window)) {
+			s.Prev = s.Count
+		} else {
+			s.Prev = 0
+		}
+		s.Count = 0
+		s.Stamp = start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(sw.window)
+	estimate := s.Prev*weight + s.Count
+
+	limit := float64(sw.limit)
+	res := Result{Limit: sw.limit}
+
+	if estimate+1 <= limit {
+		res.Allowed = true
+		s.Count++
+		estimate++
+	} else if s.Count+1 > limit || s.Prev == 0 {
+		// Even once the previous window stops counting, this window is full.
+		res.RetryAfter = sw.window - elapsed
+	} else {
+		// Wait until enough of the previous window has slid out.
+		w := (limit - s.Count - 1) / s.Prev
+		res.RetryAfter = time.Duration((1-w)*float64(sw.window)) - elapsed
+	}
+
+	res.Remaining = max(0, int(limit-estimate))
+	res.Reset = sw.window - elapsed
+
+	return s, res
+}
+
+func seconds(v float64) time.Duration {
+	return time.Duration(math.Ceil(v * float64(time.Second)))
+}
+
+// Store persists rate limit state.
+type Store interface {
+	// Get returns the state stored for key. If there's no state stored, it returns the zero State.
+	Get(ctx context.Context, key string) (State, error)
+
+	// CompareAndSwap stores next for key if the stored state's Version still matches prev's, returning
+	// whether it was stored. The stored state may be discarded once ttl passes.
+	CompareAndSwap(ctx context.Context, key string, prev, next State, ttl time.Duration) (bool, error)
+}
+
+// maxAttempts is how many times Allow will retry a CompareAndSwap that lost a race.
+const maxAttempts = 10
+
+// Limiter applies an Algorithm to keys whose state is kept in a Store.
+type Limiter struct {
+	Algorithm Algorithm
+	Store     Store
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// New returns a Limiter that applies alg to state kept in store.
+func New(alg Algorithm, store Store) *Limiter {
+	return &Limiter{
+		Algorithm: alg,
+		Store:     store,
+	}
+}
+
+// Allow takes a single request from the limit for key.
+func (l *Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	now := time.Now
+	if l.Now != nil {
+		now = l.Now
+	}
+
+	for range maxAttempts {
+		prev, err := l.Store.Get(ctx, key)
+		if err != nil {
+			return Result{}, fmt.Errorf("store: get: %w", err)
+		}
+
+		next, res := l.Algorithm.Take(now(), prev)
+		next.Version = prev.Version + 1
+
+		ok, err := l.Store.CompareAndSwap(ctx, key, prev, next, l.Algorithm.TTL())
+		if err != nil {
+			return Result{}, fmt.Errorf("store: compare and swap: %w", err)
+		}
+
+		if ok {
+			return res, nil
+		}
+	}
+
+	return Result{}, ErrContention
+}
@@ -0,0 +1,201 @@
+package ratelimit_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web/ratelimit"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/stretchr/testify/require"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newLimiter(alg ratelimit.Algorithm) (*ratelimit.Limiter, *clock) {
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := ratelimit.New(alg, ratelimit.NewMemoryStore())
+	l.Now = c.Now
+	return l, c
+}
+
+func TestTokenBucket(t *testing.T) {
+	l, c := newLimiter(ratelimit.TokenBucket(3, 3*time.Second))
+	ctx := context.Background()
+
+	for i := range 3 {
+		res, err := l.Allow(ctx, "a")
+		require.NoError(t, err)
+		require.True(t, res.Allowed)
+		require.Equal(t, 2-i, res.Remaining)
+	}
+
+	res, err := l.Allow(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, res.Allowed)
+	require.Equal(t, time.Second, res.RetryAfter)
+	require.Equal(t, 3*time.Second, res.Reset)
+
+	// Other keys have their own bucket.
+	res, err = l.Allow(ctx, "b")
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+
+	c.now = c.now.Add(time.Second)
+
+	res, err = l.Allow(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+	require.Equal(t, 0, res.Remaining)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	l, c := newLimiter(ratelimit.SlidingWindow(4, time.Minute))
+	ctx := context.Background()
+
+	for range 4 {
+		res, err := l.Allow(ctx, "a")
+		require.NoError(t, err)
+		require.True(t, res.Allowed)
+	}
+
+	res, err := l.Allow(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, res.Allowed)
+	require.Equal(t, time.Minute, res.RetryAfter)
+
+	// Halfway through the next window, half of the previous window's requests still count.
+	c.now = c.now.Add(90 * time.Second)
+
+	for range 2 {
+		res, err := l.Allow(ctx, "a")
+		require.NoError(t, err)
+		require.True(t, res.Allowed)
+	}
+
+	res, err = l.Allow(ctx, "a")
+	require.NoError(t, err)
+	require.False(t, res.Allowed)
+	require.Equal(t, 15*time.Second, res.RetryAfter)
+
+	c.now = c.now.Add(15 * time.Second)
+
+	res, err = l.Allow(ctx, "a")
+	require.NoError(t, err)
+	require.True(t, res.Allowed)
+}
+
+func TestMemoryStoreCompareAndSwap(t *testing.T) {
+	s := ratelimit.NewMemoryStore()
+	ctx := context.Background()
+
+	ok, err := s.CompareAndSwap(ctx, "a", ratelimit.State{}, ratelimit.State{Count: 1, Version: 1}, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// A stale write loses.
+	ok, err = s.CompareAndSwap(ctx, "a", ratelimit.State{}, ratelimit.State{Count: 2, Version: 1}, time.Minute)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	st, err := s.Get(ctx, "a")
+	require.NoError(t, err)
+	require.Equal(t, 1.0, st.Count)
+
+	// Expired state is as good as missing.
+	ok, err = s.CompareAndSwap(ctx, "b", ratelimit.State{}, ratelimit.State{Count: 1, Version: 1}, -time.Second)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	st, err = s.Get(ctx, "b")
+	require.NoError(t, err)
+	require.Zero(t, st.Version)
+}
+
+func TestMiddleware(t *testing.T) {
+	l, _ := newLimiter(ratelimit.TokenBucket(1, time.Minute))
+
+	r := router.New()
+	r.Group(func(r router.Router) {
+		r.Getf("/a", func(w http.ResponseWriter, r *http.Request) {})
+		r.Getf("/b", func(w http.ResponseWriter, r *http.Request) {})
+	}, ratelimit.Middleware(l, ratelimit.Options{
+		Name:     "test",
+		Key:      ratelimit.ByHeader("X-Api-Client"),
+		PerRoute: true,
+	}))
+
+	do := func(path, client string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Api-Client", client)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("/a", "one")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "1", w.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "60", w.Header().Get("RateLimit-Reset"))
+
+	w = do("/a", "one")
+	require.Equal(t, http.StatusTooManyRequests, w.Code)
+	require.Equal(t, "60", w.Header().Get("Retry-After"))
+	require.Contains(t, w.Body.String(), `"code":"RATE_LIMITED"`)
+
+	require.Equal(t, http.StatusOK, do("/b", "one").Code)
+	require.Equal(t, http.StatusOK, do("/a", "two").Code)
+
+	// Requests without a key aren't limited.
+	require.Equal(t, http.StatusOK, do("/a", "").Code)
+	require.Equal(t, http.StatusOK, do("/a", "").Code)
+}
+
+func TestMiddlewarePerRouteUse(t *testing.T) {
+	l, _ := newLimiter(ratelimit.TokenBucket(1, time.Minute))
+	mw := ratelimit.Middleware(l, ratelimit.Options{
+		Key:      ratelimit.ByHeader("X-Api-Client"),
+		PerRoute: true,
+	})
+
+	r := router.New()
+	r.Use(mw)
+	r.Getf("/a", func(w http.ResponseWriter, r *http.Request) {})
+	r.Getf("/b/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	r.Route("/api", func(r router.Router) {
+		r.Use(ratelimit.Middleware(l, ratelimit.Options{
+			Name:     "api",
+			Key:      ratelimit.ByHeader("X-Api-Client"),
+			PerRoute: true,
+		}))
+		r.Getf("/c", func(w http.ResponseWriter, r *http.Request) {})
+		r.Getf("/d", func(w http.ResponseWriter, r *http.Request) {})
+	})
+
+	do := func(path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Api-Client", "one")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, do("/a"))
+	require.Equal(t, http.StatusTooManyRequests, do("/a"))
+
+	// Each route has its own limit, shared by every request matching its pattern.
+	require.Equal(t, http.StatusOK, do("/b/1"))
+	require.Equal(t, http.StatusTooManyRequests, do("/b/2"))
+
+	// Middleware inside a Route sees the whole pattern, not just "/api/*".
+	require.Equal(t, http.StatusOK, do("/api/c"))
+	require.Equal(t, http.StatusTooManyRequests, do("/api/c"))
+	require.Equal(t, http.StatusOK, do("/api/d"))
+}
@@ -0,0 +1,72 @@
+package requestid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// crockford is the Crockford base32 alphabet used by ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULID returns a new ULID: a 48-bit millisecond timestamp followed by 80 random bits, as 26 characters of
+// Crockford base32. ULIDs sort by creation time. Use it as a Generator's Format.
+func ULID() string {
+	var b [16]byte
+	putMillis(b[:6], time.Now())
+	rand.Read(b[6:])
+
+	// 128 bits is 26 base32 characters, the first holding only the top 3 bits.
+	var out [26]byte
+	for i := range out {
+		bit := 128 - 5*(len(out)-i)
+		out[i] = crockford[bitsAt(b[:], bit)]
+	}
+
+	return string(out[:])
+}
+
+// bitsAt returns the 5 bits of b starting at bit, treating bits before the start of b as zero.
+func bitsAt(b []byte, bit int) byte {
+	var v byte
+	for j := range 5 {
+		n := bit + j
+		v <<= 1
+		if n >= 0 && b[n/8]&(0x80>>(n%8)) != 0 {
+			v |= 1
+		}
+	}
+	return v
+}
+
+// UUIDv7 returns a new RFC 9562 version 7 UUID: a 48-bit millisecond timestamp followed by random bits,
+// in the usual hyphenated hex form. UUIDv7s sort by creation time. Use it as a Generator's Format.
+func UUIDv7() string {
+	var b [16]byte
+	putMillis(b[:6], time.Now())
+	rand.Read(b[6:])
+
+	b[6] = b[6]&0x0f | 0x70 // version 7
+	b[8] = b[8]&0x3f | 0x80 // RFC 9562 variant
+
+	var out [36]byte
+	hex.Encode(out[0:8], b[0:4])
+	out[8] = '-'
+	hex.Encode(out[9:13], b[4:6])
+	out[13] = '-'
+	hex.Encode(out[14:18], b[6:8])
+	out[18] = '-'
+	hex.Encode(out[19:23], b[8:10])
+	out[23] = '-'
+	hex.Encode(out[24:], b[10:])
+
+	return string(out[:])
+}
+
+// putMillis writes t's Unix time in milliseconds to b as a 48-bit big-endian integer.
+func putMillis(b []byte, t time.Time) {
+	ms := uint64(t.UnixMilli())
+	for i := range 6 {
+		b[i] = byte(ms >> (40 - 8*i))
+	}
+}
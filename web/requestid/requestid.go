@@ -7,7 +7,10 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"sync/atomic"
+
+	"github.com/jimmysawczuk/kit/tracing"
 )
 
 type ctxKey int
@@ -41,10 +44,30 @@ func HostnamePrefix(length int) string {
 	return fmt.Sprintf("%s/%s", hostname, base64.RawURLEncoding.EncodeToString(buf))
 }
 
+// DefaultHeader is the header request IDs are read from and echoed on if a Generator doesn't say otherwise.
+const DefaultHeader = "X-Request-Id"
+
+// DefaultMaxLength is the longest inbound request ID accepted if a Generator doesn't say otherwise.
+const DefaultMaxLength = 128
+
 // Generator generates request IDs with the set prefix.
 type Generator struct {
 	Prefix string
 
+	// Headers are checked in order for an inbound request ID. Traceparent and X-Amzn-Trace-Id are
+	// understood, and contribute their trace ID, so request IDs line up with distributed traces. Defaults
+	// to DefaultHeader.
+	Headers []string
+
+	// ResponseHeader is the header the request ID is echoed on. Defaults to DefaultHeader.
+	ResponseHeader string
+
+	// MaxLength is the longest inbound request ID accepted. Defaults to DefaultMaxLength.
+	MaxLength int
+
+	// Format generates new request IDs, i.e. ULID or UUIDv7. Defaults to Prefix followed by a counter.
+	Format func() string
+
 	id uint64
 }
 
@@ -54,30 +77,128 @@ var DefaultGenerator = &Generator{
 	Prefix: HostnamePrefix(8),
 }
 
-// Next checks the passed request for an incoming request ID; if its set, that's the request ID we use.
-// Otherwise, we'll generate the next request ID and return it.
+// Next checks the passed request's Headers for an incoming request ID; if there's a valid one, that's the
+// request ID we use. Otherwise, we'll generate the next request ID and return it.
 func (c *Generator) Next(r *http.Request) string {
-	requestID := r.Header.Get("X-Request-Id")
-	if requestID == "" {
-		id := atomic.AddUint64(&c.id, 1)
-		requestID = fmt.Sprintf("%s-%09d", c.Prefix, id)
+	if id, ok := c.inbound(r.Header); ok {
+		return id
+	}
+
+	if c.Format != nil {
+		return c.Format()
 	}
-	return requestID
+
+	id := atomic.AddUint64(&c.id, 1)
+	return fmt.Sprintf("%s-%09d", c.Prefix, id)
+}
+
+// Header returns the header the request ID should be echoed on.
+func (c *Generator) Header() string {
+	if c.ResponseHeader == "" {
+		return DefaultHeader
+	}
+	return c.ResponseHeader
+}
+
+func (c *Generator) inbound(h http.Header) (string, bool) {
+	headers := c.Headers
+	if len(headers) == 0 {
+		headers = []string{DefaultHeader}
+	}
+
+	maxLength := c.MaxLength
+	if maxLength <= 0 {
+		maxLength = DefaultMaxLength
+	}
+
+	for _, name := range headers {
+		v := h.Get(name)
+		if v == "" {
+			continue
+		}
+
+		switch http.CanonicalHeaderKey(name) {
+		case "Traceparent":
+			sc, ok := tracing.ParseTraceparent(v)
+			if !ok {
+				continue
+			}
+			v = sc.TraceID().String()
+
+		case "X-Amzn-Trace-Id":
+			v = amznTraceRoot(v)
+		}
+
+		if id, ok := Sanitize(v, maxLength); ok {
+			return id, true
+		}
+	}
+
+	return "", false
+}
+
+// amznTraceRoot returns the Root field of an X-Amzn-Trace-Id header, i.e. "1-5759e988-bd862e3fe1be46a994272793"
+// from "Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1".
+func amznTraceRoot(v string) string {
+	for _, field := range strings.Split(v, ";") {
+		if k, val, ok := strings.Cut(strings.TrimSpace(field), "="); ok && k == "Root" {
+			return val
+		}
+	}
+	return ""
+}
+
+// Sanitize checks an untrusted request ID before it's logged or echoed. Surrounding whitespace is
+// trimmed; IDs that are empty, longer than maxLength or contain anything other than letters, digits and
+// the punctuation "-_.:/+=@" are rejected, so a client can't inject content into logs or headers.
+func Sanitize(id string, maxLength int) (string, bool) {
+	id = strings.TrimSpace(id)
+	if id == "" || len(id) > maxLength {
+		return "", false
+	}
+
+	for i := 0; i < len(id); i++ {
+		switch b := id[i]; {
+		case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		case strings.IndexByte("-_.:/+=@", b) >= 0:
+		default:
+			return "", false
+		}
+	}
+
+	return id, true
+}
+
+// ctxValue is a request ID along with the header it's echoed on.
+type ctxValue struct {
+	id     string
+	header string
 }
 
 // Get attempts to get the current request ID from the provided context.Context. It'll return an
 // empty string if the context doesn't contain a request ID.
 func (c *Generator) Get(ctx context.Context) string {
-	v, _ := ctx.Value(requestIDKey).(string)
-	return v
+	v, _ := ctx.Value(requestIDKey).(ctxValue)
+	return v.id
 }
 
-// Set returns a copy of the provided context.Context with the provided request ID as a value.
+// Set returns a copy of the provided context.Context with the provided request ID as a value. The
+// Generator's Header is stored along with it, so responses can echo the ID on the right header.
 func (c *Generator) Set(parent context.Context, id string) context.Context {
-	ctx := context.WithValue(parent, requestIDKey, id)
+	ctx := context.WithValue(parent, requestIDKey, ctxValue{id: id, header: c.Header()})
 	return ctx
 }
 
+// Header returns the header the context's request ID is echoed on, as set by the Generator that set it,
+// or DefaultHeader if the context doesn't contain a request ID.
+func Header(ctx context.Context) string {
+	v, ok := ctx.Value(requestIDKey).(ctxValue)
+	if !ok {
+		return DefaultHeader
+	}
+	return v.header
+}
+
 // Next wraps DefaultGenerator.Next.
 func Next(r *http.Request) string {
 	return DefaultGenerator.Next(r)
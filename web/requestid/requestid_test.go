@@ -3,8 +3,10 @@ package requestid_test
 import (
 	"context"
 	"net/http"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jimmysawczuk/kit/web/requestid"
 )
@@ -101,3 +103,104 @@ func TestPackageLevelFunctions(t *testing.T) {
 		t.Fatalf("expected pkg-test, got %q", got)
 	}
 }
+
+func TestGenerator_Next_Headers(t *testing.T) {
+	g := &requestid.Generator{
+		Prefix:  "test",
+		Headers: []string{"X-Request-Id", "X-Amzn-Trace-Id", "traceparent"},
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{"REQUEST_ID", map[string]string{"X-Request-Id": "abc-123", "Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, "abc-123"},
+		{"AMZN_TRACE_ID", map[string]string{"X-Amzn-Trace-Id": "Self=1-67891234-12456789abcdef012345678;Root=1-5759e988-bd862e3fe1be46a994272793;Sampled=1"}, "1-5759e988-bd862e3fe1be46a994272793"},
+		{"TRACEPARENT", map[string]string{"Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"INVALID_FALLS_THROUGH", map[string]string{"X-Request-Id": "bad\nid", "Traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"INVALID_TRACEPARENT", map[string]string{"Traceparent": "00-zzz"}, ""},
+		{"TOO_LONG", map[string]string{"X-Request-Id": strings.Repeat("a", 129)}, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+
+			id := g.Next(r)
+			if test.want == "" {
+				if !strings.HasPrefix(id, "test-") {
+					t.Fatalf("expected a generated ID, got %q", id)
+				}
+				return
+			}
+			if id != test.want {
+				t.Fatalf("expected %q, got %q", test.want, id)
+			}
+		})
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+		ok   bool
+	}{
+		{"PLAIN", "req-001", "req-001", true},
+		{"PUNCTUATION", "h/a_B.1:2+3=4@x", "h/a_B.1:2+3=4@x", true},
+		{"TRIMMED", "  req-001 ", "req-001", true},
+		{"EMPTY", "   ", "", false},
+		{"NEWLINE", "req\n{\"level\":\"error\"}", "", false},
+		{"QUOTE", `req"001`, "", false},
+		{"SPACE", "req 001", "", false},
+		{"UNICODE", "req‐001", "", false},
+		{"TOO_LONG", strings.Repeat("a", 17), "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := requestid.Sanitize(test.in, 16)
+			if ok != test.ok || got != test.want {
+				t.Fatalf("expected (%q, %v), got (%q, %v)", test.want, test.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestFormats(t *testing.T) {
+	ulid := regexp.MustCompile(`^[0-7][0-9A-HJKMNP-TV-Z]{25}$`)
+	uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	tests := []struct {
+		name   string
+		format func() string
+		re     *regexp.Regexp
+	}{
+		{"ULID", requestid.ULID, ulid},
+		{"UUIDV7", requestid.UUIDv7, uuid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := &requestid.Generator{Format: test.format}
+			r, _ := http.NewRequest(http.MethodGet, "/", nil)
+
+			first := g.Next(r)
+			if !test.re.MatchString(first) {
+				t.Fatalf("unexpected format: %q", first)
+			}
+
+			time.Sleep(2 * time.Millisecond)
+
+			second := g.Next(r)
+			if second == first || second < first {
+				t.Fatalf("expected %q to sort after %q", second, first)
+			}
+		})
+	}
+}
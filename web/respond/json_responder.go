@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 
 	"github.com/jimmysawczuk/kit/web/requestid"
 	"github.com/rs/zerolog"
@@ -53,7 +54,7 @@ func (jr *JSONResponder) CodedError(ctx context.Context, httpStatus int, code st
 
 	reqID := requestid.Get(ctx)
 	if reqID != "" {
-		resp.header.Set("X-Request-Id", reqID)
+		resp.header.Set(requestid.Header(ctx), reqID)
 	}
 
 	if ct := resp.header.Get("Content-Type"); ct == "" {
@@ -107,7 +108,7 @@ func (jr *JSONResponder) Success(ctx context.Context, httpStatus int, body any)
 
 	reqID := requestid.Get(ctx)
 	if reqID != "" {
-		resp.header.Set("X-Request-Id", reqID)
+		resp.header.Set(requestid.Header(ctx), reqID)
 	}
 
 	return resp
@@ -126,7 +127,10 @@ func (r *JSONResponse) Write(w http.ResponseWriter) error {
 
 	for h := range r.header {
 		for _, v := range r.header[h] {
-			w.Header().Add(h, v)
+			// Middleware may have set the same value already, i.e. the request ID.
+			if !slices.Contains(w.Header().Values(h), v) {
+				w.Header().Add(h, v)
+			}
 		}
 	}
 
@@ -2,6 +2,10 @@ package router
 
 import (
 	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -48,21 +52,113 @@ type Route struct {
 	Method  string
 	Path    string
 	Handler string
+
+	// Annotations are collected from the route's middleware that implement Annotator, i.e. the
+	// permissions required to access the route.
+	Annotations []string
+}
+
+// Annotator is an optional interface for the http.Handlers returned by middleware, describing what the
+// middleware enforces. Middleware is probed with a no-op handler to collect them when it's added with Use
+// or registered with a route, so middleware that implements it shouldn't have side effects when it's
+// applied.
+type Annotator interface {
+	Annotations() []string
+}
+
+// routeHandler is a handler registered through a Router, along with the annotations collected from its
+// middleware when it was registered.
+type routeHandler struct {
+	http.Handler
+
+	annotations []string
+}
+
+// Match reports whether path would be routed to the Route's pattern, ignoring the method.
+func (r Route) Match(path string) bool {
+	re := compiledPattern(r.Path)
+	return re != nil && re.MatchString(path)
+}
+
+// patterns caches the regexps for route patterns, since middleware matches them on every request. Route
+// tables are fixed once an app is serving, so it only grows while they're built.
+var patterns sync.Map
+
+// compiledPattern returns the regexp for a route pattern, or nil if it doesn't compile.
+func compiledPattern(pattern string) *regexp.Regexp {
+	if re, ok := patterns.Load(pattern); ok {
+		return re.(*regexp.Regexp)
+	}
+
+	re, err := patternRegexp(pattern)
+	if err != nil {
+		re = nil
+	}
+
+	patterns.Store(pattern, re)
+	return re
+}
+
+// patternRegexp converts a chi route pattern, i.e. /users/{id:[0-9]+}/*, into an anchored regexp.
+func patternRegexp(pattern string) (*regexp.Regexp, error) {
+	b := strings.Builder{}
+	b.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '{':
+			// Find the matching brace; param regexps can contain their own braces.
+			depth, end := 0, -1
+			for j := i; j < len(pattern) && end < 0; j++ {
+				switch pattern[j] {
+				case '{':
+					depth++
+				case '}':
+					depth--
+					if depth == 0 {
+						end = j
+					}
+				}
+			}
+			if end < 0 {
+				end = len(pattern) - 1
+			}
+
+			if _, rexpat, ok := strings.Cut(pattern[i+1:end], ":"); ok {
+				b.WriteString("(" + strings.TrimSuffix(strings.TrimPrefix(rexpat, "^"), "$") + ")")
+			} else {
+				b.WriteString("[^/]+")
+			}
+			i = end
+		case '*':
+			b.WriteString(".*")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+
+	b.WriteString("$")
+	return regexp.Compile(b.String())
 }
 
 type chiRouter struct {
 	chi chi.Router
+
+	// annotations are collected from the middleware added with Use, to this router and its parents.
+	annotations *[]string
 }
 
 func New() Router {
 	return chiRouter{
-		chi: chi.NewRouter(),
+		chi:         chi.NewRouter(),
+		annotations: new([]string),
 	}
 }
 
-func newSubrouter(in chi.Router) Router {
+func newSubrouter(in chi.Router, annotations []string) Router {
 	return chiRouter{
-		chi: in,
+		chi:         in,
+		annotations: &annotations,
 	}
 }
 
@@ -71,11 +167,10 @@ func (ro chiRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (ro chiRouter) Method(method string, path string, handler http.Handler, mws ...Middleware) {
-	if len(mws) > 0 {
-		ro.chi.Method(method, path, chain(handler, mws...))
-	} else {
-		ro.chi.Method(method, path, handler)
-	}
+	ro.chi.Method(method, path, routeHandler{
+		Handler:     chain(handler, mws...),
+		annotations: annotations(*ro.annotations, mws),
+	})
 }
 
 func (ro chiRouter) Connect(path string, handler http.Handler, mws ...Middleware) {
@@ -152,7 +247,7 @@ func (ro chiRouter) Tracef(path string, handler http.HandlerFunc, mws ...Middlew
 
 func (ro chiRouter) Group(f func(Router), mws ...Middleware) {
 	ro.chi.Group(func(inner chi.Router) {
-		rr := newSubrouter(inner)
+		rr := newSubrouter(inner, slices.Clone(*ro.annotations))
 		rr.Use(mws...)
 		f(rr)
 	})
@@ -160,7 +255,7 @@ func (ro chiRouter) Group(f func(Router), mws ...Middleware) {
 
 func (ro chiRouter) Route(path string, f func(Router), mws ...Middleware) {
 	ro.chi.Route(path, func(inner chi.Router) {
-		rr := newSubrouter(inner)
+		rr := newSubrouter(inner, slices.Clone(*ro.annotations))
 		rr.Use(mws...)
 		f(rr)
 	})
@@ -181,20 +276,51 @@ func (ro chiRouter) Mount(path string, h http.Handler, mws ...Middleware) {
 
 func (ro chiRouter) Use(m ...Middleware) {
 	ro.chi.Use(m...)
+	*ro.annotations = annotations(*ro.annotations, m)
 }
 
+// Routes returns the routes registered so far. Routes that weren't registered through a Router, i.e.
+// those of a mounted handler, have their middleware probed for annotations on every call.
 func (ro chiRouter) Routes() []Route {
 	tbr := []Route{}
 	chi.Walk(ro.chi, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		var anns []string
+		if rh, ok := handler.(routeHandler); ok {
+			anns = rh.annotations
+		} else {
+			anns = annotations(nil, middlewares)
+		}
+
 		tbr = append(tbr, Route{
-			Method: method,
-			Path:   route,
+			Method:      method,
+			Path:        route,
+			Annotations: slices.Clone(anns),
 		})
 		return nil
 	})
 	return tbr
 }
 
+var nopHandler = http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+
+// annotations returns base along with the annotations from any of mws that produce an Annotator.
+func annotations(base []string, mws []Middleware) []string {
+	tbr := append([]string(nil), base...)
+	for _, mw := range mws {
+		a, ok := mw(nopHandler).(Annotator)
+		if !ok {
+			continue
+		}
+
+		for _, v := range a.Annotations() {
+			if !slices.Contains(tbr, v) {
+				tbr = append(tbr, v)
+			}
+		}
+	}
+	return tbr
+}
+
 // chain applies middlewares to a handler
 func chain(h http.Handler, mws ...func(http.Handler) http.Handler) http.Handler {
 	for i := len(mws) - 1; i >= 0; i-- {
@@ -3,6 +3,7 @@ package router_test
 import (
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
@@ -177,3 +178,83 @@ func TestNestedRouteParamsWithMiddleware(t *testing.T) {
 		t.Errorf("expected recipeID to be 'recipe-789', got '%s'", capturedRecipeID)
 	}
 }
+
+func TestRouteMatch(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/users", "/users", true},
+		{"/users", "/users/1", false},
+		{"/users/{userID}", "/users/123", true},
+		{"/users/{userID}", "/users/123/orders", false},
+		{"/users/{userID:[0-9]+}", "/users/123", true},
+		{"/users/{userID:[0-9]+}", "/users/abc", false},
+		{"/codes/{code:[a-z]{3}}", "/codes/abc", true},
+		{"/codes/{code:[a-z]{3}}", "/codes/abcd", false},
+		{"/files/*", "/files/a/b/c.txt", true},
+		{"/files/*", "/other/a", false},
+		{"/v1.0/ping", "/v1x0/ping", false},
+		{"/bad/{id:[}", "/bad/1", false},
+		{"/bad/{id:[}", "/bad/[", false},
+	} {
+		got := router.Route{Path: tt.pattern}.Match(tt.path)
+		if got != tt.want {
+			t.Errorf("%s matching %s: expected %v, got %v", tt.pattern, tt.path, tt.want, got)
+		}
+	}
+}
+
+// annotated is a handler produced by middleware that reports what it enforces.
+type annotated struct {
+	http.Handler
+
+	annotations []string
+}
+
+func (a annotated) Annotations() []string {
+	return a.annotations
+}
+
+func TestRoutesAnnotations(t *testing.T) {
+	probes := 0
+	annotate := func(v string) router.Middleware {
+		return func(next http.Handler) http.Handler {
+			probes++
+			return annotated{Handler: next, annotations: []string{v}}
+		}
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+
+	r := router.New()
+	r.Use(annotate("app"))
+	r.Getf("/health", ok)
+	r.Route("/orders", func(r router.Router) {
+		r.Getf("/", ok)
+		r.Postf("/", ok, annotate("write"))
+	}, annotate("orders"))
+
+	want := map[string][]string{
+		"GET /health":   {"app"},
+		"GET /orders/":  {"app", "orders"},
+		"POST /orders/": {"app", "orders", "write"},
+	}
+
+	registered := probes
+	for range 3 {
+		got := map[string][]string{}
+		for _, route := range r.Routes() {
+			got[route.Method+" "+route.Path] = route.Annotations
+		}
+
+		if !reflect.DeepEqual(want, got) {
+			t.Fatalf("expected annotations %v, got %v", want, got)
+		}
+	}
+
+	if probes != registered {
+		t.Errorf("expected middleware to be probed only when routes are registered, got %d more probes", probes-registered)
+	}
+}
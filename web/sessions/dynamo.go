@@ -0,0 +1,76 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/jimmysawczuk/kit/aws/dtable"
+)
+
+// DynamoStore is a Store that keeps sessions in a DynamoDB table. Enable DynamoDB's TTL on TTLAttribute to
+// have expired sessions cleaned up.
+type DynamoStore struct {
+	Table *dtable.Table
+
+	// Schema names the table's key and TTL attributes. Items are written with a sort key of "session".
+	dtable.Schema
+}
+
+var _ Store = &DynamoStore{}
+
+// NewDynamoStore returns a DynamoStore for a table with a "pk" partition key and no sort key.
+func NewDynamoStore(t *dtable.Table) *DynamoStore {
+	return &DynamoStore{
+		Table: t,
+	}
+}
+
+func (d *DynamoStore) key(id string) map[string]*dynamodb.AttributeValue {
+	return d.ItemKey("session", id)
+}
+
+// Load implements Store.
+func (d *DynamoStore) Load(ctx context.Context, id string) ([]byte, error) {
+	out, err := d.Table.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:            d.key(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dtable: get item: %w", err)
+	}
+
+	if len(out.Item) == 0 || out.Item["data"] == nil {
+		return nil, nil
+	}
+
+	if expired, err := d.Expired(out.Item, time.Now()); err != nil || expired {
+		return nil, err
+	}
+
+	return out.Item["data"].B, nil
+}
+
+// Save implements Store.
+func (d *DynamoStore) Save(ctx context.Context, id string, data []byte, expires time.Time) error {
+	item := d.key(id)
+	item["data"] = &dynamodb.AttributeValue{B: data}
+	item[d.TTLAttributeName()] = dtable.Unix(expires)
+
+	if _, err := d.Table.PutItem(ctx, &dynamodb.PutItemInput{Item: item}); err != nil {
+		return fmt.Errorf("dtable: put item: %w", err)
+	}
+
+	return nil
+}
+
+// Delete implements Store.
+func (d *DynamoStore) Delete(ctx context.Context, id string) error {
+	if _, err := d.Table.DeleteItem(ctx, &dynamodb.DeleteItemInput{Key: d.key(id)}); err != nil {
+		return fmt.Errorf("dtable: delete item: %w", err)
+	}
+
+	return nil
+}
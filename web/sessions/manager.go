@@ -0,0 +1,363 @@
+package sessions
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jimmysawczuk/kit/internal/httpwrap"
+	"github.com/rs/zerolog"
+)
+
+// ErrCookieTooLarge is returned when a session kept in a cookie is too large for browsers to store.
+var ErrCookieTooLarge = errors.New("sessions: cookie too large")
+
+// ErrInvalidCookie is returned when a cookie can't be decrypted with any of the Manager's keys.
+var ErrInvalidCookie = errors.New("sessions: invalid cookie")
+
+// maxCookieSize is the largest cookie browsers are guaranteed to store.
+const maxCookieSize = 4096
+
+// touchInterval is how stale a session's LastSeen can get before an otherwise unchanged session is saved
+// again to push its idle expiry back.
+const touchInterval = time.Minute
+
+// Store keeps sessions on the server. IDs passed to a Store are hashes of the session IDs sent to
+// clients, so a leaked store doesn't leak usable session cookies.
+type Store interface {
+	// Load returns the data saved for id, or nil if there isn't any or it has expired.
+	Load(ctx context.Context, id string) ([]byte, error)
+
+	// Save stores data for id until expires.
+	Save(ctx context.Context, id string, data []byte, expires time.Time) error
+
+	// Delete removes the data for id.
+	Delete(ctx context.Context, id string) error
+}
+
+// Manager loads and saves sessions around requests.
+type Manager struct {
+	// Keys are 16, 24 or 32 byte AES keys used to encrypt cookies. The first key encrypts; all of them
+	// are tried when decrypting, so keys can be rotated by prepending a new one and dropping the oldest
+	// once cookies encrypted with it have expired.
+	Keys [][]byte
+
+	// Store keeps sessions on the server. If nil, the whole session is kept in the cookie.
+	Store Store
+
+	// IdleTimeout expires sessions that haven't been used for this long. Defaults to 24 hours.
+	IdleTimeout time.Duration
+
+	// AbsoluteTimeout expires sessions this long after they were created, regardless of use. Defaults to
+	// 7 days.
+	AbsoluteTimeout time.Duration
+
+	// Cookie is the template for the session cookie. Name defaults to "session" and Path to "/"; the
+	// cookie is always HttpOnly.
+	Cookie http.Cookie
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+func (m *Manager) now() time.Time {
+	if m.Now != nil {
+		return m.Now()
+	}
+	return time.Now()
+}
+
+func (m *Manager) idleTimeout() time.Duration {
+	if m.IdleTimeout <= 0 {
+		return 24 * time.Hour
+	}
+	return m.IdleTimeout
+}
+
+func (m *Manager) absoluteTimeout() time.Duration {
+	if m.AbsoluteTimeout <= 0 {
+		return 7 * 24 * time.Hour
+	}
+	return m.AbsoluteTimeout
+}
+
+func (m *Manager) cookieName() string {
+	if m.Cookie.Name == "" {
+		return "session"
+	}
+	return m.Cookie.Name
+}
+
+// storeID hashes a session ID for use as a Store key.
+func storeID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// expired reports whether s has passed its idle or absolute expiry.
+func (m *Manager) expired(s *Session, now time.Time) bool {
+	return now.Sub(s.LastSeen) > m.idleTimeout() || now.Sub(s.Created) > m.absoluteTimeout()
+}
+
+// expires returns when s will expire if it isn't used again.
+func (m *Manager) expires(s *Session) time.Time {
+	idle := s.LastSeen.Add(m.idleTimeout())
+	absolute := s.Created.Add(m.absoluteTimeout())
+	if idle.Before(absolute) {
+		return idle
+	}
+	return absolute
+}
+
+// Load returns the session for r, or a new session if r doesn't have a valid one.
+func (m *Manager) Load(ctx context.Context, r *http.Request) (*Session, error) {
+	now := m.now()
+
+	c, err := r.Cookie(m.cookieName())
+	if err != nil {
+		return newSession(now), nil
+	}
+
+	plain, err := m.decrypt(c.Value)
+	if err != nil {
+		// Cookies we can't read, i.e. ones encrypted with a retired key, just start a new session.
+		return newSession(now), nil
+	}
+
+	data := plain
+	if m.Store != nil {
+		data, err = m.Store.Load(ctx, storeID(string(plain)))
+		if err != nil {
+			return nil, fmt.Errorf("store: load: %w", err)
+		}
+
+		if data == nil {
+			return newSession(now), nil
+		}
+	}
+
+	s := &Session{}
+	if err := json.Unmarshal(data, s); err != nil {
+		return newSession(now), nil
+	}
+
+	if m.expired(s, now) {
+		if m.Store != nil {
+			if err := m.Store.Delete(ctx, storeID(s.ID)); err != nil {
+				return nil, fmt.Errorf("store: delete: %w", err)
+			}
+		}
+		return newSession(now), nil
+	}
+
+	return s, nil
+}
+
+// Save persists s and sets or clears the session cookie on w as needed. It must be called before the
+// response's headers are written.
+func (m *Manager) Save(ctx context.Context, w http.ResponseWriter, s *Session) error {
+	now := m.now()
+
+	if m.Store != nil {
+		for _, id := range s.oldIDs {
+			if err := m.Store.Delete(ctx, storeID(id)); err != nil {
+				return fmt.Errorf("store: delete: %w", err)
+			}
+		}
+	}
+
+	if s.destroyed {
+		if m.Store != nil && !s.isNew {
+			if err := m.Store.Delete(ctx, storeID(s.ID)); err != nil {
+				return fmt.Errorf("store: delete: %w", err)
+			}
+		}
+
+		if !s.isNew || len(s.oldIDs) > 0 {
+			c := m.Cookie
+			c.Name = m.cookieName()
+			c.Path = cookiePath(c.Path)
+			c.HttpOnly = true
+			c.MaxAge = -1
+			http.SetCookie(w, &c)
+		}
+		return nil
+	}
+
+	// Don't hand out sessions to clients that haven't stored anything.
+	if s.isNew && len(s.Values) == 0 {
+		return nil
+	}
+
+	if !s.dirty && now.Sub(s.LastSeen) < touchInterval {
+		return nil
+	}
+
+	s.LastSeen = now
+	expires := m.expires(s)
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("json: marshal: %w", err)
+	}
+
+	plain := data
+	if m.Store != nil {
+		if err := m.Store.Save(ctx, storeID(s.ID), data, expires); err != nil {
+			return fmt.Errorf("store: save: %w", err)
+		}
+		plain = []byte(s.ID)
+	}
+
+	value, err := m.encrypt(plain)
+	if err != nil {
+		return err
+	}
+
+	c := m.Cookie
+	c.Name = m.cookieName()
+	c.Value = value
+	c.Path = cookiePath(c.Path)
+	c.HttpOnly = true
+	c.Expires = expires
+
+	if len(c.String()) > maxCookieSize {
+		return fmt.Errorf("%w: %d bytes", ErrCookieTooLarge, len(c.String()))
+	}
+
+	http.SetCookie(w, &c)
+	return nil
+}
+
+func cookiePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return p
+}
+
+func (m *Manager) aead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("aes: new cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("cipher: new gcm: %w", err)
+	}
+
+	return gcm, nil
+}
+
+// encrypt seals plain with the first key, binding it to the cookie name.
+func (m *Manager) encrypt(plain []byte) (string, error) {
+	if len(m.Keys) == 0 {
+		return "", fmt.Errorf("sessions: no keys configured")
+	}
+
+	gcm, err := m.aead(m.Keys[0])
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("crypto/rand: read: %w", err)
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plain, []byte(m.cookieName()))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a cookie value with whichever key sealed it.
+func (m *Manager) decrypt(value string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+
+	for _, key := range m.Keys {
+		gcm, err := m.aead(key)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(sealed) < gcm.NonceSize() {
+			return nil, ErrInvalidCookie
+		}
+
+		nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+		if plain, err := gcm.Open(nil, nonce, ciphertext, []byte(m.cookieName())); err == nil {
+			return plain, nil
+		}
+	}
+
+	return nil, ErrInvalidCookie
+}
+
+// Middleware loads the request's session onto the context, then saves it once the handler starts
+// writing its response.
+func (m *Manager) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := zerolog.Ctx(ctx)
+
+		s, err := m.Load(ctx, r)
+		if err != nil {
+			log.Error().Err(err).Msg("sessions: couldn't load session")
+			s = newSession(m.now())
+		}
+
+		sw := &sessionWriter{Writer: httpwrap.Writer{ResponseWriter: w}, save: func() {
+			if err := m.Save(ctx, w, s); err != nil {
+				log.Error().Err(err).Msg("sessions: couldn't save session")
+			}
+		}}
+
+		next.ServeHTTP(sw, r.WithContext(Set(ctx, s)))
+
+		sw.commit()
+	})
+}
+
+// sessionWriter saves the session just before the response's headers are written, since that's the last
+// chance to set the cookie.
+type sessionWriter struct {
+	httpwrap.Writer
+
+	save      func()
+	committed bool
+}
+
+func (sw *sessionWriter) commit() {
+	if sw.committed {
+		return
+	}
+	sw.committed = true
+	sw.save()
+}
+
+func (sw *sessionWriter) WriteHeader(code int) {
+	sw.commit()
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *sessionWriter) Write(b []byte) (int, error) {
+	sw.commit()
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (sw *sessionWriter) Flush() {
+	httpwrap.Flush(sw.ResponseWriter, sw.commit)
+}
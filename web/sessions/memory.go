@@ -0,0 +1,74 @@
+package sessions
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how many saves a MemoryStore allows between sweeps for expired sessions.
+const sweepEvery = 1024
+
+type memoryEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+// MemoryStore is a Store that keeps sessions in memory. Sessions are lost on restart and aren't shared
+// between instances.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	saves   int
+}
+
+var _ Store = &MemoryStore{}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		entries: map[string]memoryEntry{},
+	}
+}
+
+// Load implements Store.
+func (m *MemoryStore) Load(_ context.Context, id string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[id]
+	if !ok || time.Now().After(e.expires) {
+		return nil, nil
+	}
+
+	return e.data, nil
+}
+
+// Save implements Store.
+func (m *MemoryStore) Save(_ context.Context, id string, data []byte, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[id] = memoryEntry{data: data, expires: expires}
+
+	m.saves++
+	if m.saves%sweepEvery == 0 {
+		now := time.Now()
+		for k, e := range m.entries {
+			if now.After(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Delete implements Store.
+func (m *MemoryStore) Delete(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, id)
+	return nil
+}
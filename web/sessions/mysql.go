@@ -0,0 +1,93 @@
+package sessions
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// MySQLStore is a Store that keeps sessions in a MySQL table with the following schema:
+//
+//	CREATE TABLE sessions (
+//		id CHAR(64) NOT NULL PRIMARY KEY,
+//		data BLOB NOT NULL,
+//		expires_at DATETIME(6) NOT NULL,
+//		INDEX (expires_at)
+//	);
+//
+// Expired rows are ignored on load; call DeleteExpired periodically to remove them.
+type MySQLStore struct {
+	DB *sqlx.DB
+
+	// Table is the name of the sessions table. Defaults to "sessions".
+	Table string
+}
+
+var _ Store = &MySQLStore{}
+
+// NewMySQLStore returns a MySQLStore using the "sessions" table.
+func NewMySQLStore(db *sqlx.DB) *MySQLStore {
+	return &MySQLStore{
+		DB: db,
+	}
+}
+
+func (m *MySQLStore) table() string {
+	if m.Table == "" {
+		return "sessions"
+	}
+	return m.Table
+}
+
+// Load implements Store.
+func (m *MySQLStore) Load(ctx context.Context, id string) ([]byte, error) {
+	var data []byte
+	err := m.DB.GetContext(ctx, &data, "SELECT `data` FROM `"+m.table()+"` WHERE `id` = ? AND `expires_at` > ?", id, time.Now().UTC())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sqlx: get: %w", err)
+	}
+
+	return data, nil
+}
+
+// Save implements Store.
+func (m *MySQLStore) Save(ctx context.Context, id string, data []byte, expires time.Time) error {
+	_, err := m.DB.ExecContext(ctx, "INSERT INTO `"+m.table()+"` (`id`, `data`, `expires_at`) VALUES (?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE `data` = VALUES(`data`), `expires_at` = VALUES(`expires_at`)", id, data, expires.UTC())
+	if err != nil {
+		return fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	return nil
+}
+
+// Delete implements Store.
+func (m *MySQLStore) Delete(ctx context.Context, id string) error {
+	if _, err := m.DB.ExecContext(ctx, "DELETE FROM `"+m.table()+"` WHERE `id` = ?", id); err != nil {
+		return fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpired removes expired sessions, returning how many were removed.
+func (m *MySQLStore) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := m.DB.ExecContext(ctx, "DELETE FROM `"+m.table()+"` WHERE `expires_at` <= ?", time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("sqlx: exec: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("sql: rows affected: %w", err)
+	}
+
+	return n, nil
+}
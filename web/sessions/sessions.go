@@ -0,0 +1,118 @@
+// Package sessions keeps per-client state between requests, either entirely within an encrypted cookie or
+// in a server-side Store referenced by an encrypted cookie.
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type ctxKey int
+
+const (
+	sessionKey ctxKey = 1 << iota
+)
+
+// Session is the state kept for a single client. Values are stored as JSON.
+type Session struct {
+	ID       string                     `json:"id"`
+	Values   map[string]json.RawMessage `json:"values,omitempty"`
+	Created  time.Time                  `json:"created"`
+	LastSeen time.Time                  `json:"lastSeen"`
+
+	isNew     bool
+	dirty     bool
+	destroyed bool
+	oldIDs    []string
+}
+
+func newSession(now time.Time) *Session {
+	return &Session{
+		ID:       newID(),
+		Values:   map[string]json.RawMessage{},
+		Created:  now,
+		LastSeen: now,
+		isNew:    true,
+	}
+}
+
+func newID() string {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Errorf("crypto/rand: read: %w", err))
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// IsNew reports whether the session was created during this request.
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+// Get unmarshals the value stored under key into v. It returns false if there's no value for key.
+func (s *Session) Get(key string, v any) (bool, error) {
+	raw, ok := s.Values[key]
+	if !ok {
+		return false, nil
+	}
+
+	if err := json.Unmarshal(raw, v); err != nil {
+		return true, fmt.Errorf("json: unmarshal (key: %s): %w", key, err)
+	}
+
+	return true, nil
+}
+
+// Set stores v under key.
+func (s *Session) Set(key string, v any) error {
+	by, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("json: marshal (key: %s): %w", key, err)
+	}
+
+	if s.Values == nil {
+		s.Values = map[string]json.RawMessage{}
+	}
+
+	s.Values[key] = by
+	s.dirty = true
+	return nil
+}
+
+// Delete removes the value stored under key.
+func (s *Session) Delete(key string) {
+	if _, ok := s.Values[key]; ok {
+		delete(s.Values, key)
+		s.dirty = true
+	}
+}
+
+// RenewID gives the session a new ID, keeping its values. Call it whenever the privilege level of the
+// session changes, i.e. on login, to prevent session fixation.
+func (s *Session) RenewID() {
+	s.oldIDs = append(s.oldIDs, s.ID)
+	s.ID = newID()
+	s.dirty = true
+}
+
+// Destroy removes the session and its values. The client's cookie is cleared at the end of the request.
+func (s *Session) Destroy() {
+	s.Values = map[string]json.RawMessage{}
+	s.destroyed = true
+	s.dirty = true
+}
+
+// Get returns the Session from the provided context.Context, or nil if Manager.Middleware hasn't run.
+func Get(ctx context.Context) *Session {
+	v, _ := ctx.Value(sessionKey).(*Session)
+	return v
+}
+
+// Set returns a copy of the provided context.Context with the provided Session as a value.
+func Set(parent context.Context, s *Session) context.Context {
+	return context.WithValue(parent, sessionKey, s)
+}
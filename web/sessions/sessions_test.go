@@ -0,0 +1,197 @@
+package sessions_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web/sessions"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+// clock is a manually advanced time source.
+type clock struct {
+	now atomic.Int64
+}
+
+func newClock() *clock {
+	c := &clock{}
+	c.now.Store(time.Now().UnixNano())
+	return c
+}
+
+func (c *clock) Now() time.Time {
+	return time.Unix(0, c.now.Load())
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.now.Add(int64(d))
+}
+
+// newServer serves a handler that manages a "user" value: POST /login?user=x stores it and renews the
+// session ID, POST /logout destroys the session and GET / returns it.
+func newServer(t *testing.T, m *sessions.Manager) (*httptest.Server, *http.Client) {
+	t.Helper()
+
+	h := m.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := sessions.Get(r.Context())
+		require.NotNil(t, s)
+
+		switch r.URL.Path {
+		case "/login":
+			s.RenewID()
+			require.NoError(t, s.Set("user", r.URL.Query().Get("user")))
+		case "/logout":
+			s.Destroy()
+		}
+
+		var user string
+		_, err := s.Get("user", &user)
+		require.NoError(t, err)
+
+		w.Write([]byte(user))
+	}))
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+
+	return srv, &http.Client{Jar: jar}
+}
+
+func get(t *testing.T, c *http.Client, url string) (string, *http.Response) {
+	t.Helper()
+
+	resp, err := c.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	var buf bytes.Buffer
+	buf.ReadFrom(resp.Body)
+	return buf.String(), resp
+}
+
+func TestManager(t *testing.T) {
+	tests := []struct {
+		name  string
+		store sessions.Store
+	}{
+		{"COOKIE", nil},
+		{"MEMORY", sessions.NewMemoryStore()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, c := newServer(t, &sessions.Manager{Keys: [][]byte{newKey}, Store: test.store})
+
+			body, resp := get(t, c, srv.URL+"/")
+			require.Empty(t, body)
+			require.Empty(t, resp.Header.Values("Set-Cookie"), "anonymous requests shouldn't get a session")
+
+			body, resp = get(t, c, srv.URL+"/login?user=alice")
+			require.Equal(t, "alice", body)
+			require.Len(t, resp.Cookies(), 1)
+			require.True(t, resp.Cookies()[0].HttpOnly)
+			first := resp.Cookies()[0].Value
+
+			body, _ = get(t, c, srv.URL+"/")
+			require.Equal(t, "alice", body)
+
+			body, resp = get(t, c, srv.URL+"/login?user=bob")
+			require.Equal(t, "bob", body)
+			require.NotEqual(t, first, resp.Cookies()[0].Value)
+
+			if test.store != nil {
+				// The session behind the old cookie is gone once the ID is renewed.
+				replay := &http.Client{}
+				req, err := http.NewRequest("GET", srv.URL+"/", nil)
+				require.NoError(t, err)
+				req.AddCookie(&http.Cookie{Name: "session", Value: first})
+
+				resp, err := replay.Do(req)
+				require.NoError(t, err)
+				var buf bytes.Buffer
+				buf.ReadFrom(resp.Body)
+				resp.Body.Close()
+				require.Empty(t, buf.String())
+			}
+
+			_, resp = get(t, c, srv.URL+"/logout")
+			require.Len(t, resp.Cookies(), 1)
+			require.Equal(t, -1, resp.Cookies()[0].MaxAge)
+
+			body, _ = get(t, c, srv.URL+"/")
+			require.Empty(t, body)
+		})
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	m := &sessions.Manager{Keys: [][]byte{oldKey}}
+	srv, c := newServer(t, m)
+
+	body, _ := get(t, c, srv.URL+"/login?user=alice")
+	require.Equal(t, "alice", body)
+
+	// Rotate in a new key, keeping the old one for decryption.
+	m.Keys = [][]byte{newKey, oldKey}
+
+	body, _ = get(t, c, srv.URL+"/")
+	require.Equal(t, "alice", body)
+
+	// Once the old key is retired, cookies sealed with it are ignored.
+	m.Keys = [][]byte{newKey}
+
+	body, _ = get(t, c, srv.URL+"/")
+	require.Empty(t, body)
+}
+
+func TestExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []time.Duration
+		present bool
+	}{
+		{"ACTIVE", []time.Duration{20 * time.Minute, 20 * time.Minute, 20 * time.Minute}, true},
+		{"IDLE", []time.Duration{31 * time.Minute}, false},
+		{"ABSOLUTE", []time.Duration{25 * time.Minute, 25 * time.Minute, 25 * time.Minute, 25 * time.Minute, 25 * time.Minute}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clk := newClock()
+			srv, c := newServer(t, &sessions.Manager{
+				Keys:            [][]byte{newKey},
+				Store:           sessions.NewMemoryStore(),
+				IdleTimeout:     30 * time.Minute,
+				AbsoluteTimeout: 2 * time.Hour,
+				Now:             clk.Now,
+			})
+
+			body, _ := get(t, c, srv.URL+"/login?user=alice")
+			require.Equal(t, "alice", body)
+
+			for _, d := range test.steps {
+				clk.Advance(d)
+				body, _ = get(t, c, srv.URL+"/")
+			}
+
+			if test.present {
+				require.Equal(t, "alice", body)
+			} else {
+				require.Empty(t, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package web
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// TLSConfig describes the files that are used to serve TLS.
+type TLSConfig struct {
+	CertFile string `envconfig:"TLS_CERT_FILE"`
+	KeyFile  string `envconfig:"TLS_KEY_FILE"`
+
+	// ClientCAFile is an optional PEM bundle of CAs used to verify client certificates. If set, clients are
+	// required to present a certificate signed by one of these CAs unless ClientAuth says otherwise.
+	ClientCAFile string `envconfig:"TLS_CLIENT_CA_FILE"`
+	ClientAuth   tls.ClientAuthType
+
+	// ReloadInterval is how often the files are checked for changes. Defaults to 30 seconds.
+	ReloadInterval time.Duration `envconfig:"TLS_RELOAD_INTERVAL" default:"30s"`
+
+	// ExpiryThreshold is how close to expiry the certificate can get before its health details warn that
+	// it's expiring soon. Defaults to 7 days.
+	ExpiryThreshold time.Duration `envconfig:"TLS_EXPIRY_THRESHOLD" default:"168h"`
+}
+
+// CertReloader serves a certificate and key pair from disk, picking up changes to the files without
+// restarting the server. Connections that are already established keep using the certificate they
+// negotiated with; new handshakes use the latest certificate.
+type CertReloader struct {
+	cfg TLSConfig
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTimes  map[string]time.Time
+	reloadErr error
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+var (
+	_ HealthChecker  = &CertReloader{}
+	_ HealthDetailer = &CertReloader{}
+	_ Shutdowner     = &CertReloader{}
+)
+
+// CertStatus describes a CertReloader's certificate. It's reported through HealthDetails.
+type CertStatus struct {
+	NotAfter time.Time `json:"notAfter"`
+
+	// Remaining is how much longer the certificate is valid for, rounded to the second.
+	Remaining string `json:"remaining"`
+
+	// ExpiringSoon is set once the certificate is within ExpiryThreshold of expiring.
+	ExpiringSoon bool `json:"expiringSoon"`
+
+	// ReloadError is why the files couldn't be reloaded the last time they changed, if they couldn't.
+	ReloadError string `json:"reloadError,omitempty"`
+}
+
+// NewCertReloader loads the files referenced in cfg and returns a CertReloader serving them.
+func NewCertReloader(cfg TLSConfig) (*CertReloader, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("tls: cert file and key file are required")
+	}
+
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = 30 * time.Second
+	}
+
+	if cfg.ExpiryThreshold <= 0 {
+		cfg.ExpiryThreshold = 7 * 24 * time.Hour
+	}
+
+	if cfg.ClientCAFile != "" && cfg.ClientAuth == tls.NoClientCert {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	c := &CertReloader{
+		cfg:  cfg,
+		stop: make(chan struct{}),
+	}
+
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Reload reads the certificate, key and client CA files from disk. If any of them can't be loaded, the
+// previously loaded certificate is kept and an error is returned, which HealthCheck reports until a reload
+// succeeds.
+func (c *CertReloader) Reload() error {
+	err := c.load()
+
+	c.mu.Lock()
+	c.reloadErr = err
+	c.mu.Unlock()
+
+	return err
+}
+
+func (c *CertReloader) load() error {
+	modTimes, err := c.statFiles()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.cfg.CertFile, c.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls: load x509 key pair: %w", err)
+	}
+
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("x509: parse certificate: %w", err)
+		}
+		cert.Leaf = leaf
+	}
+
+	var pool *x509.CertPool
+	if c.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("couldn't read client ca file (path: %s): %w", c.cfg.ClientCAFile, err)
+		}
+
+		pool = x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(pem); !ok {
+			return fmt.Errorf("can't decode pem file (file: %s)", c.cfg.ClientCAFile)
+		}
+	}
+
+	c.mu.Lock()
+	c.cert = &cert
+	c.clientCAs = pool
+	c.modTimes = modTimes
+	c.mu.Unlock()
+
+	return nil
+}
+
+func (c *CertReloader) files() []string {
+	files := []string{c.cfg.CertFile, c.cfg.KeyFile}
+	if c.cfg.ClientCAFile != "" {
+		files = append(files, c.cfg.ClientCAFile)
+	}
+	return files
+}
+
+func (c *CertReloader) statFiles() (map[string]time.Time, error) {
+	tbr := map[string]time.Time{}
+	for _, f := range c.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, fmt.Errorf("os: stat: %w", err)
+		}
+		tbr[f] = fi.ModTime()
+	}
+	return tbr, nil
+}
+
+// changed reports whether any of the files have been modified since they were last loaded.
+func (c *CertReloader) changed() bool {
+	modTimes, err := c.statFiles()
+	if err != nil {
+		// The files might be in the middle of being replaced; we'll try again on the next tick.
+		return false
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for f, t := range modTimes {
+		if !t.Equal(c.modTimes[f]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Watch polls the files every ReloadInterval and reloads them when they change, until Shutdown is called.
+// Reload failures are logged to log and retried on the next tick.
+func (c *CertReloader) Watch(log *zerolog.Logger) {
+	if log == nil {
+		nop := zerolog.Nop()
+		log = &nop
+	}
+
+	go func() {
+		t := time.NewTicker(c.cfg.ReloadInterval)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-t.C:
+			}
+
+			if !c.changed() {
+				continue
+			}
+
+			if err := c.Reload(); err != nil {
+				log.Error().Err(err).Msg("tls: couldn't reload certificate")
+				continue
+			}
+
+			log.Info().
+				Time("notAfter", c.NotAfter()).
+				Msg("tls: reloaded certificate")
+		}
+	}()
+}
+
+// GetCertificate returns the current certificate. It's suitable for use as tls.Config.GetCertificate.
+func (c *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert, nil
+}
+
+// Leaf returns the parsed leaf of the current certificate.
+func (c *CertReloader) Leaf() *x509.Certificate {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.cert.Leaf
+}
+
+// NotAfter returns the expiry of the current certificate.
+func (c *CertReloader) NotAfter() time.Time {
+	return c.Leaf().NotAfter
+}
+
+// TLSConfig returns a *tls.Config that negotiates each handshake with the current certificate and client CAs.
+func (c *CertReloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		ClientAuth: c.cfg.ClientAuth,
+	}
+
+	cfg := base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c.mu.RLock()
+		defer c.mu.RUnlock()
+
+		hcfg := base.Clone()
+		hcfg.Certificates = []tls.Certificate{*c.cert}
+		hcfg.ClientCAs = c.clientCAs
+		return hcfg, nil
+	}
+
+	return cfg
+}
+
+// Name implements HealthChecker.
+func (c *CertReloader) Name() string {
+	return "tls"
+}
+
+// HealthCheck implements HealthChecker. It fails once the current certificate has expired, or if the files
+// couldn't be reloaded after they changed. A certificate that's about to expire can still be served, so
+// that's only reported through HealthDetails; failing the check would take every instance out of service
+// before it had to be.
+func (c *CertReloader) HealthCheck(_ context.Context) error {
+	c.mu.RLock()
+	notAfter, reloadErr := c.cert.Leaf.NotAfter, c.reloadErr
+	c.mu.RUnlock()
+
+	if reloadErr != nil {
+		return fmt.Errorf("reload certificate: %w", reloadErr)
+	}
+
+	if time.Now().After(notAfter) {
+		return fmt.Errorf("certificate expired at %s", notAfter.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// HealthDetails implements HealthDetailer.
+func (c *CertReloader) HealthDetails(_ context.Context) any {
+	c.mu.RLock()
+	notAfter, reloadErr := c.cert.Leaf.NotAfter, c.reloadErr
+	c.mu.RUnlock()
+
+	left := time.Until(notAfter)
+	st := CertStatus{
+		NotAfter:     notAfter,
+		Remaining:    max(left, 0).Round(time.Second).String(),
+		ExpiringSoon: left < c.cfg.ExpiryThreshold,
+	}
+	if reloadErr != nil {
+		st.ReloadError = reloadErr.Error()
+	}
+
+	return st
+}
+
+// Shutdown implements Shutdowner. It stops watching the files for changes.
+func (c *CertReloader) Shutdown(_ context.Context) error {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	return nil
+}
+
+// TLSServer returns an *http.Server for the App that serves TLS using the files described by cfg. The
+// files are watched for changes, and the certificate's expiry is registered as a health check, so
+// TLSServer should be called before WithHealthCheckHandler. Start the server with ListenAndServeTLS("", "").
+func (a *App) TLSServer(addr string, cfg TLSConfig) (*http.Server, error) {
+	cr, err := NewCertReloader(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("new cert reloader: %w", err)
+	}
+
+	cr.Watch(a.logger)
+
+	a.WithHealthCheck(cr)
+	a.WithShutdown(cr)
+
+	return &http.Server{
+		Addr:      addr,
+		Handler:   a,
+		TLSConfig: cr.TLSConfig(),
+	}, nil
+}
@@ -0,0 +1,241 @@
+package web_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCert(t *testing.T, serial int64, cn string, notAfter time.Time, parent *testCert) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return testCert{cert: cert, key: key}
+}
+
+func (tc testCert) write(t *testing.T, certFile, keyFile string) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.cert.Raw}), 0o600))
+
+	if keyFile != "" {
+		der, err := x509.MarshalECPrivateKey(tc.key)
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600))
+	}
+}
+
+func (tc testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{
+		Certificate: [][]byte{tc.cert.Raw},
+		PrivateKey:  tc.key,
+		Leaf:        tc.cert,
+	}
+}
+
+func serveTLS(t *testing.T, srv *http.Server) string {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	go srv.ServeTLS(ln, "", "")
+	t.Cleanup(func() { srv.Close() })
+
+	return ln.Addr().String()
+}
+
+func peerSerial(t *testing.T, addr string, cfg *tls.Config) int64 {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, cfg)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestTLSServerReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	ca := newTestCert(t, 1, "ca", time.Now().Add(24*time.Hour), nil)
+	newTestCert(t, 2, "server", time.Now().Add(24*time.Hour), &ca).write(t, certFile, keyFile)
+
+	a := web.NewApp().Route(func(r router.Router) {
+		r.Getf("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("hello, world"))
+		})
+	})
+
+	srv, err := a.TLSServer("", web.TLSConfig{
+		CertFile:       certFile,
+		KeyFile:        keyFile,
+		ReloadInterval: 10 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	require.Len(t, a.HealthCheckers(), 1)
+	require.Len(t, a.Shutdowners(), 1)
+	t.Cleanup(func() { a.Shutdowners()[0].Shutdown(context.Background()) })
+
+	addr := serveTLS(t, srv)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	clientCfg := &tls.Config{RootCAs: pool}
+
+	// Hold a connection open across the reload to make sure it isn't dropped.
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientCfg}}
+	resp, err := client.Get("https://" + addr + "/")
+	require.NoError(t, err)
+	require.Equal(t, "hello, world", getBody(resp.Body))
+
+	require.EqualValues(t, 2, peerSerial(t, addr, clientCfg))
+
+	newTestCert(t, 3, "server", time.Now().Add(48*time.Hour), &ca).write(t, certFile, keyFile)
+
+	require.Eventually(t, func() bool {
+		return peerSerial(t, addr, clientCfg) == 3
+	}, 2*time.Second, 10*time.Millisecond)
+
+	resp, err = client.Get("https://" + addr + "/")
+	require.NoError(t, err)
+	require.Equal(t, "hello, world", getBody(resp.Body))
+}
+
+func TestTLSServerClientCA(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), filepath.Join(dir, "ca.crt")
+
+	ca := newTestCert(t, 1, "ca", time.Now().Add(24*time.Hour), nil)
+	ca.write(t, caFile, "")
+	newTestCert(t, 2, "server", time.Now().Add(24*time.Hour), &ca).write(t, certFile, keyFile)
+	clientCert := newTestCert(t, 3, "client", time.Now().Add(24*time.Hour), &ca)
+
+	a := web.NewApp().Route(func(r router.Router) {
+		r.Getf("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+		})
+	})
+
+	srv, err := a.TLSServer("", web.TLSConfig{
+		CertFile:     certFile,
+		KeyFile:      keyFile,
+		ClientCAFile: caFile,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { a.Shutdowners()[0].Shutdown(context.Background()) })
+
+	addr := serveTLS(t, srv)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	{
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      pool,
+			Certificates: []tls.Certificate{clientCert.tlsCertificate()},
+		}}}
+
+		resp, err := client.Get("https://" + addr + "/")
+		require.NoError(t, err)
+		require.Equal(t, "client", getBody(resp.Body))
+	}
+
+	{
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs: pool,
+		}}}
+
+		_, err := client.Get("https://" + addr + "/")
+		require.Error(t, err)
+	}
+}
+
+func TestCertReloaderHealthCheck(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+
+	ca := newTestCert(t, 1, "ca", time.Now().Add(24*time.Hour), nil)
+	newTestCert(t, 2, "server", time.Now().Add(24*time.Hour), &ca).write(t, certFile, keyFile)
+
+	{
+		cr, err := web.NewCertReloader(web.TLSConfig{CertFile: certFile, KeyFile: keyFile, ExpiryThreshold: time.Hour})
+		require.NoError(t, err)
+		require.NoError(t, cr.HealthCheck(context.Background()))
+		require.False(t, cr.HealthDetails(context.Background()).(web.CertStatus).ExpiringSoon)
+	}
+
+	{
+		// Expiring soon is a warning, not a failure.
+		cr, err := web.NewCertReloader(web.TLSConfig{CertFile: certFile, KeyFile: keyFile, ExpiryThreshold: 48 * time.Hour})
+		require.NoError(t, err)
+		require.NoError(t, cr.HealthCheck(context.Background()))
+
+		st := cr.HealthDetails(context.Background()).(web.CertStatus)
+		require.True(t, st.ExpiringSoon)
+		require.Empty(t, st.ReloadError)
+
+		require.NoError(t, os.WriteFile(certFile, []byte("not a certificate"), 0o600))
+		require.Error(t, cr.Reload())
+		require.Error(t, cr.HealthCheck(context.Background()))
+		require.NotEmpty(t, cr.HealthDetails(context.Background()).(web.CertStatus).ReloadError)
+	}
+
+	{
+		newTestCert(t, 3, "server", time.Now().Add(-time.Minute), &ca).write(t, certFile, keyFile)
+
+		cr, err := web.NewCertReloader(web.TLSConfig{CertFile: certFile, KeyFile: keyFile})
+		require.NoError(t, err)
+		require.Error(t, cr.HealthCheck(context.Background()))
+		require.Equal(t, "0s", cr.HealthDetails(context.Background()).(web.CertStatus).Remaining)
+	}
+}
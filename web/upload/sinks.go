@@ -0,0 +1,189 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// FileSink is a Sink that writes objects to files under Dir.
+type FileSink struct {
+	Dir string
+}
+
+var _ Sink = FileSink{}
+
+func (f FileSink) path(key string) (string, error) {
+	p := filepath.Join(f.Dir, filepath.FromSlash(key))
+	if rel, err := filepath.Rel(f.Dir, p); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("key %q is outside %s", key, f.Dir)
+	}
+	return p, nil
+}
+
+// Put implements Sink. Objects are written to a temporary file and renamed into place once complete.
+func (f FileSink) Put(_ context.Context, key, _ string, r io.Reader) (string, error) {
+	p, err := f.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", fmt.Errorf("os: mkdir all: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("os: create temp: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("io: copy: %w", err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("file: close: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return "", fmt.Errorf("os: rename: %w", err)
+	}
+
+	return p, nil
+}
+
+// Delete implements Sink.
+func (f FileSink) Delete(_ context.Context, key string) error {
+	p, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("os: remove: %w", err)
+	}
+	return nil
+}
+
+// MemoryObject is an object held by a MemorySink.
+type MemoryObject struct {
+	ContentType string
+	Data        []byte
+}
+
+// MemorySink is a Sink that keeps objects in memory, i.e. for tests or for processing small files
+// before storing them elsewhere.
+type MemorySink struct {
+	mu      sync.Mutex
+	objects map[string]MemoryObject
+}
+
+var _ Sink = &MemorySink{}
+
+// NewMemorySink returns an empty MemorySink.
+func NewMemorySink() *MemorySink {
+	return &MemorySink{
+		objects: map[string]MemoryObject{},
+	}
+}
+
+// Put implements Sink.
+func (m *MemorySink) Put(_ context.Context, key, contentType string, r io.Reader) (string, error) {
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		return "", fmt.Errorf("io: copy: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.objects[key] = MemoryObject{ContentType: contentType, Data: buf.Bytes()}
+	return "memory:" + key, nil
+}
+
+// Delete implements Sink.
+func (m *MemorySink) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.objects, key)
+	return nil
+}
+
+// Get returns the object stored under key.
+func (m *MemorySink) Get(key string) (MemoryObject, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	obj, ok := m.objects[key]
+	return obj, ok
+}
+
+// Len returns the number of objects stored.
+func (m *MemorySink) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.objects)
+}
+
+// S3Sink is a Sink that streams objects to an S3-compatible bucket with s3manager, which uploads large
+// objects in parts.
+type S3Sink struct {
+	Uploader *s3manager.Uploader
+	Bucket   string
+
+	// Prefix is prepended to every key, i.e. "uploads/".
+	Prefix string
+}
+
+var _ Sink = &S3Sink{}
+
+// NewS3Sink returns an S3Sink for bucket using the session or config provider p. For S3-compatible
+// services, configure p with the service's endpoint and path-style addressing.
+func NewS3Sink(p client.ConfigProvider, bucket string) *S3Sink {
+	return &S3Sink{
+		Uploader: s3manager.NewUploader(p),
+		Bucket:   bucket,
+	}
+}
+
+// Put implements Sink.
+func (s *S3Sink) Put(ctx context.Context, key, contentType string, r io.Reader) (string, error) {
+	out, err := s.Uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(s.Prefix + key),
+		Body:        r,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", fmt.Errorf("s3manager: upload: %w", err)
+	}
+
+	return out.Location, nil
+}
+
+// Delete implements Sink.
+func (s *S3Sink) Delete(ctx context.Context, key string) error {
+	_, err := s.Uploader.S3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.Prefix + key),
+	})
+	if err != nil {
+		return fmt.Errorf("s3: delete object: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,315 @@
+// Package upload streams multipart/form-data uploads to a Sink without buffering them in memory or on
+// disk. Each file part's content type is sniffed from its first bytes and checked, along with its size,
+// against the rules for its field.
+package upload
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// sniffLength is how many bytes http.DetectContentType looks at.
+const sniffLength = 512
+
+// Sink stores uploaded objects.
+type Sink interface {
+	// Put stores the contents of r under key, returning where the object can be found.
+	Put(ctx context.Context, key, contentType string, r io.Reader) (string, error)
+
+	// Delete removes the object stored under key. It's used to clean up after a failed upload.
+	Delete(ctx context.Context, key string) error
+}
+
+// Object describes a stored file.
+type Object struct {
+	// Field is the form field the file was uploaded in.
+	Field string `json:"field"`
+
+	// Filename is the name the client gave the file, without any directories.
+	Filename string `json:"filename"`
+
+	// ContentType is the type sniffed from the file's contents.
+	ContentType string `json:"contentType"`
+
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+
+	// Key is the name the object was stored under, and Location is where the Sink put it.
+	Key      string `json:"key"`
+	Location string `json:"location"`
+}
+
+// Result holds the outcome of a successful upload.
+type Result struct {
+	Objects []Object
+
+	// Values holds the non-file form fields.
+	Values url.Values
+}
+
+// Rule limits the files uploaded in a field.
+type Rule struct {
+	// MaxBytes is the largest file accepted. If zero, file size isn't limited here, so make sure the
+	// request body is limited some other way, i.e. with middleware.BodyLimit.
+	MaxBytes int64
+
+	// AllowedTypes lists the content types accepted, as sniffed by http.DetectContentType. A trailing "/"
+	// accepts a whole family, i.e. "image/". If empty, any type is accepted.
+	AllowedTypes []string
+
+	// MaxFiles is the most files accepted in the field. Defaults to 1.
+	MaxFiles int
+}
+
+func (r Rule) allows(contentType string) bool {
+	if len(r.AllowedTypes) == 0 {
+		return true
+	}
+
+	mt, _, _ := mime.ParseMediaType(contentType)
+	for _, t := range r.AllowedTypes {
+		if mt == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mt, t)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Options configures Handle.
+type Options struct {
+	Sink Sink
+
+	// Fields maps the form fields files may be uploaded in to their Rules. Files in any other field are
+	// rejected.
+	Fields map[string]Rule
+
+	// MaxValueBytes is the most bytes accepted across all non-file fields. Defaults to 1 MiB.
+	MaxValueBytes int64
+
+	// Key returns the key a file is stored under. Defaults to a random name keeping the file's extension.
+	Key func(field, filename string) string
+}
+
+func (o Options) maxValueBytes() int64 {
+	if o.MaxValueBytes <= 0 {
+		return 1 << 20
+	}
+	return o.MaxValueBytes
+}
+
+func defaultKey(_, filename string) string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Errorf("crypto/rand: read: %w", err))
+	}
+	return hex.EncodeToString(buf) + strings.ToLower(path.Ext(filename))
+}
+
+// Error is returned for uploads that are rejected. Status and Code are suitable for respond.CodedError.
+type Error struct {
+	Field  string
+	Status int
+	Code   string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s (field: %s)", e.Err, e.Field)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// ErrTooLarge is returned when a file or the form values are larger than allowed.
+	ErrTooLarge = errors.New("upload: too large")
+
+	// ErrTypeNotAllowed is returned when a file's sniffed type isn't allowed.
+	ErrTypeNotAllowed = errors.New("upload: content type not allowed")
+
+	// ErrFieldNotAllowed is returned when a file is uploaded in a field without a Rule.
+	ErrFieldNotAllowed = errors.New("upload: field not allowed")
+
+	// ErrTooManyFiles is returned when a field has more files than its Rule allows.
+	ErrTooManyFiles = errors.New("upload: too many files")
+)
+
+// Handle reads the multipart body of r, streaming each file part to opts.Sink. If anything is rejected
+// or fails, objects already stored by this request are deleted and an error is returned; rejections are
+// *Errors.
+func Handle(r *http.Request, opts Options) (*Result, error) {
+	ctx := r.Context()
+
+	if opts.Key == nil {
+		opts.Key = defaultKey
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, &Error{Status: http.StatusBadRequest, Code: "INVALID_MULTIPART", Err: fmt.Errorf("multipart reader: %w", err)}
+	}
+
+	res := &Result{Values: url.Values{}}
+	counts := map[string]int{}
+	valueBytes := opts.maxValueBytes()
+
+	fail := func(err error) (*Result, error) {
+		cleanup(ctx, opts.Sink, res.Objects)
+		return nil, err
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fail(&Error{Status: http.StatusBadRequest, Code: "INVALID_MULTIPART", Err: fmt.Errorf("multipart: next part: %w", err)})
+		}
+
+		field := part.FormName()
+		if field == "" {
+			part.Close()
+			continue
+		}
+
+		if part.FileName() == "" {
+			by, err := io.ReadAll(io.LimitReader(part, valueBytes+1))
+			part.Close()
+			if err != nil {
+				return fail(fmt.Errorf("read value (field: %s): %w", field, err))
+			}
+
+			valueBytes -= int64(len(by))
+			if valueBytes < 0 {
+				return fail(&Error{Field: field, Status: http.StatusRequestEntityTooLarge, Code: "UPLOAD_TOO_LARGE", Err: ErrTooLarge})
+			}
+
+			res.Values.Add(field, string(by))
+			continue
+		}
+
+		rule, ok := opts.Fields[field]
+		if !ok {
+			part.Close()
+			return fail(&Error{Field: field, Status: http.StatusBadRequest, Code: "UPLOAD_FIELD_NOT_ALLOWED", Err: ErrFieldNotAllowed})
+		}
+
+		counts[field]++
+		if max := max(rule.MaxFiles, 1); counts[field] > max {
+			part.Close()
+			return fail(&Error{Field: field, Status: http.StatusBadRequest, Code: "UPLOAD_TOO_MANY_FILES", Err: ErrTooManyFiles})
+		}
+
+		obj, err := store(ctx, opts, rule, part)
+		part.Close()
+		if err != nil {
+			return fail(err)
+		}
+
+		res.Objects = append(res.Objects, obj)
+	}
+
+	return res, nil
+}
+
+// store sniffs and streams a single file part to the sink.
+func store(ctx context.Context, opts Options, rule Rule, part *multipart.Part) (Object, error) {
+	field := part.FormName()
+
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(part, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return Object{}, fmt.Errorf("read part (field: %s): %w", field, err)
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	if !rule.allows(contentType) {
+		return Object{}, &Error{Field: field, Status: http.StatusUnsupportedMediaType, Code: "UPLOAD_TYPE_NOT_ALLOWED", Err: fmt.Errorf("%w: %s", ErrTypeNotAllowed, contentType)}
+	}
+
+	obj := Object{
+		Field:       field,
+		Filename:    path.Base(strings.ReplaceAll(part.FileName(), `\`, "/")),
+		ContentType: contentType,
+	}
+	obj.Key = opts.Key(field, obj.Filename)
+
+	body := &countingReader{
+		r:     io.MultiReader(bytes.NewReader(head), part),
+		limit: rule.MaxBytes,
+		hash:  sha256.New(),
+	}
+
+	loc, err := opts.Sink.Put(ctx, obj.Key, contentType, body)
+	if body.exceeded {
+		// Sinks may or may not have kept what they received before the limit was hit.
+		opts.Sink.Delete(context.WithoutCancel(ctx), obj.Key)
+		return Object{}, &Error{Field: field, Status: http.StatusRequestEntityTooLarge, Code: "UPLOAD_TOO_LARGE", Err: fmt.Errorf("%w: files must be at most %d bytes", ErrTooLarge, rule.MaxBytes)}
+	}
+	if err != nil {
+		opts.Sink.Delete(context.WithoutCancel(ctx), obj.Key)
+		return Object{}, fmt.Errorf("sink: put (key: %s): %w", obj.Key, err)
+	}
+
+	obj.Location = loc
+	obj.Size = body.n
+	obj.SHA256 = hex.EncodeToString(body.hash.Sum(nil))
+
+	return obj, nil
+}
+
+func cleanup(ctx context.Context, sink Sink, objects []Object) {
+	ctx = context.WithoutCancel(ctx)
+	for _, obj := range objects {
+		if err := sink.Delete(ctx, obj.Key); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("key", obj.Key).Msg("upload: couldn't clean up object")
+		}
+	}
+}
+
+// countingReader counts and hashes what's read through it, failing once more than limit bytes are read.
+type countingReader struct {
+	r        io.Reader
+	limit    int64
+	n        int64
+	hash     hash.Hash
+	exceeded bool
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if c.exceeded {
+		return 0, ErrTooLarge
+	}
+
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.hash.Write(p[:n])
+
+	if c.limit > 0 && c.n > c.limit {
+		c.exceeded = true
+		return n, ErrTooLarge
+	}
+
+	return n, err
+}
@@ -0,0 +1,224 @@
+package upload_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/jimmysawczuk/kit/web/upload"
+	"github.com/stretchr/testify/require"
+)
+
+var png = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 100)...)
+
+type part struct {
+	field, filename string
+	data            []byte
+}
+
+func newRequest(t *testing.T, parts ...part) *http.Request {
+	t.Helper()
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		var w io.Writer
+		var err error
+		if p.filename != "" {
+			w, err = mw.CreateFormFile(p.field, p.filename)
+		} else {
+			w, err = mw.CreateFormField(p.field)
+		}
+		require.NoError(t, err)
+		w.Write(p.data)
+	}
+	require.NoError(t, mw.Close())
+
+	r := httptest.NewRequest("POST", "/upload", &buf)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+var rules = map[string]upload.Rule{
+	"avatar":      {MaxBytes: 1024, AllowedTypes: []string{"image/"}},
+	"attachments": {MaxBytes: 64, MaxFiles: 2},
+}
+
+func TestHandle(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []part
+		code  string
+	}{
+		{
+			name:  "OK",
+			parts: []part{{"title", "", []byte("hello")}, {"avatar", `C:\photos\me.PNG`, png}, {"attachments", "a.txt", []byte("plain text")}},
+		},
+		{
+			name:  "TYPE_NOT_ALLOWED",
+			parts: []part{{"attachments", "a.txt", []byte("plain text")}, {"avatar", "me.png", []byte("not really a png")}},
+			code:  "UPLOAD_TYPE_NOT_ALLOWED",
+		},
+		{
+			name:  "TOO_LARGE",
+			parts: []part{{"attachments", "a.txt", bytes.Repeat([]byte("a"), 65)}},
+			code:  "UPLOAD_TOO_LARGE",
+		},
+		{
+			name:  "TOO_MANY_FILES",
+			parts: []part{{"attachments", "a.txt", []byte("a")}, {"attachments", "b.txt", []byte("b")}, {"attachments", "c.txt", []byte("c")}},
+			code:  "UPLOAD_TOO_MANY_FILES",
+		},
+		{
+			name:  "FIELD_NOT_ALLOWED",
+			parts: []part{{"other", "a.txt", []byte("a")}},
+			code:  "UPLOAD_FIELD_NOT_ALLOWED",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink := upload.NewMemorySink()
+
+			res, err := upload.Handle(newRequest(t, test.parts...), upload.Options{Sink: sink, Fields: rules})
+			if test.code != "" {
+				var uerr *upload.Error
+				require.True(t, errors.As(err, &uerr), "%v", err)
+				require.Equal(t, test.code, uerr.Code)
+
+				// Objects stored before the failure are cleaned up.
+				require.Zero(t, sink.Len())
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "hello", res.Values.Get("title"))
+			require.Len(t, res.Objects, 2)
+
+			avatar := res.Objects[0]
+			require.Equal(t, "avatar", avatar.Field)
+			require.Equal(t, "me.PNG", avatar.Filename)
+			require.Equal(t, "image/png", avatar.ContentType)
+			require.EqualValues(t, len(png), avatar.Size)
+			require.True(t, strings.HasSuffix(avatar.Key, ".png"))
+			sum := sha256.Sum256(png)
+			require.Equal(t, hex.EncodeToString(sum[:]), avatar.SHA256)
+
+			stored, ok := sink.Get(avatar.Key)
+			require.True(t, ok)
+			require.Equal(t, png, stored.Data)
+
+			require.Equal(t, "text/plain; charset=utf-8", res.Objects[1].ContentType)
+		})
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir := t.TempDir()
+	sink := upload.FileSink{Dir: dir}
+
+	res, err := upload.Handle(newRequest(t, part{"avatar", "me.png", png}), upload.Options{
+		Sink:   sink,
+		Fields: rules,
+		Key:    func(field, filename string) string { return "users/1/" + filename },
+	})
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(dir, "users", "1", "me.png"), res.Objects[0].Location)
+
+	by, err := os.ReadFile(res.Objects[0].Location)
+	require.NoError(t, err)
+	require.Equal(t, png, by)
+
+	_, err = upload.Handle(newRequest(t, part{"avatar", "me.png", png}), upload.Options{
+		Sink:   sink,
+		Fields: rules,
+		Key:    func(field, filename string) string { return "../escape.png" },
+	})
+	require.ErrorContains(t, err, "outside")
+}
+
+// s3Stub is a minimal stand-in for an S3-compatible service, handling path-style PutObject and
+// DeleteObject.
+type s3Stub struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	types   map[string]string
+}
+
+func newS3Stub(t *testing.T) *s3Stub {
+	s := &s3Stub{objects: map[string][]byte{}, types: map[string]string{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		switch r.Method {
+		case http.MethodPut:
+			by, err := io.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			s.objects[r.URL.Path] = by
+			s.types[r.URL.Path] = r.Header.Get("Content-Type")
+
+			sum := sha256.Sum256(by)
+			w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		case http.MethodDelete:
+			delete(s.objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestS3Sink(t *testing.T) {
+	stub := newS3Stub(t)
+
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:         aws.String(stub.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("id", "secret", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})
+	require.NoError(t, err)
+
+	sink := upload.NewS3Sink(sess, "bucket")
+	sink.Prefix = "uploads/"
+
+	res, err := upload.Handle(newRequest(t, part{"avatar", "me.png", png}), upload.Options{Sink: sink, Fields: rules})
+	require.NoError(t, err)
+
+	obj := res.Objects[0]
+	require.Equal(t, stub.URL+"/bucket/uploads/"+obj.Key, obj.Location)
+
+	stub.mu.Lock()
+	require.Equal(t, png, stub.objects["/bucket/uploads/"+obj.Key])
+	require.Equal(t, "image/png", stub.types["/bucket/uploads/"+obj.Key])
+	stub.mu.Unlock()
+
+	// A failure later in the request removes what was already uploaded.
+	_, err = upload.Handle(newRequest(t, part{"avatar", "me.png", png}, part{"other", "x.txt", []byte("x")}), upload.Options{Sink: sink, Fields: rules})
+	require.ErrorIs(t, err, upload.ErrFieldNotAllowed)
+
+	stub.mu.Lock()
+	require.Len(t, stub.objects, 1)
+	stub.mu.Unlock()
+}
@@ -0,0 +1,421 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type message struct {
+	typ  MessageType
+	data []byte
+}
+
+// control is a control frame waiting to be written.
+type control struct {
+	op      opcode
+	payload []byte
+
+	// For close frames, status and reason are what was sent, and wait is whether to wait for the peer's
+	// close frame before dropping the connection.
+	status StatusCode
+	reason string
+	wait   bool
+}
+
+// Conn is a WebSocket connection. Messages are read with ReadMessage and sent with Send or TrySend, which
+// queue them in the connection's send buffer; a single goroutine writes them, along with pings and other
+// control frames. Conn is safe for concurrent use.
+//
+// Frames are read in the background, so pings and close frames are answered even while the handler is
+// busy. A data message waits there until ReadMessage is called, holding up the frames behind it, so
+// handlers that only send should still call ReadMessage if their clients might send anything.
+type Conn struct {
+	opts        Options
+	conn        net.Conn
+	br          *bufio.Reader
+	bw          *bufio.Writer
+	subprotocol string
+	cancel      context.CancelFunc
+
+	send chan message
+	ctrl chan control
+	recv chan message
+
+	mu            sync.Mutex
+	closing       bool
+	closeSent     bool
+	closeReceived bool
+	peerClose     *CloseError
+
+	failOnce sync.Once
+	err      error
+	done     chan struct{}
+
+	writerDone  chan struct{}
+	handlerDone chan struct{}
+}
+
+func newConn(cancel context.CancelFunc, conn net.Conn, brw *bufio.ReadWriter, subprotocol string, opts Options) *Conn {
+	c := &Conn{
+		opts:        opts,
+		conn:        conn,
+		br:          brw.Reader,
+		bw:          brw.Writer,
+		subprotocol: subprotocol,
+		cancel:      cancel,
+		send:        make(chan message, opts.SendBuffer),
+		ctrl:        make(chan control, 8),
+		recv:        make(chan message),
+		done:        make(chan struct{}),
+		writerDone:  make(chan struct{}),
+		handlerDone: make(chan struct{}),
+	}
+
+	go c.readLoop()
+	go c.writeLoop()
+
+	return c
+}
+
+// Subprotocol returns the subprotocol chosen during the handshake, if any.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the address of the client, or the proxy it connected through.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Buffered returns how many messages are waiting in the send buffer.
+func (c *Conn) Buffered() int {
+	return len(c.send)
+}
+
+// ReadMessage returns the next message from the client. Once the connection closes it returns the reason:
+// a *CloseError if it closed with a close frame, from either side, or another error if it was dropped.
+func (c *Conn) ReadMessage(ctx context.Context) (MessageType, []byte, error) {
+	select {
+	case m := <-c.recv:
+		return m.typ, m.data, nil
+	case <-c.done:
+		return 0, nil, c.err
+	case <-ctx.Done():
+		// The handler's context is cancelled when the connection drops; report why it dropped.
+		select {
+		case <-c.done:
+			return 0, nil, c.err
+		default:
+			return 0, nil, ctx.Err()
+		}
+	}
+}
+
+// Send queues a message, waiting for room in the send buffer until ctx is done. It returns ErrClosed if
+// the connection is closed or closing. A nil error means the message was queued, not that it was sent.
+func (c *Conn) Send(ctx context.Context, typ MessageType, data []byte) error {
+	if err := c.sendable(typ); err != nil {
+		return err
+	}
+
+	select {
+	case c.send <- message{typ: typ, data: data}:
+		return nil
+	case <-c.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// TrySend queues a message if there's room in the send buffer, returning ErrBufferFull if there isn't, so
+// callers fanning out to many connections can drop messages or connections that fall behind.
+func (c *Conn) TrySend(typ MessageType, data []byte) error {
+	if err := c.sendable(typ); err != nil {
+		return err
+	}
+
+	select {
+	case c.send <- message{typ: typ, data: data}:
+		return nil
+	case <-c.done:
+		return ErrClosed
+	default:
+		return ErrBufferFull
+	}
+}
+
+func (c *Conn) sendable(typ MessageType) error {
+	if typ != Text && typ != Binary {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closing {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close starts the closing handshake: messages already in the send buffer are written, then a close frame
+// with status and reason. The connection is dropped once the client answers, or after CloseTimeout.
+// ReadMessage returns a *CloseError once it's closed. Closing a connection that's already closing does
+// nothing.
+func (c *Conn) Close(status StatusCode, reason string) error {
+	if !status.valid() {
+		return fmt.Errorf("websocket: invalid close status %d", status)
+	}
+
+	c.closeWith(status, reason, true)
+	return nil
+}
+
+// closeWith queues a close frame, unless one already has been, and reports whether it did.
+func (c *Conn) closeWith(status StatusCode, reason string, wait bool) bool {
+	c.mu.Lock()
+	if c.closing {
+		c.mu.Unlock()
+		return false
+	}
+	c.closing = true
+	c.mu.Unlock()
+
+	select {
+	case c.ctrl <- control{op: opClose, payload: closePayload(status, reason), status: status, reason: reason, wait: wait}:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// fail drops the connection, recording why.
+func (c *Conn) fail(err error) {
+	c.failOnce.Do(func() {
+		c.err = err
+		close(c.done)
+		c.conn.Close()
+		c.cancel()
+	})
+}
+
+func (c *Conn) readLoop() {
+	for {
+		typ, data, err := c.readMessage()
+
+		var ce *CloseError
+		var pe *protocolError
+		switch {
+		case errors.As(err, &ce):
+			c.mu.Lock()
+			c.closeReceived = true
+			c.peerClose = ce
+			sent := c.closeSent
+			c.mu.Unlock()
+
+			if sent {
+				c.fail(ce)
+				return
+			}
+
+			// Echo the status; the writer drops the connection once it's sent.
+			echo := ce.Status
+			if echo == StatusNoStatus || !echo.valid() {
+				echo = StatusNormalClosure
+			}
+			c.closeWith(echo, "", false)
+			return
+		case errors.As(err, &pe):
+			if !c.closeWith(pe.status, pe.reason, false) {
+				c.fail(&CloseError{Status: pe.status, Reason: pe.reason})
+			}
+			return
+		case err != nil:
+			c.fail(fmt.Errorf("websocket: read: %w", err))
+			return
+		}
+
+		select {
+		case c.recv <- message{typ: typ, data: data}:
+		case <-c.handlerDone:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// readMessage reads frames until it has a whole data message, handling control frames along the way. A
+// close frame is returned as a *CloseError.
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var (
+		typ        opcode
+		buf        []byte
+		fragmented bool
+	)
+
+	for {
+		if c.opts.PingInterval > 0 {
+			// Any frame shows the client is still there.
+			c.conn.SetReadDeadline(time.Now().Add(c.opts.PingInterval + c.opts.PongTimeout))
+		}
+
+		h, err := readFrameHeader(c.br)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if h.op.control() {
+			payload, err := readPayload(c.br, h, nil)
+			if err != nil {
+				return 0, nil, err
+			}
+
+			switch h.op {
+			case opPing:
+				// If pongs are already queued, the client will get one of those.
+				select {
+				case c.ctrl <- control{op: opPong, payload: payload}:
+				default:
+				}
+			case opClose:
+				status, reason, err := parseClosePayload(payload)
+				if err != nil {
+					return 0, nil, err
+				}
+				return 0, nil, &CloseError{Status: status, Reason: reason}
+			}
+			continue
+		}
+
+		switch {
+		case h.op == opContinuation && !fragmented:
+			return 0, nil, protocolErr(StatusProtocolError, "unexpected continuation frame")
+		case h.op != opContinuation && fragmented:
+			return 0, nil, protocolErr(StatusProtocolError, "expected continuation frame")
+		case h.op != opContinuation:
+			typ = h.op
+		}
+
+		if int64(len(buf))+h.length > c.opts.ReadLimit {
+			return 0, nil, protocolErr(StatusMessageTooBig, "message larger than %d bytes", c.opts.ReadLimit)
+		}
+
+		buf, err = readPayload(c.br, h, buf)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if !h.fin {
+			fragmented = true
+			continue
+		}
+
+		if typ == opText && !utf8.Valid(buf) {
+			return 0, nil, protocolErr(StatusInvalidPayload, "text message isn't valid UTF-8")
+		}
+
+		if buf == nil {
+			buf = []byte{}
+		}
+		return MessageType(typ), buf, nil
+	}
+}
+
+func (c *Conn) writeLoop() {
+	defer close(c.writerDone)
+
+	var ping <-chan time.Time
+	if c.opts.PingInterval > 0 {
+		t := time.NewTicker(c.opts.PingInterval)
+		defer t.Stop()
+		ping = t.C
+	}
+
+	for {
+		// Control frames jump the queue.
+		select {
+		case f := <-c.ctrl:
+			if !c.writeControl(f) {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case f := <-c.ctrl:
+			if !c.writeControl(f) {
+				return
+			}
+		case m := <-c.send:
+			if !c.write(opcode(m.typ), m.data) {
+				return
+			}
+		case <-ping:
+			if !c.write(opPing, nil) {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// write writes a frame, dropping the connection if it fails. It reports whether the write succeeded.
+func (c *Conn) write(op opcode, payload []byte) bool {
+	c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout))
+	if err := writeFrame(c.bw, op, payload); err != nil {
+		c.fail(fmt.Errorf("websocket: write: %w", err))
+		return false
+	}
+	return true
+}
+
+// writeControl writes a control frame. It reports whether the writer should carry on, which it shouldn't
+// once a close frame has been sent.
+func (c *Conn) writeControl(f control) bool {
+	if f.op != opClose {
+		return c.write(f.op, f.payload)
+	}
+
+	// Flush what's already been queued before closing.
+	for flushed := false; !flushed; {
+		select {
+		case m := <-c.send:
+			if !c.write(opcode(m.typ), m.data) {
+				return false
+			}
+		default:
+			flushed = true
+		}
+	}
+
+	if !c.write(opClose, f.payload) {
+		return false
+	}
+
+	c.mu.Lock()
+	c.closeSent = true
+	received, peer := c.closeReceived, c.peerClose
+	c.mu.Unlock()
+
+	switch {
+	case received:
+		c.fail(peer)
+	case !f.wait:
+		c.fail(&CloseError{Status: f.status, Reason: f.reason})
+	default:
+		time.AfterFunc(c.opts.CloseTimeout, func() {
+			c.fail(fmt.Errorf("%w: close handshake timed out", ErrClosed))
+		})
+	}
+
+	return false
+}
@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// opcode identifies the kind of a frame.
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xA
+)
+
+func (op opcode) control() bool {
+	return op&0x8 != 0
+}
+
+// maxControlPayload is the largest payload a control frame may carry.
+const maxControlPayload = 125
+
+// frameHeader is the part of a frame before its payload.
+type frameHeader struct {
+	fin    bool
+	op     opcode
+	masked bool
+	mask   [4]byte
+	length int64
+}
+
+// protocolError is a violation of RFC 6455 by the peer. The connection is closed with its status.
+type protocolError struct {
+	status StatusCode
+	reason string
+}
+
+func (e *protocolError) Error() string {
+	return fmt.Sprintf("websocket: protocol error (status: %d): %s", e.status, e.reason)
+}
+
+func protocolErr(status StatusCode, format string, args ...any) *protocolError {
+	return &protocolError{status: status, reason: fmt.Sprintf(format, args...)}
+}
+
+// readFrameHeader reads and validates a frame header sent by a client.
+func readFrameHeader(r *bufio.Reader) (frameHeader, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:2]); err != nil {
+		return frameHeader{}, err
+	}
+
+	h := frameHeader{
+		fin:    b[0]&0x80 != 0,
+		op:     opcode(b[0] & 0x0F),
+		masked: b[1]&0x80 != 0,
+		length: int64(b[1] & 0x7F),
+	}
+
+	if b[0]&0x70 != 0 {
+		return h, protocolErr(StatusProtocolError, "reserved bits set without an extension")
+	}
+
+	switch h.op {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return h, protocolErr(StatusProtocolError, "unknown opcode %d", h.op)
+	}
+
+	if !h.masked {
+		return h, protocolErr(StatusProtocolError, "client frames must be masked")
+	}
+
+	switch h.length {
+	case 126:
+		if _, err := io.ReadFull(r, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(r, b[:8]); err != nil {
+			return h, err
+		}
+		n := binary.BigEndian.Uint64(b[:8])
+		if n>>63 != 0 {
+			return h, protocolErr(StatusProtocolError, "invalid payload length")
+		}
+		h.length = int64(n)
+	}
+
+	if h.op.control() {
+		if !h.fin {
+			return h, protocolErr(StatusProtocolError, "fragmented control frame")
+		}
+		if h.length > maxControlPayload {
+			return h, protocolErr(StatusProtocolError, "control frame payload longer than %d bytes", maxControlPayload)
+		}
+	}
+
+	if _, err := io.ReadFull(r, h.mask[:]); err != nil {
+		return h, err
+	}
+
+	return h, nil
+}
+
+// readPayload reads and unmasks a frame's payload, appending it to buf.
+func readPayload(r *bufio.Reader, h frameHeader, buf []byte) ([]byte, error) {
+	start := len(buf)
+	buf = append(buf, make([]byte, h.length)...)
+	if _, err := io.ReadFull(r, buf[start:]); err != nil {
+		return buf, err
+	}
+
+	for i := range buf[start:] {
+		buf[start+i] ^= h.mask[i%4]
+	}
+	return buf, nil
+}
+
+// writeFrame writes an unmasked, unfragmented frame, as servers send them.
+func writeFrame(w *bufio.Writer, op opcode, payload []byte) error {
+	var b [10]byte
+	b[0] = 0x80 | byte(op)
+
+	n := 2
+	switch l := len(payload); {
+	case l <= 125:
+		b[1] = byte(l)
+	case l <= 0xFFFF:
+		b[1] = 126
+		binary.BigEndian.PutUint16(b[2:], uint16(l))
+		n += 2
+	default:
+		b[1] = 127
+		binary.BigEndian.PutUint64(b[2:], uint64(l))
+		n += 8
+	}
+
+	if _, err := w.Write(b[:n]); err != nil {
+		return err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// closePayload encodes a close frame's payload.
+func closePayload(status StatusCode, reason string) []byte {
+	if status == StatusNoStatus {
+		return nil
+	}
+
+	// Reasons are cut to fit in a control frame.
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+		for !utf8.ValidString(reason) {
+			reason = reason[:len(reason)-1]
+		}
+	}
+
+	b := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(b, uint16(status))
+	copy(b[2:], reason)
+	return b
+}
+
+// parseClosePayload decodes and validates a close frame's payload.
+func parseClosePayload(b []byte) (StatusCode, string, error) {
+	switch {
+	case len(b) == 0:
+		return StatusNoStatus, "", nil
+	case len(b) == 1:
+		return 0, "", protocolErr(StatusProtocolError, "close frame payload too short")
+	}
+
+	status := StatusCode(binary.BigEndian.Uint16(b))
+	if !status.valid() {
+		return 0, "", protocolErr(StatusProtocolError, "invalid close status %d", status)
+	}
+
+	reason := string(b[2:])
+	if !utf8.ValidString(reason) {
+		return 0, "", protocolErr(StatusInvalidPayload, "close reason isn't valid UTF-8")
+	}
+
+	return status, reason, nil
+}
@@ -0,0 +1,473 @@
+// Package websocket serves WebSocket connections (RFC 6455) from kit's router. A Server upgrades requests
+// and runs a HandlerFunc for each connection, keeping connections alive with pings and closing them all
+// when it shuts down:
+//
+//	ws := websocket.New(websocket.Options{})
+//
+//	app := web.NewApp().WithShutdown(ws).Route(func(r router.Router) {
+//		ws.Mount(r, "/events", func(ctx context.Context, c *websocket.Conn) {
+//			for {
+//				typ, msg, err := c.ReadMessage(ctx)
+//				if err != nil {
+//					return
+//				}
+//				c.Send(ctx, typ, msg)
+//			}
+//		})
+//	})
+//
+// The upgrade runs through the router's middleware like any other request, so the connection's context
+// carries the request ID and logger, and the 101 response carries any headers middleware set. Middleware
+// that buffers or times out responses, like middleware.WithTimeout, shouldn't wrap WebSocket routes.
+package websocket
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/rs/zerolog"
+)
+
+// StatusCode is the status sent in a close frame.
+type StatusCode int
+
+const (
+	StatusNormalClosure   StatusCode = 1000
+	StatusGoingAway       StatusCode = 1001
+	StatusProtocolError   StatusCode = 1002
+	StatusUnsupportedData StatusCode = 1003
+	StatusInvalidPayload  StatusCode = 1007
+	StatusPolicyViolation StatusCode = 1008
+	StatusMessageTooBig   StatusCode = 1009
+	StatusInternalError   StatusCode = 1011
+
+	// StatusNoStatus is reported when a close frame doesn't carry a status. It's never sent.
+	StatusNoStatus StatusCode = 1005
+
+	// StatusAbnormalClosure is reported when a connection ends without a close frame. It's never sent.
+	StatusAbnormalClosure StatusCode = 1006
+)
+
+// valid reports whether s may be sent in a close frame.
+func (s StatusCode) valid() bool {
+	switch {
+	case s >= 1000 && s <= 1003, s >= 1007 && s <= 1014:
+		return true
+	case s >= 3000 && s <= 4999:
+		return true
+	}
+	return false
+}
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	Text   MessageType = MessageType(opText)
+	Binary MessageType = MessageType(opBinary)
+)
+
+var (
+	// ErrClosed is returned when sending on a connection that's closed or closing.
+	ErrClosed = errors.New("websocket: connection closed")
+
+	// ErrBufferFull is returned by TrySend when a connection's send buffer is full.
+	ErrBufferFull = errors.New("websocket: send buffer full")
+)
+
+// CloseError is returned by ReadMessage once the peer has closed the connection.
+type CloseError struct {
+	Status StatusCode
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed (status: %d)", e.Status)
+	}
+	return fmt.Sprintf("websocket: closed (status: %d, reason: %s)", e.Status, e.Reason)
+}
+
+// CloseStatus returns the status err was closed with, StatusAbnormalClosure if the connection ended
+// without a close frame, or -1 if err is nil.
+func CloseStatus(err error) StatusCode {
+	if err == nil {
+		return -1
+	}
+
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		return ce.Status
+	}
+	return StatusAbnormalClosure
+}
+
+// Options configures a Server.
+type Options struct {
+	// Subprotocols lists the subprotocols the Server speaks, in order of preference. The first one the
+	// client also offers is chosen; see Conn.Subprotocol.
+	Subprotocols []string
+
+	// CheckOrigin decides whether requests from a browser's Origin may connect. Defaults to allowing
+	// requests without an Origin header and requests whose Origin matches their Host, since browsers
+	// don't apply the same-origin policy to WebSockets.
+	CheckOrigin func(r *http.Request) bool
+
+	// ReadLimit is the largest message a client may send. Larger messages close the connection with
+	// StatusMessageTooBig. Defaults to 1 MiB.
+	ReadLimit int64
+
+	// SendBuffer is how many messages can wait to be written to each connection. Once it's full, Send
+	// blocks and TrySend fails, so slow clients push back on whatever is sending to them. Defaults to 16.
+	SendBuffer int
+
+	// WriteTimeout caps how long writing a frame may take before the connection is considered dead.
+	// Defaults to 10 seconds.
+	WriteTimeout time.Duration
+
+	// PingInterval is how often the Server pings each connection. Connections that send nothing, not even
+	// a pong, for PingInterval plus PongTimeout are closed. Defaults to 30 seconds; a negative interval
+	// disables keepalive.
+	PingInterval time.Duration
+
+	// PongTimeout is how long a connection has to answer a ping. Defaults to PingInterval.
+	PongTimeout time.Duration
+
+	// CloseTimeout is how long to wait for the client to answer a close frame before dropping the
+	// connection. Defaults to 5 seconds.
+	CloseTimeout time.Duration
+}
+
+// Stats describes the current state of a Server.
+type Stats struct {
+	Connections int   `json:"connections"`
+	Accepted    int64 `json:"accepted"`
+	Rejected    int64 `json:"rejected"`
+}
+
+// HandlerFunc handles a connection. Its context carries the upgrade request's values and is cancelled
+// when the connection closes. The connection is closed with StatusNormalClosure when it returns.
+type HandlerFunc func(ctx context.Context, c *Conn)
+
+// Server upgrades requests to WebSocket connections and keeps track of them, so they can be closed when
+// the server shuts down.
+type Server struct {
+	opts Options
+
+	mu       sync.Mutex
+	conns    map[*Conn]struct{}
+	stopping bool
+	wg       sync.WaitGroup
+
+	accepted atomic.Int64
+	rejected atomic.Int64
+}
+
+// New returns a Server configured with opts.
+func New(opts Options) *Server {
+	if opts.CheckOrigin == nil {
+		opts.CheckOrigin = sameOrigin
+	}
+	if opts.ReadLimit <= 0 {
+		opts.ReadLimit = 1 << 20
+	}
+	if opts.SendBuffer <= 0 {
+		opts.SendBuffer = 16
+	}
+	if opts.WriteTimeout <= 0 {
+		opts.WriteTimeout = 10 * time.Second
+	}
+	if opts.PingInterval == 0 {
+		opts.PingInterval = 30 * time.Second
+	}
+	if opts.PongTimeout <= 0 {
+		opts.PongTimeout = opts.PingInterval
+	}
+	if opts.CloseTimeout <= 0 {
+		opts.CloseTimeout = 5 * time.Second
+	}
+
+	return &Server{
+		opts:  opts,
+		conns: map[*Conn]struct{}{},
+	}
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// Mount registers a GET route for pattern on r that upgrades requests and runs fn for each connection.
+func (s *Server) Mount(r router.Router, pattern string, fn HandlerFunc, mws ...router.Middleware) {
+	r.Get(pattern, s.Handler(fn), mws...)
+}
+
+// Handler returns an http.Handler that upgrades requests and runs fn for each connection. Requests that
+// aren't valid WebSocket handshakes are rejected with a 400 and the code "BAD_HANDSHAKE", or a 426 and
+// the code "UNSUPPORTED_VERSION" for versions other than 13. Requests from origins CheckOrigin rejects
+// get a 403 and the code "ORIGIN_NOT_ALLOWED", and requests made while the Server is shutting down get a
+// 503 and the code "SHUTTING_DOWN".
+func (s *Server) Handler(fn HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := zerolog.Ctx(ctx)
+
+		key, status, code, err := s.checkHandshake(r)
+		if err != nil {
+			s.rejected.Add(1)
+			resp := respond.CodedError(ctx, status, code, err)
+			if status == http.StatusUpgradeRequired {
+				resp.WithHeader(func(h http.Header) http.Header {
+					h.Set("Sec-WebSocket-Version", "13")
+					return h
+				})
+			}
+			resp.Write(w)
+			return
+		}
+
+		s.mu.Lock()
+		if s.stopping {
+			s.mu.Unlock()
+			s.rejected.Add(1)
+			respond.CodedError(ctx, http.StatusServiceUnavailable, "SHUTTING_DOWN", errors.New("server is shutting down")).Write(w)
+			return
+		}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		defer s.wg.Done()
+
+		subprotocol := s.subprotocol(r)
+
+		netConn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			log.Error().Err(err).Msg("websocket: couldn't hijack connection")
+			respond.CodedError(ctx, http.StatusInternalServerError, "WEBSOCKET_UNSUPPORTED", errors.New("connection can't be upgraded")).Write(w)
+			return
+		}
+
+		// Deadlines set by the http.Server apply to the request, not the connection it becomes.
+		netConn.SetDeadline(time.Time{})
+
+		h := w.Header().Clone()
+		h.Del("Content-Length")
+		h.Del("Transfer-Encoding")
+		h.Set("Upgrade", "websocket")
+		h.Set("Connection", "Upgrade")
+		h.Set("Sec-WebSocket-Accept", acceptKey(key))
+		if subprotocol != "" {
+			h.Set("Sec-WebSocket-Protocol", subprotocol)
+		}
+
+		netConn.SetWriteDeadline(time.Now().Add(s.opts.WriteTimeout))
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+		h.Write(brw)
+		brw.WriteString("\r\n")
+		if err := brw.Flush(); err != nil {
+			log.Warn().Err(err).Msg("websocket: couldn't complete handshake")
+			netConn.Close()
+			return
+		}
+		netConn.SetWriteDeadline(time.Time{})
+
+		// net/http cancels the request's context when a read from the hijacked connection fails, which
+		// would race with the connection recording why it failed, so the connection's context only keeps
+		// the request's values and is cancelled when the connection closes.
+		ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c := newConn(cancel, netConn, brw, subprotocol, s.opts)
+
+		if !s.track(c) {
+			c.closeWith(StatusGoingAway, "server shutting down", false)
+		}
+		defer s.untrack(c)
+
+		s.accepted.Add(1)
+		start := time.Now()
+		log.Info().Str("@ws.subprotocol", subprotocol).Msg("websocket: connection opened")
+
+		s.serve(ctx, c, fn)
+
+		log.Info().
+			Dur("@ws.dur", time.Since(start)).
+			Int("@ws.status", int(CloseStatus(c.err))).
+			Msg("websocket: connection closed")
+	})
+}
+
+// serve runs fn, then closes c and waits for it to finish closing.
+func (s *Server) serve(ctx context.Context, c *Conn, fn HandlerFunc) {
+	defer func() {
+		if p := recover(); p != nil {
+			zerolog.Ctx(ctx).Error().
+				Err(fmt.Errorf("panic: %v", p)).
+				Msg("websocket: recovered from panic")
+			c.closeWith(StatusInternalError, "", false)
+		}
+
+		c.Close(StatusNormalClosure, "")
+		close(c.handlerDone)
+		<-c.done
+		<-c.writerDone
+	}()
+
+	fn(ctx, c)
+}
+
+// checkHandshake validates an upgrade request, returning the client's key. If the request is invalid, it
+// returns the status and code to reject it with.
+func (s *Server) checkHandshake(r *http.Request) (string, int, string, error) {
+	if r.Method != http.MethodGet {
+		return "", http.StatusBadRequest, "BAD_HANDSHAKE", fmt.Errorf("method must be GET, not %s", r.Method)
+	}
+
+	if !r.ProtoAtLeast(1, 1) {
+		return "", http.StatusBadRequest, "BAD_HANDSHAKE", fmt.Errorf("protocol must be at least HTTP/1.1, not %s", r.Proto)
+	}
+
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return "", http.StatusBadRequest, "BAD_HANDSHAKE", errors.New("request isn't a websocket upgrade")
+	}
+
+	if v := r.Header.Get("Sec-WebSocket-Version"); v != "13" {
+		return "", http.StatusUpgradeRequired, "UNSUPPORTED_VERSION", fmt.Errorf("websocket version must be 13, not %q", v)
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return "", http.StatusBadRequest, "BAD_HANDSHAKE", errors.New("invalid Sec-WebSocket-Key header")
+	}
+
+	if !s.opts.CheckOrigin(r) {
+		return "", http.StatusForbidden, "ORIGIN_NOT_ALLOWED", fmt.Errorf("origin %q not allowed", r.Header.Get("Origin"))
+	}
+
+	return key, 0, "", nil
+}
+
+// subprotocol picks the most preferred subprotocol the client offered.
+func (s *Server) subprotocol(r *http.Request) string {
+	offered := headerTokens(r.Header, "Sec-WebSocket-Protocol")
+	for _, p := range s.opts.Subprotocols {
+		if slices.Contains(offered, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// headerTokens splits the comma-separated values of header k.
+func headerTokens(h http.Header, k string) []string {
+	var tokens []string
+	for _, v := range h.Values(k) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return tokens
+}
+
+func headerContains(h http.Header, k, token string) bool {
+	return slices.ContainsFunc(headerTokens(h, k), func(t string) bool {
+		return strings.EqualFold(t, token)
+	})
+}
+
+// acceptGUID is appended to the client's key to prove the server speaks WebSocket.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func (s *Server) track(c *Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conns[c] = struct{}{}
+	return !s.stopping
+}
+
+func (s *Server) untrack(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.conns, c)
+}
+
+// Stats returns the current state of the Server.
+func (s *Server) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return Stats{
+		Connections: len(s.conns),
+		Accepted:    s.accepted.Load(),
+		Rejected:    s.rejected.Load(),
+	}
+}
+
+// Name implements web.Shutdowner.
+func (s *Server) Name() string {
+	return "websocket"
+}
+
+// Shutdown implements web.Shutdowner. It stops accepting connections, closes open ones with
+// StatusGoingAway and waits for their handlers to return. If ctx is done first, the remaining connections
+// are dropped.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopping = true
+	conns := make([]*Conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.Close(StatusGoingAway, "server shutting down")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.mu.Lock()
+	for c := range s.conns {
+		c.fail(fmt.Errorf("%w: server shut down", ErrClosed))
+	}
+	s.mu.Unlock()
+
+	return ctx.Err()
+}
@@ -0,0 +1,465 @@
+package websocket_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/web"
+	"github.com/jimmysawczuk/kit/web/middleware"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/jimmysawczuk/kit/web/websocket"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+var _ web.Shutdowner = &websocket.Server{}
+
+const (
+	opText   = 0x1
+	opBinary = 0x2
+	opClose  = 0x8
+	opPing   = 0x9
+	opPong   = 0xA
+)
+
+// client is just enough of a WebSocket client to exercise the server.
+type client struct {
+	conn net.Conn
+	br   *bufio.Reader
+	resp *http.Response
+}
+
+func dial(t *testing.T, srv *httptest.Server, path string, header http.Header) *client {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	require.NoError(t, err)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	require.NoError(t, req.Write(conn))
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	require.NoError(t, err)
+
+	return &client{conn: conn, br: br, resp: resp}
+}
+
+func (c *client) writeFrame(t *testing.T, fin bool, op byte, payload []byte) {
+	t.Helper()
+
+	b := []byte{op, 0x80}
+	if fin {
+		b[0] |= 0x80
+	}
+
+	switch l := len(payload); {
+	case l <= 125:
+		b[1] |= byte(l)
+	case l <= 0xFFFF:
+		b[1] |= 126
+		b = binary.BigEndian.AppendUint16(b, uint16(l))
+	default:
+		b[1] |= 127
+		b = binary.BigEndian.AppendUint64(b, uint64(l))
+	}
+
+	mask := [4]byte{0x12, 0x34, 0x56, 0x78}
+	b = append(b, mask[:]...)
+	for i, p := range payload {
+		b = append(b, p^mask[i%4])
+	}
+
+	_, err := c.conn.Write(b)
+	require.NoError(t, err)
+}
+
+func (c *client) readFrame(t *testing.T) (byte, []byte) {
+	t.Helper()
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	var h [2]byte
+	_, err := io.ReadFull(c.br, h[:])
+	require.NoError(t, err)
+	require.Zero(t, h[1]&0x80, "server frames must not be masked")
+
+	n := uint64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		_, err = io.ReadFull(c.br, b[:])
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		_, err = io.ReadFull(c.br, b[:])
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	require.NoError(t, err)
+
+	payload := make([]byte, n)
+	_, err = io.ReadFull(c.br, payload)
+	require.NoError(t, err)
+
+	return h[0] & 0x0F, payload
+}
+
+// readClose reads frames until a close frame, returning its status.
+func (c *client) readClose(t *testing.T) (websocket.StatusCode, string) {
+	t.Helper()
+
+	for {
+		op, payload := c.readFrame(t)
+		if op != opClose {
+			continue
+		}
+		require.GreaterOrEqual(t, len(payload), 2)
+		return websocket.StatusCode(binary.BigEndian.Uint16(payload)), string(payload[2:])
+	}
+}
+
+func closeFrame(status websocket.StatusCode, reason string) []byte {
+	return append(binary.BigEndian.AppendUint16(nil, uint16(status)), reason...)
+}
+
+// syncBuffer is a bytes.Buffer that's safe to log to from the server's goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func echo(ctx context.Context, c *websocket.Conn) {
+	for {
+		typ, msg, err := c.ReadMessage(ctx)
+		if err != nil {
+			return
+		}
+		c.Send(ctx, typ, msg)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	ws := websocket.New(websocket.Options{})
+	h := ws.Handler(echo)
+
+	tests := []struct {
+		name       string
+		header     map[string]string
+		wantStatus int
+		wantCode   string
+	}{
+		{"NOT_AN_UPGRADE", map[string]string{"Upgrade": ""}, http.StatusBadRequest, "BAD_HANDSHAKE"},
+		{"BAD_KEY", map[string]string{"Sec-WebSocket-Key": "c2hvcnQ="}, http.StatusBadRequest, "BAD_HANDSHAKE"},
+		{"OLD_VERSION", map[string]string{"Sec-WebSocket-Version": "8"}, http.StatusUpgradeRequired, "UNSUPPORTED_VERSION"},
+		{"CROSS_ORIGIN", map[string]string{"Origin": "https://evil.example.com"}, http.StatusForbidden, "ORIGIN_NOT_ALLOWED"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+			r.Header.Set("Upgrade", "websocket")
+			r.Header.Set("Connection", "keep-alive, Upgrade")
+			r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+			r.Header.Set("Sec-WebSocket-Version", "13")
+			for k, v := range test.header {
+				r.Header.Set(k, v)
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			require.Equal(t, test.wantStatus, w.Code)
+
+			var resp struct {
+				Code string `json:"code"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			require.Equal(t, test.wantCode, resp.Code)
+
+			if test.wantStatus == http.StatusUpgradeRequired {
+				require.Equal(t, "13", w.Header().Get("Sec-WebSocket-Version"))
+			}
+		})
+	}
+
+	require.EqualValues(t, 4, ws.Stats().Rejected)
+}
+
+func TestConn(t *testing.T) {
+	var logs syncBuffer
+	log := zerolog.New(&logs)
+
+	ws := websocket.New(websocket.Options{
+		Subprotocols: []string{"v2.chat", "v1.chat"},
+		ReadLimit:    1024,
+	})
+
+	done := make(chan error, 1)
+	r := router.New()
+	r.Use(middleware.WithLogger(&log), middleware.RequestID, middleware.ProfileRequest)
+	ws.Mount(r, "/chat", func(ctx context.Context, c *websocket.Conn) {
+		for {
+			typ, msg, err := c.ReadMessage(ctx)
+			if err != nil {
+				done <- err
+				return
+			}
+			require.NoError(t, c.Send(ctx, typ, bytes.ToUpper(msg)))
+		}
+	})
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	c := dial(t, srv, "/chat", http.Header{
+		"X-Request-Id":           {"req-123"},
+		"Sec-Websocket-Protocol": {"v1.chat, v2.chat"},
+		"Origin":                 {srv.URL},
+	})
+
+	require.Equal(t, http.StatusSwitchingProtocols, c.resp.StatusCode)
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", c.resp.Header.Get("Sec-WebSocket-Accept"))
+	require.Equal(t, "v2.chat", c.resp.Header.Get("Sec-WebSocket-Protocol"))
+	require.Equal(t, "req-123", c.resp.Header.Get("X-Request-Id"))
+
+	c.writeFrame(t, true, opText, []byte("hello"))
+	op, payload := c.readFrame(t)
+	require.Equal(t, byte(opText), op)
+	require.Equal(t, "HELLO", string(payload))
+
+	// Control frames can arrive between fragments, and are answered straight away.
+	c.writeFrame(t, false, opBinary, []byte("frag"))
+	c.writeFrame(t, true, opPing, []byte("are you there"))
+	op, payload = c.readFrame(t)
+	require.Equal(t, byte(opPong), op)
+	require.Equal(t, "are you there", string(payload))
+
+	c.writeFrame(t, true, 0x0, []byte("mented"))
+	op, payload = c.readFrame(t)
+	require.Equal(t, byte(opBinary), op)
+	require.Equal(t, "FRAGMENTED", string(payload))
+
+	big := strings.Repeat("x", 300)
+	c.writeFrame(t, true, opText, []byte(big))
+	_, payload = c.readFrame(t)
+	require.Equal(t, strings.ToUpper(big), string(payload))
+
+	c.writeFrame(t, true, opClose, closeFrame(websocket.StatusGoingAway, "bye"))
+	status, _ := c.readClose(t)
+	require.Equal(t, websocket.StatusGoingAway, status)
+
+	err := <-done
+	require.Equal(t, websocket.StatusGoingAway, websocket.CloseStatus(err))
+	require.EqualError(t, err, "websocket: closed (status: 1001, reason: bye)")
+
+	// The server drops the connection once the handshake is done.
+	_, err = c.br.ReadByte()
+	require.ErrorIs(t, err, io.EOF)
+
+	require.Eventually(t, func() bool { return strings.Contains(logs.String(), "websocket: connection closed") }, time.Second, 10*time.Millisecond)
+
+	var opened map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		if entry["message"] == "websocket: connection opened" {
+			opened = entry
+		}
+	}
+	require.Equal(t, "req-123", opened["@id"])
+	require.Equal(t, "v2.chat", opened["@ws.subprotocol"])
+}
+
+func TestProtocolErrors(t *testing.T) {
+	ws := websocket.New(websocket.Options{ReadLimit: 16})
+	srv := httptest.NewServer(ws.Handler(echo))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		send   func(t *testing.T, c *client)
+		status websocket.StatusCode
+	}{
+		{"UNMASKED", func(t *testing.T, c *client) {
+			c.conn.Write([]byte{0x81, 0x02, 'h', 'i'})
+		}, websocket.StatusProtocolError},
+		{"UNKNOWN_OPCODE", func(t *testing.T, c *client) {
+			c.writeFrame(t, true, 0x3, nil)
+		}, websocket.StatusProtocolError},
+		{"UNEXPECTED_CONTINUATION", func(t *testing.T, c *client) {
+			c.writeFrame(t, true, 0x0, []byte("hi"))
+		}, websocket.StatusProtocolError},
+		{"INTERLEAVED_MESSAGES", func(t *testing.T, c *client) {
+			c.writeFrame(t, false, opText, []byte("hi"))
+			c.writeFrame(t, true, opText, []byte("hi"))
+		}, websocket.StatusProtocolError},
+		{"TOO_BIG", func(t *testing.T, c *client) {
+			c.writeFrame(t, false, opBinary, make([]byte, 10))
+			c.writeFrame(t, true, 0x0, make([]byte, 10))
+		}, websocket.StatusMessageTooBig},
+		{"INVALID_UTF8", func(t *testing.T, c *client) {
+			c.writeFrame(t, true, opText, []byte{0xff, 0xfe})
+		}, websocket.StatusInvalidPayload},
+		{"INVALID_CLOSE_STATUS", func(t *testing.T, c *client) {
+			c.writeFrame(t, true, opClose, closeFrame(websocket.StatusAbnormalClosure, ""))
+		}, websocket.StatusProtocolError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := dial(t, srv, "/", nil)
+			require.Equal(t, http.StatusSwitchingProtocols, c.resp.StatusCode)
+
+			test.send(t, c)
+
+			status, _ := c.readClose(t)
+			require.Equal(t, test.status, status)
+		})
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	ws := websocket.New(websocket.Options{SendBuffer: 1})
+
+	type result struct {
+		full, blocked error
+		buffered      int
+	}
+
+	results := make(chan result, 1)
+	srv := httptest.NewServer(ws.Handler(func(ctx context.Context, c *websocket.Conn) {
+		// The client never reads, so once the socket's buffers fill the writer stalls and so does the
+		// send buffer.
+		msg := make([]byte, 4<<20)
+
+		var res result
+		for range 64 {
+			if res.full = c.TrySend(websocket.Binary, msg); res.full != nil {
+				res.buffered = c.Buffered()
+				break
+			}
+		}
+
+		for range 64 {
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			res.blocked = c.Send(ctx, websocket.Binary, msg)
+			cancel()
+			if res.blocked != nil {
+				break
+			}
+		}
+
+		results <- res
+	}))
+	defer srv.Close()
+
+	dial(t, srv, "/", nil)
+
+	res := <-results
+	require.ErrorIs(t, res.full, websocket.ErrBufferFull)
+	require.Equal(t, 1, res.buffered)
+	require.ErrorIs(t, res.blocked, context.DeadlineExceeded)
+}
+
+func TestKeepalive(t *testing.T) {
+	ws := websocket.New(websocket.Options{PingInterval: 20 * time.Millisecond, PongTimeout: 20 * time.Millisecond})
+
+	result := make(chan error, 1)
+	srv := httptest.NewServer(ws.Handler(func(ctx context.Context, c *websocket.Conn) {
+		_, _, err := c.ReadMessage(ctx)
+		result <- err
+	}))
+	defer srv.Close()
+
+	c := dial(t, srv, "/", nil)
+
+	// Answering pings keeps the connection open.
+	for range 3 {
+		op, payload := c.readFrame(t)
+		require.Equal(t, byte(opPing), op)
+		c.writeFrame(t, true, opPong, payload)
+	}
+
+	select {
+	case err := <-result:
+		t.Fatalf("connection closed early: %v", err)
+	default:
+	}
+
+	// Ignoring them doesn't.
+	err := <-result
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	require.Equal(t, websocket.StatusAbnormalClosure, websocket.CloseStatus(err))
+}
+
+func TestShutdown(t *testing.T) {
+	ws := websocket.New(websocket.Options{})
+
+	started := make(chan struct{}, 2)
+	srv := httptest.NewServer(ws.Handler(func(ctx context.Context, c *websocket.Conn) {
+		started <- struct{}{}
+		echo(ctx, c)
+	}))
+	defer srv.Close()
+
+	polite := dial(t, srv, "/", nil)
+	rude := dial(t, srv, "/", nil)
+	<-started
+	<-started
+	require.Equal(t, 2, ws.Stats().Connections)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		shutdown <- ws.Shutdown(ctx)
+	}()
+
+	status, reason := polite.readClose(t)
+	require.Equal(t, websocket.StatusGoingAway, status)
+	require.Equal(t, "server shutting down", reason)
+	polite.writeFrame(t, true, opClose, closeFrame(status, ""))
+
+	// The other client never answers, so it's dropped when the shutdown deadline passes.
+	status, _ = rude.readClose(t)
+	require.Equal(t, websocket.StatusGoingAway, status)
+
+	require.ErrorIs(t, <-shutdown, context.DeadlineExceeded)
+	require.Eventually(t, func() bool { return ws.Stats().Connections == 0 }, time.Second, 10*time.Millisecond)
+
+	late := dial(t, srv, "/", nil)
+	require.Equal(t, http.StatusServiceUnavailable, late.resp.StatusCode)
+}
@@ -0,0 +1,163 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jimmysawczuk/kit/internal/httpwrap"
+	"github.com/jimmysawczuk/kit/web/idempotency"
+	"github.com/jimmysawczuk/kit/web/respond"
+	"github.com/jimmysawczuk/kit/web/router"
+	"github.com/rs/zerolog"
+)
+
+// VerifyOptions configures the verifying Middleware.
+type VerifyOptions struct {
+	// Secrets are the secrets a webhook may be signed with. During a rotation, list both the new and old
+	// secrets.
+	Secrets [][]byte
+
+	// Header is the request header carrying the signature. Defaults to DefaultHeader.
+	Header string
+
+	// Tolerance is how far a signature's timestamp may be from the current time. Defaults to 5 minutes.
+	Tolerance time.Duration
+
+	// MaxBodySize is the largest request body that will be verified. Defaults to 1 MiB.
+	MaxBodySize int64
+
+	// Replay, if set, remembers verified signatures until they're out of tolerance, rejecting requests that
+	// reuse one. Signatures of requests the handler fails with a 5xx are forgotten, so the sender's retry
+	// isn't mistaken for a replay. Any idempotency.Store works, i.e. idempotency.NewMemoryStore(), but only
+	// a shared store protects against requests replayed to another instance.
+	Replay idempotency.Store
+
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+func (o VerifyOptions) header() string {
+	if o.Header == "" {
+		return DefaultHeader
+	}
+	return o.Header
+}
+
+func (o VerifyOptions) tolerance() time.Duration {
+	if o.Tolerance <= 0 {
+		return 5 * time.Minute
+	}
+	return o.Tolerance
+}
+
+func (o VerifyOptions) maxBodySize() int64 {
+	if o.MaxBodySize <= 0 {
+		return 1 << 20
+	}
+	return o.MaxBodySize
+}
+
+func (o VerifyOptions) now() time.Time {
+	if o.Now != nil {
+		return o.Now()
+	}
+	return time.Now()
+}
+
+// Middleware rejects requests that don't carry a valid, current signature of their body with a 401 and
+// the code "INVALID_SIGNATURE", and requests replaying a signature that's already been seen with a 409
+// and the code "WEBHOOK_REPLAYED". The body is restored for the handler once it's verified.
+func Middleware(opts VerifyOptions) router.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			log := zerolog.Ctx(ctx)
+
+			var body []byte
+			if r.Body != nil && r.Body != http.NoBody {
+				b, err := io.ReadAll(io.LimitReader(r.Body, opts.maxBodySize()+1))
+				if err != nil {
+					respond.CodedError(ctx, http.StatusBadRequest, "INVALID_BODY", fmt.Errorf("read body: %w", err)).Write(w)
+					return
+				}
+				if int64(len(b)) > opts.maxBodySize() {
+					respond.CodedError(ctx, http.StatusRequestEntityTooLarge, "REQUEST_TOO_LARGE", fmt.Errorf("request body must be at most %d bytes", opts.maxBodySize())).Write(w)
+					return
+				}
+
+				body = b
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+
+			sig, err := Verify(r.Header.Get(opts.header()), body, opts.now(), opts.tolerance(), opts.Secrets...)
+			if err != nil {
+				log.Warn().Err(err).
+					Str("@req.method", r.Method).
+					Str("@req.path", r.URL.Path).
+					Msg("webhook: request rejected")
+
+				respond.CodedError(ctx, http.StatusUnauthorized, "INVALID_SIGNATURE", err).Write(w)
+				return
+			}
+
+			if opts.Replay == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// The key is derived from what was signed rather than the header, which the sender can pad with
+			// other signatures or strip down to one during a rotation. Signed timestamps expire with the
+			// tolerance, so that's as long as keys need to be remembered.
+			sum := sha256.Sum256(body)
+			key := "webhook|" + strconv.FormatInt(sig.Timestamp.Unix(), 10) + "|" + hex.EncodeToString(sum[:])
+
+			_, token, err := opts.Replay.Lock(ctx, key, key, 2*opts.tolerance())
+			if err != nil {
+				log.Error().Err(err).Msg("webhook: couldn't check for replay")
+				respond.CodedError(ctx, http.StatusServiceUnavailable, "WEBHOOK_UNAVAILABLE", errors.New("couldn't check for replayed webhook")).Write(w)
+				return
+			}
+
+			if token == "" {
+				log.Warn().
+					Str("@req.method", r.Method).
+					Str("@req.path", r.URL.Path).
+					Msg("webhook: replay rejected")
+
+				respond.CodedError(ctx, http.StatusConflict, "WEBHOOK_REPLAYED", errors.New("webhook was already received")).Write(w)
+				return
+			}
+
+			defer func() {
+				// Forget the signature even if the handler panics, then let the panic carry on.
+				if p := recover(); p != nil {
+					forget(opts.Replay, key, token, log)
+					panic(p)
+				}
+			}()
+
+			sw := httpwrap.NewStatusWriter(w)
+			next.ServeHTTP(sw, r)
+
+			// The webhook wasn't processed, so the sender's retry, which may well be signed with the same
+			// timestamp, should be too.
+			if sw.Status >= 500 {
+				forget(opts.Replay, key, token, log)
+			}
+		})
+	}
+}
+
+func forget(replay idempotency.Store, key, token string, log *zerolog.Logger) {
+	if err := replay.Unlock(context.Background(), key, token); err != nil {
+		log.Error().Err(err).Msg("webhook: couldn't forget signature")
+	}
+}
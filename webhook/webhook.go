@@ -0,0 +1,157 @@
+// Package webhook signs and verifies webhooks, and delivers them reliably.
+//
+// Payloads are signed with HMAC-SHA256 over the delivery's timestamp and body, and the signature is sent
+// in a header like Stripe's:
+//
+//	Webhook-Signature: t=1700000000,v1=5257a869e7ecebeda32affa62cdca3fa51cad7e77a0e56ff536d0ce8e108d8bd
+//
+// Secrets are rotated by adding the new secret alongside the old one. While both are configured, senders
+// sign with both, so receivers holding either can verify, and receivers accept either. Once every
+// receiver has the new secret, the old one is removed.
+//
+// Middleware verifies inbound webhooks, and a Worker sends outbound ones, retrying failed deliveries and
+// handing those that never succeed to a dead-letter callback.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultHeader is the header carrying a webhook's signature.
+const DefaultHeader = "Webhook-Signature"
+
+// IDHeader is the header carrying a delivery's ID, which stays the same across retries so receivers can
+// deduplicate deliveries.
+const IDHeader = "Webhook-Id"
+
+// scheme is the key of signatures in the header. It names the signing scheme, so another can be added
+// without breaking receivers that don't understand it.
+const scheme = "v1"
+
+var (
+	// ErrNoSignature is returned when a request doesn't carry a signature header.
+	ErrNoSignature = errors.New("webhook: no signature")
+
+	// ErrMalformedSignature is returned when a signature header can't be parsed.
+	ErrMalformedSignature = errors.New("webhook: malformed signature")
+
+	// ErrSignatureMismatch is returned when none of a header's signatures match the payload for any of the
+	// secrets.
+	ErrSignatureMismatch = errors.New("webhook: signature mismatch")
+
+	// ErrTimestampOutOfRange is returned when a signature's timestamp is too far from the current time.
+	ErrTimestampOutOfRange = errors.New("webhook: timestamp out of range")
+
+	// ErrNoSecrets is returned when signing or verifying without any secrets.
+	ErrNoSecrets = errors.New("webhook: no secrets")
+)
+
+// Signature is a parsed signature header.
+type Signature struct {
+	Timestamp time.Time
+
+	// Signatures are the v1 signatures in the header; there's one for each secret it was signed with.
+	Signatures [][]byte
+}
+
+// Sign returns a signature header value for payload sent at t, with a signature for each secret.
+func Sign(payload []byte, t time.Time, secrets ...[]byte) (string, error) {
+	if len(secrets) == 0 {
+		return "", ErrNoSecrets
+	}
+
+	ts := t.Unix()
+
+	var sb strings.Builder
+	sb.WriteString("t=" + strconv.FormatInt(ts, 10))
+	for _, secret := range secrets {
+		sb.WriteString("," + scheme + "=" + hex.EncodeToString(mac(secret, ts, payload)))
+	}
+
+	return sb.String(), nil
+}
+
+// ParseHeader parses a signature header value. Signatures using schemes other than v1 are ignored.
+func ParseHeader(v string) (Signature, error) {
+	var sig Signature
+	if strings.TrimSpace(v) == "" {
+		return sig, ErrNoSignature
+	}
+
+	for _, part := range strings.Split(v, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return Signature{}, fmt.Errorf("%w: %q isn't a key-value pair", ErrMalformedSignature, part)
+		}
+
+		switch key {
+		case "t":
+			ts, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return Signature{}, fmt.Errorf("%w: invalid timestamp: %w", ErrMalformedSignature, err)
+			}
+			sig.Timestamp = time.Unix(ts, 0)
+		case scheme:
+			b, err := hex.DecodeString(value)
+			if err != nil || len(b) != sha256.Size {
+				return Signature{}, fmt.Errorf("%w: invalid %s signature", ErrMalformedSignature, scheme)
+			}
+			sig.Signatures = append(sig.Signatures, b)
+		}
+	}
+
+	if sig.Timestamp.IsZero() {
+		return Signature{}, fmt.Errorf("%w: no timestamp", ErrMalformedSignature)
+	}
+	if len(sig.Signatures) == 0 {
+		return Signature{}, fmt.Errorf("%w: no %s signatures", ErrMalformedSignature, scheme)
+	}
+
+	return sig, nil
+}
+
+// Verify checks that header is a valid signature of payload for at least one of secrets, made within
+// tolerance of now. A tolerance of zero skips the timestamp check. It returns the parsed header.
+func Verify(header string, payload []byte, now time.Time, tolerance time.Duration, secrets ...[]byte) (Signature, error) {
+	if len(secrets) == 0 {
+		return Signature{}, ErrNoSecrets
+	}
+
+	sig, err := ParseHeader(header)
+	if err != nil {
+		return Signature{}, err
+	}
+
+	if tolerance > 0 {
+		if d := now.Sub(sig.Timestamp); d > tolerance || d < -tolerance {
+			return Signature{}, fmt.Errorf("%w (timestamp: %d)", ErrTimestampOutOfRange, sig.Timestamp.Unix())
+		}
+	}
+
+	for _, secret := range secrets {
+		expected := mac(secret, sig.Timestamp.Unix(), payload)
+		for _, s := range sig.Signatures {
+			if hmac.Equal(s, expected) {
+				return sig, nil
+			}
+		}
+	}
+
+	return Signature{}, ErrSignatureMismatch
+}
+
+// mac signs the timestamp and payload, joined by a dot, with secret.
+func mac(secret []byte, ts int64, payload []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(strconv.FormatInt(ts, 10)))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return h.Sum(nil)
+}
@@ -0,0 +1,307 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jimmysawczuk/kit/httpclient"
+	"github.com/jimmysawczuk/kit/retry"
+	"github.com/jimmysawczuk/kit/web"
+	"github.com/jimmysawczuk/kit/web/idempotency"
+	"github.com/jimmysawczuk/kit/webhook"
+	"github.com/stretchr/testify/require"
+)
+
+var _ web.Shutdowner = &webhook.Worker{}
+
+var (
+	oldSecret = []byte("whsec_old")
+	newSecret = []byte("whsec_new")
+)
+
+func TestSignVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	payload := []byte(`{"event":"payment.succeeded"}`)
+
+	signed, err := webhook.Sign(payload, now, newSecret, oldSecret)
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(signed, "t=1700000000,v1="))
+	require.Equal(t, 2, strings.Count(signed, "v1="))
+
+	onlyOld, err := webhook.Sign(payload, now, oldSecret)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		header  string
+		payload []byte
+		now     time.Time
+		secrets [][]byte
+		wantErr error
+	}{
+		{"VALID", signed, payload, now, [][]byte{newSecret}, nil},
+		{"ROTATING_RECEIVER", onlyOld, payload, now, [][]byte{newSecret, oldSecret}, nil},
+		{"ROTATING_SENDER", signed, payload, now, [][]byte{oldSecret}, nil},
+		{"WITHIN_TOLERANCE", signed, payload, now.Add(4 * time.Minute), [][]byte{newSecret}, nil},
+		{"TOO_OLD", signed, payload, now.Add(6 * time.Minute), [][]byte{newSecret}, webhook.ErrTimestampOutOfRange},
+		{"TOO_NEW", signed, payload, now.Add(-6 * time.Minute), [][]byte{newSecret}, webhook.ErrTimestampOutOfRange},
+		{"WRONG_SECRET", onlyOld, payload, now, [][]byte{newSecret}, webhook.ErrSignatureMismatch},
+		{"TAMPERED", signed, []byte(`{"event":"payment.refunded"}`), now, [][]byte{newSecret}, webhook.ErrSignatureMismatch},
+		{"TAMPERED_TIMESTAMP", strings.Replace(signed, "t=1700000000", "t=1700000001", 1), payload, now, [][]byte{newSecret}, webhook.ErrSignatureMismatch},
+		{"UNKNOWN_SCHEME", "t=1700000000,v0=abc", payload, now, [][]byte{newSecret}, webhook.ErrMalformedSignature},
+		{"NO_TIMESTAMP", strings.TrimPrefix(signed, "t=1700000000,"), payload, now, [][]byte{newSecret}, webhook.ErrMalformedSignature},
+		{"BAD_HEX", "t=1700000000,v1=zz", payload, now, [][]byte{newSecret}, webhook.ErrMalformedSignature},
+		{"MISSING", "", payload, now, [][]byte{newSecret}, webhook.ErrNoSignature},
+		{"NO_SECRETS", signed, payload, now, nil, webhook.ErrNoSecrets},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sig, err := webhook.Verify(test.header, test.payload, test.now, 5*time.Minute, test.secrets...)
+			if test.wantErr != nil {
+				require.ErrorIs(t, err, test.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, now, sig.Timestamp)
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	h := webhook.Middleware(webhook.VerifyOptions{
+		Secrets:     [][]byte{newSecret, oldSecret},
+		MaxBodySize: 64,
+		Replay:      idempotency.NewMemoryStore(),
+		Now:         func() time.Time { return now },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+
+	do := func(body, sig string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+		if sig != "" {
+			r.Header.Set(webhook.DefaultHeader, sig)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	code := func(w *httptest.ResponseRecorder) string {
+		var resp struct {
+			Code string `json:"code"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return resp.Code
+	}
+
+	body := `{"event":"payment.succeeded"}`
+	sig, err := webhook.Sign([]byte(body), now, oldSecret)
+	require.NoError(t, err)
+
+	w := do(body, sig)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, body, w.Body.String())
+
+	w = do(body, sig)
+	require.Equal(t, http.StatusConflict, w.Code)
+	require.Equal(t, "WEBHOOK_REPLAYED", code(w))
+
+	// Padding the header with other signatures, or dropping one during a rotation, is still a replay.
+	junk := "v1=" + strings.Repeat("ab", 32)
+	both, err := webhook.Sign([]byte(body), now, oldSecret, newSecret)
+	require.NoError(t, err)
+	for _, replayed := range []string{
+		strings.Replace(sig, "t=1700000000,", "t=1700000000,"+junk+",", 1),
+		sig + "," + junk,
+		both,
+	} {
+		w = do(body, replayed)
+		require.Equal(t, http.StatusConflict, w.Code, replayed)
+		require.Equal(t, "WEBHOOK_REPLAYED", code(w))
+	}
+
+	// The same payload signed later isn't a replay.
+	later, err := webhook.Sign([]byte(body), now.Add(time.Second), newSecret)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, do(body, later).Code)
+
+	w = do(`{"event":"payment.refunded"}`, sig)
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, "INVALID_SIGNATURE", code(w))
+
+	w = do(body, "")
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	require.Equal(t, "INVALID_SIGNATURE", code(w))
+
+	large := strings.Repeat("x", 65)
+	largeSig, err := webhook.Sign([]byte(large), now, newSecret)
+	require.NoError(t, err)
+	w = do(large, largeSig)
+	require.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	require.Equal(t, "REQUEST_TOO_LARGE", code(w))
+}
+
+func TestMiddlewareRetryAfterFailure(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	var calls atomic.Int32
+	h := webhook.Middleware(webhook.VerifyOptions{
+		Secrets: [][]byte{newSecret},
+		Replay:  idempotency.NewMemoryStore(),
+		Now:     func() time.Time { return now },
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+
+	body := `{"event":"payment.succeeded"}`
+	sig, err := webhook.Sign([]byte(body), now, newSecret)
+	require.NoError(t, err)
+
+	do := func() int {
+		r := httptest.NewRequest(http.MethodPost, "/webhooks", strings.NewReader(body))
+		r.Header.Set(webhook.DefaultHeader, sig)
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	// A retry with the same signature is handled if the first attempt failed, but not once it succeeds.
+	require.Equal(t, http.StatusServiceUnavailable, do())
+	require.Equal(t, http.StatusOK, do())
+	require.Equal(t, http.StatusConflict, do())
+	require.EqualValues(t, 2, calls.Load())
+}
+
+func TestWorker(t *testing.T) {
+	var attempts sync.Map
+
+	var verify http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(webhook.IDHeader)
+		n, _ := attempts.LoadOrStore(id, &atomic.Int32{})
+		attempt := n.(*atomic.Int32).Add(1)
+
+		switch {
+		case strings.HasPrefix(id, "flaky") && attempt < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case strings.HasPrefix(id, "down"):
+			w.WriteHeader(http.StatusBadGateway)
+		case strings.HasPrefix(id, "rejected"):
+			http.Error(w, "unknown event", http.StatusBadRequest)
+		default:
+			require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	srv := httptest.NewServer(webhook.Middleware(webhook.VerifyOptions{Secrets: [][]byte{newSecret}})(verify))
+	defer srv.Close()
+
+	var (
+		mu   sync.Mutex
+		dead = map[string]error{}
+	)
+
+	worker := webhook.NewWorker(webhook.WorkerOptions{
+		Secrets: [][]byte{newSecret},
+		Retry:   retry.Policy{MaxAttempts: 3, BaseDelay: time.Millisecond},
+		DeadLetter: func(ctx context.Context, d webhook.Delivery, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			dead[d.ID] = err
+		},
+	})
+	worker.Start(nil)
+	worker.Start(nil) // does nothing
+
+	for _, id := range []string{"ok", "flaky", "down", "rejected"} {
+		require.NoError(t, worker.Enqueue(webhook.Delivery{ID: id, URL: srv.URL, Payload: []byte(`{"id":"` + id + `"}`)}))
+	}
+
+	require.NoError(t, worker.Shutdown(context.Background()))
+	require.ErrorIs(t, worker.Enqueue(webhook.Delivery{ID: "late"}), webhook.ErrStopped)
+
+	count := func(id string) int32 {
+		n, _ := attempts.Load(id)
+		return n.(*atomic.Int32).Load()
+	}
+
+	require.EqualValues(t, 1, count("ok"))
+	require.EqualValues(t, 3, count("flaky"))
+	require.EqualValues(t, 3, count("down"))
+	require.EqualValues(t, 1, count("rejected"))
+
+	require.Len(t, dead, 2)
+	require.True(t, httpclient.HasStatus(dead["down"], http.StatusBadGateway))
+	require.ErrorContains(t, dead["down"], "retry: gave up after 3 attempts")
+	require.True(t, httpclient.HasStatus(dead["rejected"], http.StatusBadRequest))
+	require.ErrorContains(t, dead["rejected"], "unknown event")
+
+	require.Equal(t, webhook.WorkerStats{Delivered: 2, Retried: 4, Failed: 2}, worker.Stats())
+}
+
+func TestWorkerShutdown(t *testing.T) {
+	started := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	var failed []error
+	worker := webhook.NewWorker(webhook.WorkerOptions{
+		Secrets: [][]byte{newSecret},
+		Workers: 1,
+		DeadLetter: func(ctx context.Context, d webhook.Delivery, err error) {
+			failed = append(failed, err)
+		},
+	})
+
+	// A Worker that never started hands its queue straight to DeadLetter.
+	require.NoError(t, worker.Enqueue(webhook.Delivery{ID: "queued", URL: srv.URL}))
+	require.NoError(t, worker.Shutdown(context.Background()))
+	require.Len(t, failed, 1)
+	require.ErrorIs(t, failed[0], webhook.ErrStopped)
+
+	// A started Worker cancels deliveries in progress once the shutdown deadline passes.
+	failed = nil
+	worker = webhook.NewWorker(webhook.WorkerOptions{
+		Secrets: [][]byte{newSecret},
+		Workers: 1,
+		DeadLetter: func(ctx context.Context, d webhook.Delivery, err error) {
+			failed = append(failed, err)
+		},
+	})
+	worker.Start(nil)
+
+	require.NoError(t, worker.Enqueue(webhook.Delivery{ID: "slow", URL: srv.URL}))
+	require.NoError(t, worker.Enqueue(webhook.Delivery{ID: "waiting", URL: srv.URL}))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := worker.Shutdown(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Len(t, failed, 2)
+	for _, err := range failed {
+		require.ErrorIs(t, err, context.Canceled)
+	}
+}
@@ -0,0 +1,341 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jimmysawczuk/kit/httpclient"
+	"github.com/jimmysawczuk/kit/retry"
+	"github.com/rs/zerolog"
+)
+
+var (
+	// ErrQueueFull is returned by Enqueue when the Worker's queue is full.
+	ErrQueueFull = errors.New("webhook: queue full")
+
+	// ErrStopped is returned by Enqueue after Shutdown, and passed to DeadLetter for deliveries that were
+	// still queued when a Worker that was never started shut down.
+	ErrStopped = errors.New("webhook: worker stopped")
+)
+
+// Delivery is a webhook to send.
+type Delivery struct {
+	// ID identifies the delivery to its receiver, which gets it in the Webhook-Id header on every attempt.
+	ID string
+
+	URL     string
+	Payload []byte
+
+	// Header holds extra request headers. Content-Type defaults to "application/json".
+	Header http.Header
+}
+
+// WorkerOptions configures a Worker.
+type WorkerOptions struct {
+	// Secrets are used to sign every delivery; see Sign. During a rotation, list both the new and old
+	// secrets.
+	Secrets [][]byte
+
+	// Header is the request header carrying the signature. Defaults to DefaultHeader.
+	Header string
+
+	// Client sends deliveries. Defaults to an httpclient that propagates request IDs and trace context and
+	// logs each attempt.
+	Client *http.Client
+
+	// Timeout caps each attempt. Defaults to 10 seconds.
+	Timeout time.Duration
+
+	// Retry decides how failed deliveries are retried. Its Retryable defaults to retrying network errors,
+	// 408, 429 and 5xx responses. Defaults to 5 attempts, backing off from 1 second to 1 minute.
+	Retry retry.Policy
+
+	// Workers is how many deliveries are sent at once. Defaults to 4.
+	Workers int
+
+	// QueueSize is how many deliveries can wait to be sent. Defaults to 1024.
+	QueueSize int
+
+	// DeadLetter, if set, is called with deliveries that couldn't be sent and the last error, i.e. to store
+	// them for inspection or a later retry. The context carries the Worker's logger. Otherwise they're
+	// logged and dropped.
+	DeadLetter func(ctx context.Context, d Delivery, err error)
+
+	// Now returns the current time, used to timestamp signatures. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// WorkerStats describes the current state of a Worker.
+type WorkerStats struct {
+	Queued    int   `json:"queued"`
+	Delivered int64 `json:"delivered"`
+	Retried   int64 `json:"retried"`
+	Failed    int64 `json:"failed"`
+}
+
+// Worker sends deliveries in the background, retrying failed ones. Deliveries are kept in memory, so
+// anything still queued when the process exits is lost; use DeadLetter and Shutdown to keep track of them.
+type Worker struct {
+	opts WorkerOptions
+
+	queue     chan Delivery
+	delivered atomic.Int64
+	retried   atomic.Int64
+	failed    atomic.Int64
+
+	mu      sync.RWMutex
+	started bool
+	stopped bool
+	log     *zerolog.Logger
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewWorker returns a Worker configured with opts. Call Start to begin sending.
+func NewWorker(opts WorkerOptions) *Worker {
+	if opts.Header == "" {
+		opts.Header = DefaultHeader
+	}
+	if opts.Client == nil {
+		opts.Client = httpclient.New(nil, httpclient.Propagate, httpclient.Log)
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.Retry.MaxAttempts <= 0 {
+		opts.Retry.MaxAttempts = 5
+	}
+	if opts.Retry.BaseDelay <= 0 {
+		opts.Retry.BaseDelay = time.Second
+	}
+	if opts.Retry.MaxDelay <= 0 {
+		opts.Retry.MaxDelay = time.Minute
+	}
+	if opts.Retry.Retryable == nil {
+		opts.Retry.Retryable = retryable
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 4
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = 1024
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+
+	nop := zerolog.Nop()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Worker{
+		opts:   opts,
+		queue:  make(chan Delivery, opts.QueueSize),
+		log:    &nop,
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+}
+
+// retryable retries network errors and responses that suggest the receiver may accept the delivery later.
+func retryable(err error) bool {
+	var herr *httpclient.Error
+	if errors.As(err, &herr) {
+		return herr.Status == http.StatusRequestTimeout || herr.Status == http.StatusTooManyRequests || herr.Status >= 500
+	}
+	return err != nil
+}
+
+// Enqueue queues d to be sent. It never blocks; if the queue is full it returns ErrQueueFull.
+func (w *Worker) Enqueue(d Delivery) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.stopped {
+		return ErrStopped
+	}
+
+	select {
+	case w.queue <- d:
+		return nil
+	default:
+		return fmt.Errorf("%w (id: %s)", ErrQueueFull, d.ID)
+	}
+}
+
+// Deliver sends d, retrying according to the Worker's Retry policy, and returns the last error if it
+// couldn't be sent. It doesn't call DeadLetter.
+func (w *Worker) Deliver(ctx context.Context, d Delivery) error {
+	p := w.opts.Retry
+	onRetry := p.OnRetry
+	p.OnRetry = func(ctx context.Context, attempt int, err error, delay time.Duration) {
+		w.retried.Add(1)
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Str("@webhook.id", d.ID).
+			Int("attempt", attempt).
+			Dur("delay", delay).
+			Msg("webhook: delivery attempt failed")
+
+		if onRetry != nil {
+			onRetry(ctx, attempt, err, delay)
+		}
+	}
+
+	if err := p.Do(ctx, func(ctx context.Context) error { return w.send(ctx, d) }); err != nil {
+		return fmt.Errorf("webhook: deliver (id: %s): %w", d.ID, err)
+	}
+	return nil
+}
+
+// send makes a single attempt at delivering d, signed as of now.
+func (w *Worker) send(ctx context.Context, d Delivery) error {
+	ctx, cancel := context.WithTimeout(ctx, w.opts.Timeout)
+	defer cancel()
+
+	sig, err := Sign(d.Payload, w.opts.Now(), w.opts.Secrets...)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return fmt.Errorf("http: new request: %w", err)
+	}
+
+	for k, v := range d.Header {
+		req.Header[k] = v
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if d.ID != "" {
+		req.Header.Set(IDHeader, d.ID)
+	}
+	req.Header.Set(w.opts.Header, sig)
+
+	resp, err := w.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("http: do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return httpclient.DecodeError(resp)
+	}
+
+	io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// Start sends queued deliveries until Shutdown is called. Deliveries that fail are logged to log. Starting
+// a Worker that's already started does nothing.
+func (w *Worker) Start(log *zerolog.Logger) {
+	if log == nil {
+		nop := zerolog.Nop()
+		log = &nop
+	}
+
+	w.mu.Lock()
+	if w.started {
+		w.mu.Unlock()
+		return
+	}
+	w.started = true
+	w.log = log
+	w.mu.Unlock()
+
+	ctx := log.WithContext(w.ctx)
+
+	var wg sync.WaitGroup
+	for range w.opts.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range w.queue {
+				w.process(ctx, d)
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(w.done)
+	}()
+}
+
+func (w *Worker) process(ctx context.Context, d Delivery) {
+	err := w.Deliver(ctx, d)
+	if err == nil {
+		w.delivered.Add(1)
+		return
+	}
+
+	w.deadLetter(ctx, d, err)
+}
+
+func (w *Worker) deadLetter(ctx context.Context, d Delivery, err error) {
+	w.failed.Add(1)
+
+	if w.opts.DeadLetter != nil {
+		w.opts.DeadLetter(context.WithoutCancel(ctx), d, err)
+		return
+	}
+
+	zerolog.Ctx(ctx).Error().Err(err).
+		Str("@webhook.id", d.ID).
+		Str("@webhook.url", d.URL).
+		Msg("webhook: delivery failed")
+}
+
+// Stats returns the current state of the Worker.
+func (w *Worker) Stats() WorkerStats {
+	return WorkerStats{
+		Queued:    len(w.queue),
+		Delivered: w.delivered.Load(),
+		Retried:   w.retried.Load(),
+		Failed:    w.failed.Load(),
+	}
+}
+
+// Name implements web.Shutdowner.
+func (w *Worker) Name() string {
+	return "webhook-worker"
+}
+
+// Shutdown implements web.Shutdowner. It stops accepting deliveries and waits for the queue to drain. If
+// ctx is done first, deliveries in progress are cancelled and everything left goes to DeadLetter.
+func (w *Worker) Shutdown(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.stopped {
+		w.stopped = true
+		close(w.queue)
+	}
+	started, log := w.started, w.log
+	w.mu.Unlock()
+
+	if !started {
+		dctx := log.WithContext(context.Background())
+		for d := range w.queue {
+			w.deadLetter(dctx, d, ErrStopped)
+		}
+		return nil
+	}
+
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	w.cancel()
+	<-w.done
+	return ctx.Err()
+}